@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"github.com/purini-to/plixy/pkg/plugin"
+	"github.com/purini-to/plixy/pkg/plugin/external"
 	"github.com/purini-to/plixy/pkg/stats"
 	"github.com/purini-to/plixy/pkg/trace"
 
@@ -58,3 +60,16 @@ func initExporter() error {
 
 	return nil
 }
+
+func initPlugin() (*external.Supervisor, error) {
+	if config.Global.Plugin.Dir != "" {
+		if err := plugin.OpenDir(config.Global.Plugin.Dir); err != nil {
+			return nil, errors.Wrap(err, "could not load plugins")
+		}
+	}
+	s, err := external.New(config.Global.Plugin.External)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not register external plugins")
+	}
+	return s, nil
+}
@@ -29,6 +29,7 @@ This is a lightweight API Gateway.`,
 	viper.BindPFlag("Debug", cmd.PersistentFlags().Lookup("debug"))
 
 	cmd.AddCommand(NewStartCmd(ctx))
+	cmd.AddCommand(NewSchemaCmd())
 
 	return cmd
 }
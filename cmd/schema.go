@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+// NewSchemaCmd creates a new command printing the JSON Schema of the plugin configs
+func NewSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema [plugin]",
+		Short: "Prints the JSON Schema of the plugin configs for editor completion",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return RunSchema(args)
+		},
+	}
+}
+
+// RunSchema prints the schema of the api definition plugins, or of the config of a plugin
+func RunSchema(args []string) error {
+	if err := initConfig(""); err != nil {
+		return errors.Wrap(err, "failed initialize config")
+	}
+	if _, err := initPlugin(); err != nil {
+		return errors.Wrap(err, "failed initialize plugin")
+	}
+
+	schema := plugin.DefinitionSchema()
+	if len(args) > 0 {
+		s, err := plugin.ConfigSchema(args[0])
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return errors.New(fmt.Sprintf("plugin has no config schema. name: %s", args[0]))
+		}
+		schema = s
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
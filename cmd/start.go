@@ -69,9 +69,18 @@ func RunServerStart(ctx context.Context, ops *StartOptions) error {
 	if err := initExporter(); err != nil {
 		return errors.Wrap(err, "failed initialize exporter")
 	}
+	plugins, err := initPlugin()
+	if err != nil {
+		return errors.Wrap(err, "failed initialize plugin")
+	}
 
 	ctx = ContextWithSignal(ctx)
 
+	if err := plugins.Start(); err != nil {
+		return errors.Wrap(err, "could not start external plugins")
+	}
+	defer plugins.Close()
+
 	if config.Global.Stats.Enable {
 		err := stats.Start(ctx)
 		if err != nil {
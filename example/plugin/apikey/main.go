@@ -0,0 +1,84 @@
+// Command apikey is an example of the external plugin process.
+// It rejects the requests without the api key of the plugin config.
+//
+//	plugin:
+//	  external:
+//	    - name: apikey
+//	      command: ./apikey
+//	      address: unix:/tmp/plixy-apikey.sock
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	pb "github.com/purini-to/plixy/pkg/plugin/external/proto"
+)
+
+type config struct {
+	Header string   `json:"header"`
+	Keys   []string `json:"keys"`
+}
+
+type server struct{}
+
+func (s *server) BeforeProxy(ctx context.Context, req *pb.BeforeProxyRequest) (*pb.BeforeProxyResponse, error) {
+	c := &config{Header: "X-Api-Key"}
+	if err := json.Unmarshal(req.GetConfig(), c); err != nil {
+		return nil, err
+	}
+	for _, h := range req.GetHeaders() {
+		if !strings.EqualFold(h.GetName(), c.Header) {
+			continue
+		}
+		for _, key := range c.Keys {
+			if len(h.GetValues()) > 0 && h.GetValues()[0] == key {
+				return &pb.BeforeProxyResponse{
+					Action:        pb.BeforeProxyResponse_CONTINUE,
+					RemoveHeaders: []string{c.Header},
+				}, nil
+			}
+		}
+	}
+	return &pb.BeforeProxyResponse{
+		Action:  pb.BeforeProxyResponse_RESPOND,
+		Status:  401,
+		Headers: []*pb.Header{{Name: "Content-Type", Values: []string{"text/plain"}}},
+		Body:    []byte("Unauthorized\n"),
+	}, nil
+}
+
+func main() {
+	address := os.Getenv("PLIXY_PLUGIN_ADDRESS")
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+	lis, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := grpc.NewServer()
+	pb.RegisterPluginServer(s, &server{})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		s.GracefulStop()
+	}()
+	if err := s.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
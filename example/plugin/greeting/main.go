@@ -0,0 +1,45 @@
+// Command greeting is an example of the plugin loaded from the plugin dir.
+//
+//	go build -buildmode=plugin -o plugins/greeting.so ./example/plugin/greeting
+//	PLIXY_PLUGIN_DIR=plugins plixy start
+package main
+
+import (
+	"net/http"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+// PlixyPluginAPIVersion is checked by plixy to be compatible with the plugin api.
+var PlixyPluginAPIVersion = plugin.APIVersion
+
+// PlixyRegister is called by plixy when the plugin is loaded.
+func PlixyRegister() {
+	plugin.Register("greeting", &plugin.Plugin{
+		Config:     newConfig,
+		AfterProxy: AfterProxy,
+	})
+}
+
+type Config struct {
+	Message string `json:"message" valid:"required"`
+}
+
+func newConfig() interface{} {
+	return &Config{Message: "hello"}
+}
+
+// AfterProxy sets the greeting message to the response header.
+func AfterProxy(_ *api.Api, config map[string]interface{}) (plugin.AfterProxyHook, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, err
+	}
+	return func(res *http.Response) error {
+		res.Header.Set("X-Greeting", c.Message)
+		return nil
+	}, nil
+}
+
+func main() {}
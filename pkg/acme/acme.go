@@ -0,0 +1,112 @@
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/purini-to/plixy/pkg/config"
+)
+
+// ALPNProto is the protocol of TLS-ALPN-01 challenges.
+// It must be in the NextProtos of the listener.
+const ALPNProto = acme.ALPNProto
+
+// Manager obtains and renews certificates via the ACME protocol.
+// Only the hosts set by SetHosts are allowed to obtain a certificate.
+type Manager struct {
+	manager *autocert.Manager
+	hosts   atomic.Value
+}
+
+// New creates an ACME manager.
+// Certificates and the account key are persisted in the cache dir.
+// The ACME server trusted by the ca file is regarded as a test server such as Pebble,
+// and its client works around the finalize responses without the location header.
+func New(conf config.ACME) (*Manager, error) {
+	client := &acme.Client{DirectoryURL: conf.DirectoryURL}
+	if conf.CAFile != "" {
+		pem, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read acme ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("could not parse acme ca file. file: %s", conf.CAFile))
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: newOrderTransport(transport)}
+	}
+
+	m := &Manager{}
+	m.hosts.Store(map[string]struct{}{})
+	m.manager = &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(conf.CacheDir),
+		HostPolicy:  m.hostPolicy,
+		RenewBefore: conf.RenewBefore,
+		Email:       conf.Email,
+		Client:      client,
+	}
+	return m, nil
+}
+
+// SetHosts replaces the hosts allowed to obtain a certificate.
+func (m *Manager) SetHosts(hosts []string) {
+	hs := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		hs[strings.ToLower(h)] = struct{}{}
+	}
+	m.hosts.Store(hs)
+}
+
+// Handles reports whether the tls handshake should be served by the manager.
+func (m *Manager) Handles(hello *tls.ClientHelloInfo) bool {
+	return m.Challenge(hello) || m.hostPolicy(context.Background(), hello.ServerName) == nil
+}
+
+// Challenge reports whether the tls handshake is a TLS-ALPN-01 challenge.
+func (m *Manager) Challenge(hello *tls.ClientHelloInfo) bool {
+	for _, p := range hello.SupportedProtos {
+		if p == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate returns the certificate of the server name, obtaining it if needed.
+// It also answers TLS-ALPN-01 challenges.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.manager.GetCertificate(hello)
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes other requests to fallback.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.manager.HTTPHandler(fallback)
+}
+
+// hostPolicy allows the hosts set by SetHosts.
+// host has the port if it is the host header of HTTP-01 challenges.
+func (m *Manager) hostPolicy(_ context.Context, host string) error {
+	name := host
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	hosts := m.hosts.Load().(map[string]struct{})
+	if _, ok := hosts[strings.ToLower(strings.TrimSuffix(name, "."))]; !ok {
+		return errors.New(fmt.Sprintf("acme is not allowed for the host. host: %s", host))
+	}
+	return nil
+}
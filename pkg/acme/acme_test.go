@@ -0,0 +1,139 @@
+package acme
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/config"
+)
+
+func TestManager_Handles(t *testing.T) {
+	m, err := New(config.ACME{CacheDir: t.Name()})
+	assert.NoError(t, err)
+	m.SetHosts([]string{"Example.com"})
+
+	tests := []struct {
+		name  string
+		hello *tls.ClientHelloInfo
+		want  bool
+	}{
+		{name: "should be handle the allowed host", hello: &tls.ClientHelloInfo{ServerName: "example.com"}, want: true},
+		{name: "should be handle the allowed host with trailing dot", hello: &tls.ClientHelloInfo{ServerName: "EXAMPLE.com."}, want: true},
+		{name: "should be not handle the other host", hello: &tls.ClientHelloInfo{ServerName: "www.example.com"}, want: false},
+		{name: "should be handle the tls-alpn-01 challenge", hello: &tls.ClientHelloInfo{ServerName: "www.example.com", SupportedProtos: []string{ALPNProto}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.Handles(tt.hello))
+		})
+	}
+
+	t.Run("should be not handle the host removed by reload", func(t *testing.T) {
+		m.SetHosts(nil)
+		assert.False(t, m.Handles(&tls.ClientHelloInfo{ServerName: "example.com"}))
+	})
+}
+
+func TestManager_HTTPHandler(t *testing.T) {
+	m, err := New(config.ACME{CacheDir: t.Name()})
+	assert.NoError(t, err)
+
+	h := m.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	t.Run("should be pass the request to fallback", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/test", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+
+	t.Run("should be allow the challenge of the host with port", func(t *testing.T) {
+		m.SetHosts([]string{"example.com"})
+		defer m.SetHosts(nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com:8080/.well-known/acme-challenge/token", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("should be forbid the challenge of the host not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("should be return error if ca file is not found", func(t *testing.T) {
+		_, err := New(config.ACME{CAFile: "not_found.pem"})
+		assert.Error(t, err)
+	})
+
+	t.Run("should be use the default client for the production server", func(t *testing.T) {
+		m, err := New(config.ACME{CacheDir: t.Name()})
+		assert.NoError(t, err)
+		assert.Nil(t, m.manager.Client.HTTPClient)
+	})
+
+	t.Run("should be work around the test server trusted by the ca file", func(t *testing.T) {
+		srv := httptest.NewTLSServer(http.NotFoundHandler())
+		defer srv.Close()
+		dir, _ := ioutil.TempDir("", "acme_test")
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.crt")
+		assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+
+		m, err := New(config.ACME{CacheDir: t.Name(), CAFile: caFile})
+		assert.NoError(t, err)
+		assert.IsType(t, &orderTransport{}, m.manager.Client.HTTPClient.Transport)
+	})
+}
+
+func TestOrderTransport(t *testing.T) {
+	var finalized int
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new-order":
+			w.Header().Set("Location", srv.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"status":"pending","finalize":"` + srv.URL + `/finalize/1"}`))
+		case "/finalize/1":
+			finalized++
+			if finalized == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"status":"processing"}`))
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newOrderTransport(http.DefaultTransport)}
+
+	res, err := client.Post(srv.URL+"/new-order", "application/jose+json", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/order/1", res.Header.Get("Location"))
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Contains(t, string(body), "finalize")
+
+	t.Run("should be not set the order url to the error response", func(t *testing.T) {
+		res, err := client.Post(srv.URL+"/finalize/1", "application/jose+json", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "", res.Header.Get("Location"))
+	})
+
+	t.Run("should be set the order url to the finalize response", func(t *testing.T) {
+		res, err := client.Post(srv.URL+"/finalize/1", "application/jose+json", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/order/1", res.Header.Get("Location"))
+	})
+}
@@ -0,0 +1,75 @@
+package acme
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// maxOrders bounds the orders remembered, because orders failed to authorize are never finalized.
+const maxOrders = 128
+
+// orderTransport sets the order url to the location header of finalize responses.
+// The acme client waits for the order by the location header after finalizing,
+// but RFC 8555 does not require it and servers such as Pebble omit it.
+type orderTransport struct {
+	base   http.RoundTripper
+	mu     sync.Mutex
+	orders map[string]string
+}
+
+func newOrderTransport(base http.RoundTripper) *orderTransport {
+	return &orderTransport{
+		base:   base,
+		orders: make(map[string]string),
+	}
+}
+
+func (t *orderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+
+	location := res.Header.Get("Location")
+	switch {
+	case location == "" && res.StatusCode == http.StatusOK:
+		// finalize requests may be retried on errors such as bad nonce,
+		// so the order is used only by the successful response.
+		t.mu.Lock()
+		if order, ok := t.orders[req.URL.String()]; ok {
+			res.Header.Set("Location", order)
+			delete(t.orders, req.URL.String())
+		}
+		t.mu.Unlock()
+	case location != "" && res.StatusCode == http.StatusCreated:
+		return t.remember(res, location)
+	}
+	return res, nil
+}
+
+// remember remembers the order url by the finalize url of the new order.
+func (t *orderTransport) remember(res *http.Response, location string) (*http.Response, error) {
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var o struct {
+		Finalize string `json:"finalize"`
+	}
+	if err := json.Unmarshal(body, &o); err != nil || o.Finalize == "" {
+		return res, nil
+	}
+	t.mu.Lock()
+	if len(t.orders) >= maxOrders {
+		t.orders = make(map[string]string)
+	}
+	t.orders[o.Finalize] = location
+	t.mu.Unlock()
+	return res, nil
+}
@@ -2,7 +2,12 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -18,64 +23,347 @@ const (
 	NameHeaderKey string = "X-Plixy-Api-Name"
 )
 
-var varsReg = regexp.MustCompile(`\{(.+?)\}`)
-
+// Definition is the definition of the apis.
+// Plugins are applied to all apis, and PluginGroups are the named plugins that apis can reference.
 type Definition struct {
-	Apis []*Api `yaml:"apis" valid:"required"`
+	Apis         []*Api               `yaml:"apis" valid:"required"`
+	Certificates []*Certificate       `yaml:"certificates"`
+	Plugins      []*Plugin            `yaml:"plugins"`
+	PluginGroups map[string][]*Plugin `yaml:"pluginGroups"`
+}
+
+// Certificate is a certificate served by the gateway.
+// It is either a pair of files, inline PEM or hosts obtained via ACME.
+type Certificate struct {
+	CertFile string   `yaml:"certFile"`
+	KeyFile  string   `yaml:"keyFile"`
+	Cert     string   `yaml:"cert"`
+	Key      string   `yaml:"key"`
+	Acme     bool     `yaml:"acme"`
+	Hosts    []string `yaml:"hosts"`
+}
+
+// AcmeHosts returns the hostnames whose certificates are obtained via ACME.
+func (d *Definition) AcmeHosts() []string {
+	hosts := make([]string, 0)
+	for _, c := range d.Certificates {
+		if c.Acme {
+			hosts = append(hosts, c.Hosts...)
+		}
+	}
+	return hosts
 }
 
 func (d *Definition) Validate() (bool, error) {
-	return govalidator.ValidateStruct(d)
+	if ok, err := govalidator.ValidateStruct(d); !ok {
+		return ok, err
+	}
+	for i, c := range d.Certificates {
+		if c.Acme {
+			if len(c.Hosts) == 0 {
+				return false, errors.New(fmt.Sprintf("acme certificate must have hosts. certificates[%d]", i))
+			}
+			for _, h := range c.Hosts {
+				if !govalidator.IsDNSName(h) {
+					return false, errors.New(fmt.Sprintf("acme host must be dns name. certificates[%d] host: %s", i, h))
+				}
+			}
+			continue
+		}
+		files := c.CertFile != "" && c.KeyFile != ""
+		inline := c.Cert != "" && c.Key != ""
+		if files == inline {
+			return false, errors.New(fmt.Sprintf("certificate must be either certFile and keyFile, cert and key or acme. certificates[%d]", i))
+		}
+	}
+	for i, a := range d.Apis {
+		if err := validateSplit(a); err != nil {
+			return false, err
+		}
+		for _, f := range a.Proxy.upstreamFields(i) {
+			if err := validateUpstream(a, f.upstream, f.path); err != nil {
+				return false, err
+			}
+		}
+		for _, h := range a.Proxy.Hosts {
+			if !govalidator.IsDNSName(strings.TrimPrefix(h, "*.")) {
+				return false, errors.New(fmt.Sprintf("host must be dns name or wildcard. name: %s host: %s", a.Name, h))
+			}
+		}
+		for _, matches := range [][]*Match{a.Proxy.Headers, a.Proxy.Queries} {
+			for _, m := range matches {
+				if m.Name == "" {
+					return false, errors.New(fmt.Sprintf("match name is required. name: %s", a.Name))
+				}
+				if m.Value != "" && m.Regex != "" {
+					return false, errors.New(fmt.Sprintf("match must be either value or regex. name: %s match: %s", a.Name, m.Name))
+				}
+				if _, err := regexp.Compile(m.Regex); err != nil {
+					return false, errors.Wrap(err, fmt.Sprintf("match regex is invalid. name: %s match: %s", a.Name, m.Name))
+				}
+			}
+		}
+		if c := a.Proxy.ClientAuth; c != nil {
+			for _, patterns := range [][]string{c.Subjects, c.SANs} {
+				for _, p := range patterns {
+					if _, err := regexp.Compile(p); err != nil {
+						return false, errors.Wrap(err, fmt.Sprintf("client auth pattern is invalid. name: %s", a.Name))
+					}
+				}
+			}
+		}
+	}
+	if err := d.validatePlugins(); err != nil {
+		return false, err
+	}
+	if err := d.validateRoutes(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// validateUpstream validates the upstream of the field path like `apis[0].proxy.upstream`.
+func validateUpstream(a *Api, u *Upstream, path string) error {
+	if u.Target == "" && len(u.Targets) == 0 {
+		return errors.New(fmt.Sprintf("upstream target or targets is required. name: %s", a.Name))
+	}
+	if err := validateTargetVars(u, path); err != nil {
+		return err
+	}
+	if u.Rewrite != nil {
+		if _, err := regexp.Compile(u.Rewrite.Regex); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("upstream rewrite regex is invalid. name: %s", a.Name))
+		}
+	}
+	if u.TLS != nil && (u.TLS.CertFile == "") != (u.TLS.KeyFile == "") {
+		return errors.New(fmt.Sprintf("upstream tls certFile and keyFile must be set together. name: %s", a.Name))
+	}
+	return nil
+}
+
+func validateSplit(a *Api) error {
+	sp := a.Proxy.Split
+	if sp == nil {
+		return nil
+	}
+	if len(sp.Backends) == 0 {
+		return errors.New(fmt.Sprintf("split must have backends. name: %s", a.Name))
+	}
+	names := make(map[string]struct{}, len(sp.Backends))
+	total := 0
+	for i, b := range sp.Backends {
+		// govalidator does not validate the structs in the slice
+		if ok, err := govalidator.ValidateStruct(b); !ok {
+			return errors.Wrap(err, fmt.Sprintf("split backend is invalid. name: %s backends[%d]", a.Name, i))
+		}
+		if _, ok := names[b.Name]; ok {
+			return errors.New(fmt.Sprintf("split backend name is duplicated. name: %s backend: %s", a.Name, b.Name))
+		}
+		names[b.Name] = struct{}{}
+		if b.Weight < 0 || b.Weight > 100 {
+			return errors.New(fmt.Sprintf("split weight must be between 0 and 100. name: %s backend: %s", a.Name, b.Name))
+		}
+		total += b.Weight
+	}
+	if total > 100 {
+		return errors.New(fmt.Sprintf("total of split weights must be 100 or less. name: %s", a.Name))
+	}
+	if st := sp.Sticky; st != nil {
+		keys := 0
+		for _, ok := range []bool{st.Header != "", st.Cookie != "", st.ClientIP} {
+			if ok {
+				keys++
+			}
+		}
+		if keys != 1 {
+			return errors.New(fmt.Sprintf("split sticky must be either header, cookie or clientIP. name: %s", a.Name))
+		}
+	}
+	return nil
 }
 
 type Api struct {
-	Name    string    `yaml:"name" valid:"required"`
-	Proxy   *Proxy    `yaml:"proxy" valid:"required"`
-	Plugins []*Plugin `yaml:"plugins"`
+	Name         string    `yaml:"name" valid:"required"`
+	Proxy        *Proxy    `yaml:"proxy" valid:"required"`
+	PluginGroups []string  `yaml:"pluginGroups"`
+	Plugins      []*Plugin `yaml:"plugins"`
 }
 
+// Proxy is the route of the api.
+// PathType is exact by default, and prefix matches the path and its sub paths.
+// Priority is matched in descending order, and apis of the same priority are matched from the most specific.
 type Proxy struct {
-	Path     string    `yaml:"path" valid:"required,matches(^/)~path must be start with '/'"`
-	Methods  []string  `yaml:"methods" valid:"matches(^(GET|HEAD|POST|PUT|PATCH|DELETE|CONNECT|OPTIONS|TRACE)$)~methods must be http methods. [GET|HEAD|POST|PUT|PATCH|DELETE|CONNECT|OPTIONS|TRACE]"`
+	Path       string      `yaml:"path" valid:"required,matches(^/)~path must be start with '/'"`
+	PathType   string      `yaml:"pathType" valid:"in(exact|prefix)~pathType must be contains [exact|prefix]"`
+	Methods    []string    `yaml:"methods" valid:"matches(^(GET|HEAD|POST|PUT|PATCH|DELETE|CONNECT|OPTIONS|TRACE)$)~methods must be http methods. [GET|HEAD|POST|PUT|PATCH|DELETE|CONNECT|OPTIONS|TRACE]"`
+	Hosts      []string    `yaml:"hosts"`
+	Headers    []*Match    `yaml:"headers"`
+	Queries    []*Match    `yaml:"queries"`
+	Schemes    []string    `yaml:"schemes" valid:"matches(^(http|https)$)~schemes must be http or https"`
+	Upstream   *Upstream   `yaml:"upstream" valid:"required"`
+	ClientAuth *ClientAuth `yaml:"clientAuth"`
+	Priority   int         `yaml:"priority"`
+	Split      *Split      `yaml:"split"`
+	Mirror     *Mirror     `yaml:"mirror"`
+}
+
+// Upstreams returns the upstream, the upstreams of the split backends and the mirror.
+func (p *Proxy) Upstreams() []*Upstream {
+	ups := []*Upstream{p.Upstream}
+	if p.Split != nil {
+		for _, b := range p.Split.Backends {
+			ups = append(ups, b.Upstream)
+		}
+	}
+	if p.Mirror != nil {
+		ups = append(ups, p.Mirror.Upstream)
+	}
+	return ups
+}
+
+// Split routes a percentage of the requests to alternative upstreams such as canary releases.
+// The upstream of the proxy receives the rest of the weights of the backends.
+type Split struct {
+	Backends []*Backend `yaml:"backends"`
+	Sticky   *Sticky    `yaml:"sticky"`
+}
+
+// Backend is an alternative upstream that receives Weight percent of the requests.
+type Backend struct {
+	Name     string    `yaml:"name" valid:"required"`
+	Weight   int       `yaml:"weight"`
 	Upstream *Upstream `yaml:"upstream" valid:"required"`
 }
 
+// Mirror sends a copy of the requests to the shadow upstream and discards its response.
+// Percentage is the sampling rate and 100 if it is zero. Requests with a body larger than MaxBodySize are not mirrored.
+// Compare records whether the status and the body of the mirror response are the same as the primary response.
+// MaxConcurrency limits the mirrored requests in flight, and the requests over it are not mirrored.
+type Mirror struct {
+	Upstream       *Upstream     `yaml:"upstream" valid:"required"`
+	Percentage     float64       `yaml:"percentage" valid:"range(0|100)~percentage must be between 0 and 100"`
+	MaxBodySize    int64         `yaml:"maxBodySize"`
+	Timeout        time.Duration `yaml:"timeout"`
+	Compare        bool          `yaml:"compare"`
+	MaxConcurrency int           `yaml:"maxConcurrency"`
+}
+
+// Sticky keeps a client on the same upstream by the hash of the header, the cookie or the client ip.
+type Sticky struct {
+	Header   string `yaml:"header"`
+	Cookie   string `yaml:"cookie"`
+	ClientIP bool   `yaml:"clientIP"`
+}
+
+// Match matches a header or query parameter of the request.
+// It matches the exact value, the regular expression, or the presence if both are empty.
+type Match struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+}
+
+// ClientAuth restricts the clients by the verified client certificate.
+// Subjects and SANs are regular expressions, and the client is allowed if either matches.
+type ClientAuth struct {
+	Subjects []string `yaml:"subjects"`
+	SANs     []string `yaml:"sans"`
+}
+
 type Upstream struct {
-	Target    string   `yaml:"target" valid:"required,requrl~target must be url"`
-	FixedPath bool     `yaml:"fixedPath"`
-	Vars      []string `yaml:"-"`
+	Target      string       `yaml:"target" valid:"requrl~target must be url"`
+	Targets     []*Target    `yaml:"targets"`
+	Balancing   string       `yaml:"balancing" valid:"in(roundRobin|weightedRoundRobin|leastConn|random|p2c)~balancing must be contains [roundRobin|weightedRoundRobin|leastConn|random|p2c]"`
+	FixedPath   bool         `yaml:"fixedPath"`
+	StripPrefix string       `yaml:"stripPrefix" valid:"matches(^/)~stripPrefix must be start with '/'"`
+	AddPrefix   string       `yaml:"addPrefix" valid:"matches(^/)~addPrefix must be start with '/'"`
+	Rewrite     *Rewrite     `yaml:"rewrite"`
+	HealthCheck *HealthCheck `yaml:"healthCheck"`
+	Outlier     *Outlier     `yaml:"outlierDetection"`
+	Retry       *Retry       `yaml:"retry"`
+	Transport   *Transport   `yaml:"transport"`
+	TLS         *UpstreamTLS `yaml:"tls"`
+}
+
+// Rewrite replaces the request path matched by the regular expression.
+// Replacement can refer to the capture groups such as $1 or ${name}.
+type Rewrite struct {
+	Regex       string `yaml:"regex" valid:"required"`
+	Replacement string `yaml:"replacement"`
 }
 
-func (u *Upstream) UnmarshalYAML(unmarshal func(v interface{}) error) error {
-	var m map[string]interface{}
-	if err := unmarshal(&m); err != nil {
+// AllTargets returns the targets to balance.
+// A single target is treated as one target with weight 1.
+func (u *Upstream) AllTargets() []*Target {
+	if len(u.Targets) > 0 {
+		return u.Targets
+	}
+	return []*Target{{Target: u.Target, Weight: 1}}
+}
+
+type Target struct {
+	Target string `yaml:"target" valid:"required,requrl~target must be url"`
+	Weight int    `yaml:"weight"`
+}
+
+func (t *Target) UnmarshalYAML(unmarshal func(v interface{}) error) error {
+	type plain Target
+	if err := unmarshal((*plain)(t)); err != nil {
 		return err
 	}
-	for k, v := range m {
-		switch k {
-		case "target":
-			v := v.(string)
-			u.Target = v
-			group := varsReg.FindAllSubmatch([]byte(v), -1)
-			if group == nil {
-				continue
-			}
-			for _, g := range group {
-				u.Vars = append(u.Vars, string(g[1]))
-			}
-		case "fixedPath":
-			v := v.(bool)
-			u.FixedPath = v
-		default:
-			continue
-		}
+	if t.Weight <= 0 {
+		t.Weight = 1
 	}
 	return nil
 }
 
-type Plugin struct {
-	Name   string                 `yaml:"name" valid:"required"`
-	Config map[string]interface{} `yaml:"config"`
+// HealthCheck is the active health checking of upstream targets.
+type HealthCheck struct {
+	Path               string        `yaml:"path" valid:"required,matches(^/)~path must be start with '/'"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthyThreshold"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold"`
+}
+
+// Outlier is the passive health checking that ejects failing targets
+// based on the outcome of proxied requests.
+type Outlier struct {
+	ConsecutiveFailures int           `yaml:"consecutiveFailures"`
+	BaseEjectionTime    time.Duration `yaml:"baseEjectionTime"`
+	MaxEjectionTime     time.Duration `yaml:"maxEjectionTime"`
+}
+
+// Retry is the retry policy of requests to upstream targets.
+type Retry struct {
+	Attempts           int           `yaml:"attempts"`
+	RetryOn            []string      `yaml:"retryOn" valid:"matches(^(connectFailure|timeout|5xx|[1-5][0-9][0-9])$)~retryOn must be contains [connectFailure|timeout|5xx|status code]"`
+	PerTryTimeout      time.Duration `yaml:"perTryTimeout"`
+	Backoff            time.Duration `yaml:"backoff"`
+	MaxBackoff         time.Duration `yaml:"maxBackoff"`
+	RetryNonIdempotent bool          `yaml:"retryNonIdempotent"`
+	MaxBodySize        int64         `yaml:"maxBodySize"`
+}
+
+// Transport overrides the global transport settings for the upstream.
+// Timeout limits the whole request including retries.
+type Transport struct {
+	ConnectTimeout        time.Duration `yaml:"connectTimeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout"`
+	Timeout               time.Duration `yaml:"timeout"`
+	MaxIdleConns          int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost   int           `yaml:"maxIdleConnsPerHost"`
+	IdleConnTimeout       time.Duration `yaml:"idleConnTimeout"`
+	HTTP2                 *bool         `yaml:"http2"`
+}
+
+// UpstreamTLS is the tls settings to connect to upstream targets.
+type UpstreamTLS struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
 }
 
 type DefinitionChanged struct {
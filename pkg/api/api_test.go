@@ -28,13 +28,38 @@ test: 1
 			wantErr: false,
 		},
 		{
-			name: "should be set vars if target has a path variables",
+			name: "should be set rewrite rules",
 			args: args{in: `
-target: "http://localhost:9002/apis/v1/users/{userId}/tasks/{taskId}"
+target: "http://localhost:9002"
+stripPrefix: "/api"
+addPrefix: "/apis/v1"
+rewrite:
+  regex: "^/users/(\\d+)$"
+  replacement: "/members/$1"
 `},
 			want: &Upstream{
-				Target: "http://localhost:9002/apis/v1/users/{userId}/tasks/{taskId}",
-				Vars:   []string{"userId", "taskId"},
+				Target:      "http://localhost:9002",
+				StripPrefix: "/api",
+				AddPrefix:   "/apis/v1",
+				Rewrite:     &Rewrite{Regex: `^/users/(\d+)$`, Replacement: "/members/$1"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "should be set targets with default weight",
+			args: args{in: `
+targets:
+  - target: "http://localhost:9001/users"
+    weight: 3
+  - target: "http://localhost:9002"
+balancing: weightedRoundRobin
+`},
+			want: &Upstream{
+				Targets: []*Target{
+					{Target: "http://localhost:9001/users", Weight: 3},
+					{Target: "http://localhost:9002", Weight: 1},
+				},
+				Balancing: "weightedRoundRobin",
 			},
 			wantErr: false,
 		},
@@ -60,3 +85,197 @@ target: 'test""
 		})
 	}
 }
+
+func TestDefinition_Validate(t *testing.T) {
+	newDef := func(u *Upstream) *Definition {
+		return &Definition{Apis: []*Api{{Name: "test", Proxy: &Proxy{Path: "/test", Upstream: u}}}}
+	}
+
+	t.Run("should be valid if upstream has targets", func(t *testing.T) {
+		ok, err := newDef(&Upstream{Targets: []*Target{{Target: "http://localhost:8080", Weight: 1}}}).Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should be error if upstream has neither target nor targets", func(t *testing.T) {
+		ok, err := newDef(&Upstream{}).Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if balancing is unknown", func(t *testing.T) {
+		ok, err := newDef(&Upstream{Target: "http://localhost:8080", Balancing: "unknown"}).Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be valid if acme certificate has hosts", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Certificates = []*Certificate{{Acme: true, Hosts: []string{"example.com"}}}
+		ok, err := def.Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"example.com"}, def.AcmeHosts())
+	})
+
+	t.Run("should be error if acme certificate has no hosts", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Certificates = []*Certificate{{Acme: true}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if acme host is not dns name", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Certificates = []*Certificate{{Acme: true, Hosts: []string{"*.example.com"}}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if client auth pattern is invalid", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.ClientAuth = &ClientAuth{Subjects: []string{"("}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be valid if proxy has matchers", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Hosts = []string{"api.example.com", "*.example.com"}
+		def.Apis[0].Proxy.Headers = []*Match{{Name: "Accept-Version", Regex: "^v2"}, {Name: "X-Debug"}}
+		def.Apis[0].Proxy.Queries = []*Match{{Name: "version", Value: "2"}}
+		def.Apis[0].Proxy.Schemes = []string{"https"}
+		ok, err := def.Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should be error if host is invalid", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Hosts = []string{"api.*.com"}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if match has both value and regex", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Headers = []*Match{{Name: "Accept-Version", Value: "v2", Regex: "^v2"}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if match has no name", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Queries = []*Match{{Value: "2"}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if scheme is unknown", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Schemes = []string{"ftp"}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be valid if target has deprecated path variables", func(t *testing.T) {
+		ok, err := newDef(&Upstream{Target: "http://localhost:8080/users/{id}"}).Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should be error if target has path variables in the host", func(t *testing.T) {
+		ok, err := newDef(&Upstream{Targets: []*Target{{Target: "http://localhost:8080"}, {Target: "http://{id}.localhost:8080"}}}).Validate()
+		assert.False(t, ok)
+		assert.EqualError(t, err, "upstream target can have path variables only in the path. apis[0].proxy.upstream.targets[1].target")
+	})
+
+	t.Run("should be error if rewrite regex is invalid", func(t *testing.T) {
+		ok, err := newDef(&Upstream{Target: "http://localhost:8080", Rewrite: &Rewrite{Regex: "("}}).Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if path type is unknown", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.PathType = "regex"
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be valid if proxy has split backends", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Split = &Split{
+			Backends: []*Backend{{Name: "v2", Weight: 5, Upstream: &Upstream{Target: "http://localhost:8081"}}},
+			Sticky:   &Sticky{Cookie: "user"},
+		}
+		ok, err := def.Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(def.Apis[0].Proxy.Upstreams()))
+	})
+
+	t.Run("should be error if total of split weights is over 100", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Split = &Split{Backends: []*Backend{
+			{Name: "v2", Weight: 60, Upstream: &Upstream{Target: "http://localhost:8081"}},
+			{Name: "v3", Weight: 50, Upstream: &Upstream{Target: "http://localhost:8082"}},
+		}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if split backend upstream is invalid", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Split = &Split{Backends: []*Backend{
+			{Name: "v2", Weight: 5, Upstream: &Upstream{Target: "http://localhost:8081", Balancing: "unknown"}},
+		}}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if split sticky has multiple keys", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Split = &Split{
+			Backends: []*Backend{{Name: "v2", Weight: 5, Upstream: &Upstream{Target: "http://localhost:8081"}}},
+			Sticky:   &Sticky{Header: "X-User-Id", ClientIP: true},
+		}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be valid if proxy has mirror", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Mirror = &Mirror{Upstream: &Upstream{Target: "http://localhost:8081"}, Percentage: 12.5, Compare: true}
+		ok, err := def.Validate()
+		assert.True(t, ok)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should be error if mirror percentage is out of range", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Mirror = &Mirror{Upstream: &Upstream{Target: "http://localhost:8081"}, Percentage: 120}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be error if mirror upstream has no target", func(t *testing.T) {
+		def := newDef(&Upstream{Target: "http://localhost:8080"})
+		def.Apis[0].Proxy.Mirror = &Mirror{Upstream: &Upstream{}, Percentage: 10}
+		ok, err := def.Validate()
+		assert.False(t, ok)
+		assert.Error(t, err)
+	})
+}
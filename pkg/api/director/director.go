@@ -4,10 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/upstream"
 
 	"github.com/purini-to/plixy/pkg/log"
 	"go.uber.org/zap"
@@ -19,31 +18,26 @@ func Director(r *http.Request) {
 	originalPath := r.URL.Path
 
 	apiDef := api.FromContext(ctx)
-	target := apiDef.Proxy.Upstream.Target
-	uri, err := url.Parse(target)
-	if err != nil {
-		panic(errors.New(fmt.Sprintf("Could not parse upstream uri. uri: %s", target)))
+	target := upstream.TargetFromContext(ctx)
+	if target == nil {
+		panic(errors.New(fmt.Sprintf("Could not find upstream target. name: %s", apiDef.Name)))
 	}
+	uri := target.URL
 
 	r.URL.Scheme = uri.Scheme
 	r.URL.Host = uri.Host
 	r.Host = uri.Host
 
-	path := uri.Path
-	if len(apiDef.Proxy.Upstream.Vars) > 0 {
-		vars := api.VarsFromContext(ctx)
-		for _, v := range apiDef.Proxy.Upstream.Vars {
-			if s, ok := vars[v]; ok {
-				path = strings.Replace(path, fmt.Sprintf("{%s}", v), s, 1)
-			}
-		}
+	up := upstream.FromContext(ctx)
+	if up.Definition().FixedPath {
+		r.URL.Path = target.Path(ctx)
+	} else {
+		path := up.RewritePath(originalPath)
+		r.URL.Path = upstream.JoinPath(target.Path(ctx), path)
 	}
-
-	r.URL.Path = path
-	if !apiDef.Proxy.Upstream.FixedPath {
-		r.URL.Path += originalPath
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
 	}
-	r.URL.Path = strings.ReplaceAll(r.URL.Path, "//", "/")
 
 	logger := log.FromContext(ctx)
 	logger.Info("Proxying request to the following upstream",
@@ -0,0 +1,85 @@
+package director
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+func TestDirector(t *testing.T) {
+	tests := []struct {
+		name     string
+		route    string
+		upstream *api.Upstream
+		path     string
+		want     string
+	}{
+		{name: "should be append the request path", upstream: &api.Upstream{Target: "http://localhost:9002"}, path: "/users", want: "http://localhost:9002/users"},
+		{name: "should be join the target path", upstream: &api.Upstream{Target: "http://localhost:9002/apis/v1/"}, path: "/users", want: "http://localhost:9002/apis/v1/users"},
+		{name: "should be use the target path if fixed", upstream: &api.Upstream{Target: "http://localhost:9002/status", FixedPath: true}, path: "/users", want: "http://localhost:9002/status"},
+		{name: "should be root if fixed target has no path", upstream: &api.Upstream{Target: "http://localhost:9002", FixedPath: true}, path: "/users", want: "http://localhost:9002/"},
+		{
+			name:     "should be rewrite the request path",
+			upstream: &api.Upstream{Target: "http://localhost:9002/apis", StripPrefix: "/api", Rewrite: &api.Rewrite{Regex: `^/users/(\d+)$`, Replacement: "/members/$1"}},
+			path:     "/api/users/1",
+			want:     "http://localhost:9002/apis/members/1",
+		},
+		{
+			name:     "should be expand the deprecated path variables of the fixed target",
+			route:    "/users/{id:[0-9]+}",
+			upstream: &api.Upstream{Target: "http://localhost:9002/members/{id}", FixedPath: true},
+			path:     "/users/1",
+			want:     "http://localhost:9002/members/1",
+		},
+		{
+			name:     "should be append the request path to the deprecated path variables",
+			route:    "/users/{id}",
+			upstream: &api.Upstream{Target: "http://localhost:9002/apis/{id}/"},
+			path:     "/users/1",
+			want:     "http://localhost:9002/apis/1/users/1",
+		},
+		{
+			name:  "should be expand the deprecated path variables of the target not translated",
+			route: "/users/{id}",
+			upstream: &api.Upstream{Targets: []*api.Target{
+				{Target: "http://localhost:9002/members/{id}", Weight: 1},
+				{Target: "http://localhost:9003/accounts/{id}", Weight: 1},
+			}, FixedPath: true},
+			path: "/users/1",
+			want: "http://localhost:9002/members/1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := tt.route
+			if route == "" {
+				route = "/"
+			}
+			a := &api.Api{Name: "test", Proxy: &api.Proxy{Path: route, Upstream: tt.upstream}}
+			assert.NoError(t, (&api.Definition{Apis: []*api.Api{a}}).Migrate())
+			up, err := upstream.New(a.Name, a.Proxy.Upstream)
+			assert.NoError(t, err)
+			target, err := up.Next(context.Background())
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			ctx := log.ToContext(req.Context(), zap.NewNop())
+			ctx = api.ToContext(ctx, a)
+			ctx = upstream.ToContext(ctx, up)
+			ctx = upstream.TargetToContext(ctx, target)
+			ctx = api.VarsToContext(ctx, map[string]string{"id": "1"})
+			req = req.WithContext(ctx)
+
+			Director(req)
+			assert.Equal(t, tt.want, req.URL.String())
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+// Migrate translates the deprecated settings of the definition into the current ones.
+// The path variables of the upstream targets such as `http://users/{id}` are translated
+// into the rewrite of the upstream with a warning.
+// The targets that cannot be translated are left, and their variables are expanded by the proxy.
+func (d *Definition) Migrate() error {
+	for i, a := range d.Apis {
+		if a == nil || a.Proxy == nil {
+			continue
+		}
+		for _, f := range a.Proxy.upstreamFields(i) {
+			if err := migrateTargetVars(a.Proxy, f.upstream, f.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type upstreamField struct {
+	path     string
+	upstream *Upstream
+}
+
+// upstreamFields returns the upstreams of the proxy with the paths of the fields like `apis[0].proxy.upstream`.
+func (p *Proxy) upstreamFields(i int) []upstreamField {
+	fields := []upstreamField{{path: fmt.Sprintf("apis[%d].proxy.upstream", i), upstream: p.Upstream}}
+	if p.Split != nil {
+		for j, b := range p.Split.Backends {
+			fields = append(fields, upstreamField{path: fmt.Sprintf("apis[%d].proxy.split.backends[%d].upstream", i, j), upstream: b.Upstream})
+		}
+	}
+	if p.Mirror != nil {
+		fields = append(fields, upstreamField{path: fmt.Sprintf("apis[%d].proxy.mirror.upstream", i), upstream: p.Mirror.Upstream})
+	}
+	return fields
+}
+
+// targets returns the pointers to the targets of the upstream.
+func (u *Upstream) targets() []*string {
+	if len(u.Targets) == 0 {
+		return []*string{&u.Target}
+	}
+	targets := make([]*string, 0, len(u.Targets))
+	for _, t := range u.Targets {
+		targets = append(targets, &t.Target)
+	}
+	return targets
+}
+
+// targetField returns the path of the field of the j-th target of the upstream.
+func targetField(u *Upstream, path string, j int) string {
+	if len(u.Targets) == 0 {
+		return path + ".target"
+	}
+	return fmt.Sprintf("%s.targets[%d].target", path, j)
+}
+
+// migrateTargetVars moves the path of the targets that has the path variables into the rewrite of the upstream.
+// The rewrite replaces the request path matched by the route with the path of the target,
+// followed by the request path unless the path is fixed.
+// The targets are left if they have the different paths or the upstream has its own rewrite.
+func migrateTargetVars(p *Proxy, u *Upstream, path string) error {
+	if u == nil {
+		return nil
+	}
+	targets := u.targets()
+	found := false
+	for _, t := range targets {
+		if strings.ContainsAny(*t, "{}") {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	if err := validateTargetVars(u, path); err != nil {
+		return err
+	}
+	uris := make([]*url.URL, 0, len(targets))
+	same := true
+	for j, t := range targets {
+		uri, err := url.Parse(*t)
+		if err != nil {
+			// the invalid target is reported by the validation
+			return nil
+		}
+		same = same && (j == 0 || uri.Path == uris[0].Path)
+		uris = append(uris, uri)
+	}
+	regex, vars, err := pathRegex(p.Path)
+	if !same || err != nil || u.Rewrite != nil || u.StripPrefix != "" {
+		log.Warn("Path variables of the upstream target are deprecated, use rewrite instead", zap.String("field", path))
+		return nil
+	}
+	replacement := expandTemplate(uris[0].Path, vars)
+	if !u.FixedPath {
+		replacement = strings.TrimSuffix(replacement, "/") + "${0}"
+	}
+
+	for j, uri := range uris {
+		uri.Path, uri.RawPath = "", ""
+		*targets[j] = uri.String()
+	}
+	u.FixedPath = false
+	u.Rewrite = &Rewrite{Regex: regex, Replacement: replacement}
+	log.Warn("Path variables of the upstream target are deprecated, use rewrite instead",
+		zap.String("field", path),
+		zap.String("regex", regex),
+		zap.String("replacement", replacement),
+	)
+	return nil
+}
+
+// validateTargetVars returns error if the targets of the upstream have the variables not in the path.
+func validateTargetVars(u *Upstream, path string) error {
+	for j, t := range u.targets() {
+		if !strings.ContainsAny(*t, "{}") {
+			continue
+		}
+		uri, err := url.Parse(*t)
+		if err != nil || strings.ContainsAny(uri.Host, "{}") {
+			return errors.New(fmt.Sprintf("upstream target can have path variables only in the path. %s", targetField(u, path, j)))
+		}
+	}
+	return nil
+}
+
+// pathRegex returns the regular expression that matches the route path and its sub paths,
+// and captures the path variables by their names.
+func pathRegex(path string) (string, map[string]struct{}, error) {
+	vars := make(map[string]struct{})
+	var b strings.Builder
+	b.WriteString("^")
+	depth, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			if depth == 0 {
+				b.WriteString(regexp.QuoteMeta(path[start:i]))
+				start = i + 1
+			}
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return "", nil, errors.New(fmt.Sprintf("unbalanced braces in path. path: %s", path))
+			}
+			if depth > 0 {
+				continue
+			}
+			name, expr := path[start:i], "[^/]+"
+			if j := strings.IndexByte(name, ':'); j >= 0 {
+				name, expr = name[:j], path[start+j+1:i]
+			}
+			fmt.Fprintf(&b, "(?P<%s>%s)", name, expr)
+			vars[name] = struct{}{}
+			start = i + 1
+		}
+	}
+	if depth != 0 {
+		return "", nil, errors.New(fmt.Sprintf("unbalanced braces in path. path: %s", path))
+	}
+	b.WriteString(regexp.QuoteMeta(path[start:]))
+	b.WriteString("(?:/.*)?$")
+	regex := b.String()
+	if _, err := regexp.Compile(regex); err != nil {
+		return "", nil, err
+	}
+	return regex, vars, nil
+}
+
+// expandTemplate replaces the variables of the route in the path with the references of the capture groups.
+// Unknown variables are left as is like the expansion of the targets.
+func expandTemplate(path string, vars map[string]struct{}) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(path, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(path[i:], '}')
+		if j < 0 {
+			break
+		}
+		j += i
+		b.WriteString(strings.ReplaceAll(path[:i], "$", "$$"))
+		if _, ok := vars[path[i+1:j]]; ok {
+			fmt.Fprintf(&b, "${%s}", path[i+1:j])
+		} else {
+			b.WriteString(strings.ReplaceAll(path[i:j+1], "$", "$$"))
+		}
+		path = path[j+1:]
+	}
+	b.WriteString(strings.ReplaceAll(path, "$", "$$"))
+	return b.String()
+}
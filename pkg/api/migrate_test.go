@@ -0,0 +1,85 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefinition_Migrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		proxy   *Proxy
+		want    *Upstream
+		wantErr string
+	}{
+		{
+			name:  "should be not change the upstream without path variables",
+			path:  "/users/{id}",
+			proxy: &Proxy{Upstream: &Upstream{Target: "http://localhost:8080/apis"}},
+			want:  &Upstream{Target: "http://localhost:8080/apis"},
+		},
+		{
+			name:  "should be translate the path variables of the fixed target into rewrite",
+			path:  "/users/{id}/items/{item:[0-9]+}",
+			proxy: &Proxy{Upstream: &Upstream{Target: "http://localhost:8080/members/{id}/{item}/{unknown}", FixedPath: true}},
+			want: &Upstream{
+				Target:  "http://localhost:8080",
+				Rewrite: &Rewrite{Regex: `^/users/(?P<id>[^/]+)/items/(?P<item>[0-9]+)(?:/.*)?$`, Replacement: "/members/${id}/${item}/{unknown}"},
+			},
+		},
+		{
+			name: "should be translate the path variables of the targets followed by the request path",
+			path: "/users/{id}",
+			proxy: &Proxy{Upstream: &Upstream{Targets: []*Target{
+				{Target: "http://localhost:8080/members/{id}", Weight: 1},
+				{Target: "http://localhost:8081/members/{id}", Weight: 2},
+			}}},
+			want: &Upstream{
+				Targets: []*Target{{Target: "http://localhost:8080", Weight: 1}, {Target: "http://localhost:8081", Weight: 2}},
+				Rewrite: &Rewrite{Regex: `^/users/(?P<id>[^/]+)(?:/.*)?$`, Replacement: "/members/${id}${0}"},
+			},
+		},
+		{
+			name: "should be leave the targets of the different paths",
+			path: "/users/{id}",
+			proxy: &Proxy{Upstream: &Upstream{Targets: []*Target{
+				{Target: "http://localhost:8080/members/{id}"},
+				{Target: "http://localhost:8081/users/{id}"},
+			}}},
+			want: &Upstream{Targets: []*Target{
+				{Target: "http://localhost:8080/members/{id}"},
+				{Target: "http://localhost:8081/users/{id}"},
+			}},
+		},
+		{
+			name:  "should be leave the targets of the upstream that has rewrite",
+			path:  "/users/{id}",
+			proxy: &Proxy{Upstream: &Upstream{Target: "http://localhost:8080/members/{id}", StripPrefix: "/users"}},
+			want:  &Upstream{Target: "http://localhost:8080/members/{id}", StripPrefix: "/users"},
+		},
+		{
+			name: "should be error by the path variables of the split backend in the host",
+			path: "/users/{id}",
+			proxy: &Proxy{
+				Upstream: &Upstream{Target: "http://localhost:8080"},
+				Split:    &Split{Backends: []*Backend{{Name: "v2", Upstream: &Upstream{Target: "http://{id}.localhost:8080"}}}},
+			},
+			wantErr: "upstream target can have path variables only in the path. apis[0].proxy.split.backends[0].upstream.target",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.proxy.Path = tt.path
+			def := &Definition{Apis: []*Api{{Name: "test", Proxy: tt.proxy}}}
+			err := def.Migrate()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, tt.proxy.Upstream)
+		})
+	}
+}
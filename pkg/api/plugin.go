@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Plugin is a plugin applied to apis.
+// A plugin of an api or a group with the same name as an inherited plugin overrides the config of it,
+// and Disabled removes the inherited plugin.
+type Plugin struct {
+	Name     string                 `yaml:"name" valid:"required"`
+	Config   map[string]interface{} `yaml:"config"`
+	Disabled bool                   `yaml:"disabled"`
+
+	// Path is the location in the definition where the plugin is defined or lastly overridden.
+	Path string `yaml:"-"`
+}
+
+// ApiPlugins returns the plugins applied to the api in the order of global, groups and the api.
+// The overridden plugin keeps its position and its config is merged with the config of the override.
+func (d *Definition) ApiPlugins(a *Api) []*Plugin {
+	idx := -1
+	for i, v := range d.Apis {
+		if v == a {
+			idx = i
+			break
+		}
+	}
+
+	resolved := make([]*Plugin, 0)
+	resolved = inheritPlugins(resolved, d.Plugins, "plugins")
+	for _, g := range a.PluginGroups {
+		resolved = inheritPlugins(resolved, d.PluginGroups[g], fmt.Sprintf("pluginGroups.%s", g))
+	}
+	return inheritPlugins(resolved, a.Plugins, fmt.Sprintf("apis[%d].plugins", idx))
+}
+
+// inheritPlugins applies the plugins of a level on the plugins inherited from the previous levels.
+func inheritPlugins(inherited []*Plugin, plugins []*Plugin, path string) []*Plugin {
+	overridden := make(map[string]bool)
+	added := make([]*Plugin, 0)
+	for i, v := range plugins {
+		p := &Plugin{Name: v.Name, Config: v.Config, Disabled: v.Disabled, Path: fmt.Sprintf("%s[%d]", path, i)}
+		if p.Disabled {
+			for j, q := range inherited {
+				if q != nil && q.Name == p.Name {
+					inherited[j] = nil
+				}
+			}
+			continue
+		}
+		if !overridden[p.Name] {
+			if j := indexPlugin(inherited, p.Name); j >= 0 {
+				overridden[p.Name] = true
+				p.Config = mergeConfig(inherited[j].Config, p.Config)
+				inherited[j] = p
+				continue
+			}
+		}
+		added = append(added, p)
+	}
+
+	resolved := make([]*Plugin, 0, len(inherited)+len(added))
+	for _, p := range inherited {
+		if p != nil {
+			resolved = append(resolved, p)
+		}
+	}
+	return append(resolved, added...)
+}
+
+func indexPlugin(plugins []*Plugin, name string) int {
+	for i, p := range plugins {
+		if p != nil && p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeConfig overrides the top level keys of the inherited config.
+func mergeConfig(inherited, config map[string]interface{}) map[string]interface{} {
+	if len(inherited) == 0 {
+		return config
+	}
+	merged := make(map[string]interface{}, len(inherited)+len(config))
+	for k, v := range inherited {
+		merged[k] = v
+	}
+	for k, v := range config {
+		merged[k] = v
+	}
+	return merged
+}
+
+// validatePlugins validates the plugin declarations and the references to the plugin groups.
+func (d *Definition) validatePlugins() error {
+	groups := make([]string, 0, len(d.PluginGroups))
+	for g := range d.PluginGroups {
+		if g == "" {
+			return errors.New("plugin group name is required")
+		}
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	paths := []string{"plugins"}
+	levels := [][]*Plugin{d.Plugins}
+	for _, g := range groups {
+		paths = append(paths, fmt.Sprintf("pluginGroups.%s", g))
+		levels = append(levels, d.PluginGroups[g])
+	}
+	for i, a := range d.Apis {
+		paths = append(paths, fmt.Sprintf("apis[%d].plugins", i))
+		levels = append(levels, a.Plugins)
+	}
+	for i, plugins := range levels {
+		for j, p := range plugins {
+			if p.Name == "" {
+				return errors.New(fmt.Sprintf("plugin name is required. %s[%d]", paths[i], j))
+			}
+		}
+	}
+	for j, p := range d.Plugins {
+		if p.Disabled {
+			return errors.New(fmt.Sprintf("disabled plugin is not inherited. plugins[%d] plugin: %s", j, p.Name))
+		}
+	}
+
+	for i, a := range d.Apis {
+		inherited := make(map[string]bool)
+		for _, p := range d.Plugins {
+			inherited[p.Name] = true
+		}
+		check := func(path string, plugins []*Plugin) error {
+			added := make([]string, 0, len(plugins))
+			for j, p := range plugins {
+				if !p.Disabled {
+					added = append(added, p.Name)
+					continue
+				}
+				if !inherited[p.Name] {
+					return errors.New(fmt.Sprintf("disabled plugin is not inherited. name: %s %s[%d] plugin: %s", a.Name, path, j, p.Name))
+				}
+				delete(inherited, p.Name)
+			}
+			for _, name := range added {
+				inherited[name] = true
+			}
+			return nil
+		}
+		for _, g := range a.PluginGroups {
+			plugins, ok := d.PluginGroups[g]
+			if !ok {
+				return errors.New(fmt.Sprintf("plugin group is not found. name: %s group: %s", a.Name, g))
+			}
+			if err := check(fmt.Sprintf("pluginGroups.%s", g), plugins); err != nil {
+				return err
+			}
+		}
+		if err := check(fmt.Sprintf("apis[%d].plugins", i), a.Plugins); err != nil {
+			return err
+		}
+	}
+	return nil
+}
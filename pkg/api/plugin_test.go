@@ -0,0 +1,131 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefinition_ApiPlugins(t *testing.T) {
+	def := &Definition{
+		Plugins: []*Plugin{
+			{Name: "auth", Config: map[string]interface{}{"issuer": "global"}},
+			{Name: "rate", Config: map[string]interface{}{"limit": 10, "per": "s"}},
+		},
+		PluginGroups: map[string][]*Plugin{
+			"public": {
+				{Name: "auth", Disabled: true},
+				{Name: "cors"},
+			},
+			"heavy": {
+				{Name: "rate", Config: map[string]interface{}{"limit": 1}},
+			},
+		},
+	}
+	def.Apis = []*Api{
+		{Name: "default"},
+		{Name: "public", PluginGroups: []string{"public"}},
+		{Name: "heavy", PluginGroups: []string{"heavy"}, Plugins: []*Plugin{
+			{Name: "rate", Config: map[string]interface{}{"per": "m"}},
+			{Name: "rate"},
+			{Name: "headers"},
+		}},
+	}
+
+	tests := []struct {
+		name string
+		api  *Api
+		want []*Plugin
+	}{
+		{
+			name: "should be apply the global plugins",
+			api:  def.Apis[0],
+			want: []*Plugin{
+				{Name: "auth", Config: map[string]interface{}{"issuer": "global"}, Path: "plugins[0]"},
+				{Name: "rate", Config: map[string]interface{}{"limit": 10, "per": "s"}, Path: "plugins[1]"},
+			},
+		},
+		{
+			name: "should be disable the inherited plugin",
+			api:  def.Apis[1],
+			want: []*Plugin{
+				{Name: "rate", Config: map[string]interface{}{"limit": 10, "per": "s"}, Path: "plugins[1]"},
+				{Name: "cors", Path: "pluginGroups.public[1]"},
+			},
+		},
+		{
+			name: "should be override the config of the inherited plugin in order",
+			api:  def.Apis[2],
+			want: []*Plugin{
+				{Name: "auth", Config: map[string]interface{}{"issuer": "global"}, Path: "plugins[0]"},
+				{Name: "rate", Config: map[string]interface{}{"limit": 1, "per": "m"}, Path: "apis[2].plugins[0]"},
+				{Name: "rate", Path: "apis[2].plugins[1]"},
+				{Name: "headers", Path: "apis[2].plugins[2]"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, def.ApiPlugins(tt.api))
+		})
+	}
+
+	t.Run("should be not modify the definition", func(t *testing.T) {
+		def.ApiPlugins(def.Apis[2])
+		assert.Equal(t, map[string]interface{}{"limit": 10, "per": "s"}, def.Plugins[1].Config)
+		assert.Equal(t, map[string]interface{}{"limit": 1}, def.PluginGroups["heavy"][0].Config)
+	})
+}
+
+func TestDefinition_validatePlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		def     *Definition
+		wantErr string
+	}{
+		{
+			name: "should be valid",
+			def: &Definition{
+				Plugins:      []*Plugin{{Name: "rate"}},
+				PluginGroups: map[string][]*Plugin{"public": {{Name: "rate", Disabled: true}}},
+				Apis:         []*Api{{Name: "a", PluginGroups: []string{"public"}, Plugins: []*Plugin{{Name: "rate"}}}},
+			},
+		},
+		{
+			name: "should be error if the plugin name is empty",
+			def: &Definition{
+				PluginGroups: map[string][]*Plugin{"public": {{}}},
+				Apis:         []*Api{{Name: "a"}},
+			},
+			wantErr: "plugin name is required. pluginGroups.public[0]",
+		},
+		{
+			name:    "should be error if the group is not found",
+			def:     &Definition{Apis: []*Api{{Name: "a", PluginGroups: []string{"unknown"}}}},
+			wantErr: "plugin group is not found. name: a group: unknown",
+		},
+		{
+			name:    "should be error if the global plugin is disabled",
+			def:     &Definition{Plugins: []*Plugin{{Name: "rate", Disabled: true}}, Apis: []*Api{{Name: "a"}}},
+			wantErr: "disabled plugin is not inherited. plugins[0] plugin: rate",
+		},
+		{
+			name: "should be error if the disabled plugin is not inherited",
+			def: &Definition{
+				Plugins: []*Plugin{{Name: "rate"}},
+				Apis:    []*Api{{Name: "a", Plugins: []*Plugin{{Name: "cors"}, {Name: "cors", Disabled: true}}}},
+			},
+			wantErr: "disabled plugin is not inherited. name: a apis[0].plugins[1] plugin: cors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.def.validatePlugins()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SortedApis returns the apis in the order that the router matches them.
+// Apis with a higher priority come first, then more specific apis.
+// Apis of the same priority and specificity keep the order of the definition.
+func (d *Definition) SortedApis() []*Api {
+	apis := make([]*Api, len(d.Apis))
+	copy(apis, d.Apis)
+	sort.SliceStable(apis, func(i, j int) bool {
+		pi, pj := apis[i].Proxy, apis[j].Proxy
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		return compareSpecificity(pi, pj) > 0
+	})
+	return apis
+}
+
+// validateRoutes returns error if names are duplicated or an api is never matched
+// because another api in front of it matches every request of the api.
+func (d *Definition) validateRoutes() error {
+	names := make(map[string]struct{}, len(d.Apis))
+	for _, a := range d.Apis {
+		if _, ok := names[a.Name]; ok {
+			return errors.New(fmt.Sprintf("api name is duplicated. name: %s", a.Name))
+		}
+		names[a.Name] = struct{}{}
+	}
+
+	apis := d.SortedApis()
+	for i, a := range apis {
+		for _, b := range apis[:i] {
+			if !covers(b.Proxy, a.Proxy) {
+				continue
+			}
+			if covers(a.Proxy, b.Proxy) {
+				return errors.New(fmt.Sprintf("api has the same matchers as another api. name: %s other: %s", a.Name, b.Name))
+			}
+			return errors.New(fmt.Sprintf("api is shadowed by another api with higher precedence. name: %s other: %s", a.Name, b.Name))
+		}
+	}
+	return nil
+}
+
+// compareSpecificity returns a positive number if a is more specific than b,
+// a negative number if b is more specific, and zero otherwise.
+// Hosts are compared first, then the path, headers, queries, methods and schemes.
+func compareSpecificity(a, b *Proxy) int {
+	sa, sb := newPathSpec(a), newPathSpec(b)
+	cmps := []int{
+		hostRank(a.Hosts) - hostRank(b.Hosts),
+		boolRank(!sa.prefix) - boolRank(!sb.prefix),
+		sa.literals - sb.literals,
+		sa.literalLen - sb.literalLen,
+		sa.constrained - sb.constrained,
+		len(a.Headers) + len(a.Queries) - len(b.Headers) - len(b.Queries),
+		boolRank(len(a.Methods) > 0) - boolRank(len(b.Methods) > 0),
+		len(b.Methods) - len(a.Methods),
+		boolRank(len(a.Schemes) > 0) - boolRank(len(b.Schemes) > 0),
+	}
+	for _, c := range cmps {
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// hostRank ranks exact hosts over wildcard hosts over any host.
+func hostRank(hosts []string) int {
+	if len(hosts) == 0 {
+		return 0
+	}
+	for _, h := range hosts {
+		if strings.HasPrefix(h, "*.") {
+			return 1
+		}
+	}
+	return 2
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// pathSpec is the path of the proxy split into segments.
+// Variables are normalized to "{}" or "{:pattern}" since their names do not affect matching.
+type pathSpec struct {
+	segments    []string
+	prefix      bool
+	slash       bool
+	literals    int
+	constrained int
+	literalLen  int
+}
+
+func newPathSpec(p *Proxy) *pathSpec {
+	s := &pathSpec{prefix: p.PathType == "prefix"}
+	path := p.Path
+	if s.prefix && strings.HasSuffix(path, "/") {
+		// "/files/" matches only sub paths of "/files"
+		s.slash = true
+		path = strings.TrimSuffix(path, "/")
+	}
+	if path == "" {
+		return s
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if isVar(seg) {
+			if i := strings.IndexByte(seg, ':'); i > 0 {
+				seg = "{" + seg[i:]
+				s.constrained++
+			} else {
+				seg = "{}"
+			}
+		} else {
+			s.literals++
+			s.literalLen += len(seg) + 1
+		}
+		s.segments = append(s.segments, seg)
+	}
+	return s
+}
+
+func isVar(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && strings.Count(seg, "{") == 1
+}
+
+// covers reports whether every request matched by b is also matched by a.
+// It is conservative and returns false if it can not be decided.
+func covers(a, b *Proxy) bool {
+	return coversHosts(a.Hosts, b.Hosts) &&
+		coversSet(a.Methods, b.Methods) &&
+		coversSet(a.Schemes, b.Schemes) &&
+		coversPath(newPathSpec(a), newPathSpec(b)) &&
+		coversMatches(a.Headers, b.Headers, http.CanonicalHeaderKey) &&
+		coversMatches(a.Queries, b.Queries, func(s string) string { return s })
+}
+
+func coversHosts(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, bh := range b {
+		bh = strings.ToLower(bh)
+		found := false
+		for _, ah := range a {
+			ah = strings.ToLower(ah)
+			if ah == bh {
+				found = true
+				break
+			}
+			if strings.HasPrefix(ah, "*.") && !strings.HasPrefix(bh, "*.") {
+				if i := strings.IndexByte(bh, '.'); i > 0 && bh[i:] == ah[1:] {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func coversSet(a, b []string) bool {
+	if len(a) == 0 {
+		return true
+	}
+	if len(b) == 0 {
+		return false
+	}
+	for _, bv := range b {
+		found := false
+		for _, av := range a {
+			if strings.EqualFold(av, bv) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func coversPath(a, b *pathSpec) bool {
+	if !a.prefix {
+		return !b.prefix && len(a.segments) == len(b.segments) && coversSegments(a.segments, b.segments)
+	}
+	if len(b.segments) < len(a.segments) || !coversSegments(a.segments, b.segments[:len(a.segments)]) {
+		return false
+	}
+	if !a.slash {
+		return true
+	}
+	// the sub paths of b must be under the trailing slash of a
+	return len(b.segments) > len(a.segments) || b.slash
+}
+
+func coversSegments(a, b []string) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if a[i] == "{}" && b[i] != "" {
+			continue
+		}
+		if strings.HasPrefix(a[i], "{:") && !isVar(b[i]) {
+			pattern := strings.TrimSuffix(strings.TrimPrefix(a[i], "{:"), "}")
+			if ok, err := regexp.MatchString("^(?:"+pattern+")$", b[i]); err == nil && ok {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// coversMatches reports whether every match of a is satisfied by a match of b.
+func coversMatches(a, b []*Match, key func(string) string) bool {
+	for _, am := range a {
+		found := false
+		for _, bm := range b {
+			if key(am.Name) == key(bm.Name) && impliesMatch(bm, am) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// impliesMatch reports whether the values matched by b are also matched by a.
+func impliesMatch(b, a *Match) bool {
+	switch {
+	case a.Value == "" && a.Regex == "":
+		return true
+	case a.Value != "":
+		return b.Value == a.Value && b.Regex == ""
+	case b.Regex != "":
+		return b.Regex == a.Regex
+	case b.Value != "":
+		ok, err := regexp.MatchString(a.Regex, b.Value)
+		return err == nil && ok
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRouteApi(name string, p *Proxy) *Api {
+	p.Upstream = &Upstream{Target: "http://localhost:8080"}
+	return &Api{Name: name, Proxy: p}
+}
+
+func TestDefinition_SortedApis(t *testing.T) {
+	names := func(apis []*Api) []string {
+		ns := make([]string, 0, len(apis))
+		for _, a := range apis {
+			ns = append(ns, a.Name)
+		}
+		return ns
+	}
+
+	tests := []struct {
+		name string
+		apis []*Api
+		want []string
+	}{
+		{
+			name: "should be sort by priority",
+			apis: []*Api{
+				newRouteApi("low", &Proxy{Path: "/users/me"}),
+				newRouteApi("high", &Proxy{Path: "/users/{id}", Priority: 10}),
+			},
+			want: []string{"high", "low"},
+		},
+		{
+			name: "should be sort exact hosts before wildcard hosts",
+			apis: []*Api{
+				newRouteApi("any", &Proxy{Path: "/users"}),
+				newRouteApi("wildcard", &Proxy{Path: "/users", Hosts: []string{"*.example.com"}}),
+				newRouteApi("exact", &Proxy{Path: "/users", Hosts: []string{"api.example.com"}}),
+			},
+			want: []string{"exact", "wildcard", "any"},
+		},
+		{
+			name: "should be sort exact path before longer prefix before shorter prefix",
+			apis: []*Api{
+				newRouteApi("root", &Proxy{Path: "/", PathType: "prefix"}),
+				newRouteApi("users", &Proxy{Path: "/users", PathType: "prefix"}),
+				newRouteApi("orders", &Proxy{Path: "/users/{id}/orders", PathType: "prefix"}),
+				newRouteApi("me", &Proxy{Path: "/users/me"}),
+			},
+			want: []string{"me", "orders", "users", "root"},
+		},
+		{
+			name: "should be sort literal segments before variables",
+			apis: []*Api{
+				newRouteApi("any", &Proxy{Path: "/users/{name}"}),
+				newRouteApi("number", &Proxy{Path: "/users/{id:[0-9]+}"}),
+				newRouteApi("me", &Proxy{Path: "/users/me"}),
+			},
+			want: []string{"me", "number", "any"},
+		},
+		{
+			name: "should be sort by matchers and methods",
+			apis: []*Api{
+				newRouteApi("all", &Proxy{Path: "/users"}),
+				newRouteApi("read", &Proxy{Path: "/users", Methods: []string{"GET", "HEAD"}}),
+				newRouteApi("get", &Proxy{Path: "/users", Methods: []string{"GET"}}),
+				newRouteApi("v2", &Proxy{Path: "/users", Headers: []*Match{{Name: "Accept-Version", Value: "v2"}}}),
+			},
+			want: []string{"v2", "get", "read", "all"},
+		},
+		{
+			name: "should be keep the order of the definition if specificity is same",
+			apis: []*Api{
+				newRouteApi("b", &Proxy{Path: "/b"}),
+				newRouteApi("a", &Proxy{Path: "/a"}),
+			},
+			want: []string{"b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := &Definition{Apis: tt.apis}
+			assert.Equal(t, tt.want, names(def.SortedApis()))
+		})
+	}
+}
+
+func TestDefinition_validateRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		apis    []*Api
+		wantErr bool
+	}{
+		{
+			name: "should be error if names are duplicated",
+			apis: []*Api{
+				newRouteApi("users", &Proxy{Path: "/users"}),
+				newRouteApi("users", &Proxy{Path: "/members"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should be error if matchers are identical",
+			apis: []*Api{
+				newRouteApi("users", &Proxy{Path: "/users/{id}", Methods: []string{"GET"}}),
+				newRouteApi("members", &Proxy{Path: "/users/{uid}", Methods: []string{"GET"}}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should be error if specific api is shadowed by priority",
+			apis: []*Api{
+				newRouteApi("wildcard", &Proxy{Path: "/users", PathType: "prefix", Priority: 1}),
+				newRouteApi("me", &Proxy{Path: "/users/me", Hosts: []string{"api.example.com"}}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should be error if wildcard host with priority shadows exact host",
+			apis: []*Api{
+				newRouteApi("tenant", &Proxy{Path: "/users", Hosts: []string{"*.example.com"}, Priority: 1}),
+				newRouteApi("api", &Proxy{Path: "/users", Hosts: []string{"api.example.com"}, Headers: []*Match{{Name: "X-Debug"}}}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should be error if regex variable covers the literal path",
+			apis: []*Api{
+				newRouteApi("number", &Proxy{Path: "/users/{id:[0-9]+}", Priority: 1}),
+				newRouteApi("first", &Proxy{Path: "/users/1"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "should be valid if general api is behind specific api",
+			apis: []*Api{
+				newRouteApi("wildcard", &Proxy{Path: "/users", PathType: "prefix"}),
+				newRouteApi("me", &Proxy{Path: "/users/me"}),
+			},
+			wantErr: false,
+		},
+		{
+			name: "should be valid if apis match different requests",
+			apis: []*Api{
+				newRouteApi("get", &Proxy{Path: "/users", Methods: []string{"GET"}, Priority: 1}),
+				newRouteApi("post", &Proxy{Path: "/users", Methods: []string{"POST"}}),
+				newRouteApi("v2", &Proxy{Path: "/users", Headers: []*Match{{Name: "Accept-Version", Regex: "^v2"}}, Priority: 1}),
+				newRouteApi("v1", &Proxy{Path: "/users", Headers: []*Match{{Name: "Accept-Version", Value: "v1"}}}),
+				newRouteApi("files", &Proxy{Path: "/files/", PathType: "prefix", Priority: 1}),
+				newRouteApi("file root", &Proxy{Path: "/files"}),
+			},
+			wantErr: false,
+		},
+		{
+			name: "should be error if regex header covers the value header",
+			apis: []*Api{
+				newRouteApi("v2", &Proxy{Path: "/users", Headers: []*Match{{Name: "Accept-Version", Regex: "^v2"}}, Priority: 1}),
+				newRouteApi("v2.1", &Proxy{Path: "/users", Headers: []*Match{{Name: "accept-version", Value: "v2.1"}}}),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def := &Definition{Apis: tt.apis}
+			ok, err := def.Validate()
+			if tt.wantErr {
+				assert.False(t, ok)
+				assert.Error(t, err)
+			} else {
+				assert.True(t, ok)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
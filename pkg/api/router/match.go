@@ -0,0 +1,152 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// matcher matches the request by headers, queries and schemes of the api.
+// Hosts are matched by the tree.
+type matcher struct {
+	headers []*valueMatcher
+	queries []*valueMatcher
+	schemes []string
+}
+
+type valueMatcher struct {
+	name  string
+	value string
+	reg   *regexp.Regexp
+}
+
+// newMatcher creates the matcher of the proxy definition.
+// It returns nil if the proxy has nothing to match other than path, methods and hosts.
+func newMatcher(p *api.Proxy) (*matcher, error) {
+	if len(p.Headers) == 0 && len(p.Queries) == 0 && len(p.Schemes) == 0 {
+		return nil, nil
+	}
+
+	m := &matcher{}
+	for _, s := range p.Schemes {
+		m.schemes = append(m.schemes, strings.ToLower(s))
+	}
+
+	var err error
+	if m.headers, err = newValueMatchers(p.Headers, http.CanonicalHeaderKey); err != nil {
+		return nil, err
+	}
+	if m.queries, err = newValueMatchers(p.Queries, func(s string) string { return s }); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newValueMatchers(matches []*api.Match, key func(string) string) ([]*valueMatcher, error) {
+	vms := make([]*valueMatcher, 0, len(matches))
+	for _, m := range matches {
+		vm := &valueMatcher{name: key(m.Name), value: m.Value}
+		if m.Regex != "" {
+			reg, err := regexp.Compile(m.Regex)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("could not compile match regex. name: %s", m.Name))
+			}
+			vm.reg = reg
+		}
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// Match reports whether the request matches all conditions.
+func (m *matcher) Match(r *http.Request) bool {
+	if len(m.schemes) > 0 && !matchScheme(m.schemes, r) {
+		return false
+	}
+	for _, vm := range m.headers {
+		if !vm.match(r.Header[vm.name]) {
+			return false
+		}
+	}
+	for _, vm := range m.queries {
+		if !vm.matchQuery(r.URL.RawQuery) {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether one of the values matches.
+func (vm *valueMatcher) match(values []string) bool {
+	for _, v := range values {
+		if vm.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchQuery reports whether one of the values of the name in the raw query matches.
+// The query is scanned instead of parsed into url.Values so that it does not allocate
+// unless the pairs are escaped. The pairs that url.ParseQuery rejects are ignored.
+func (vm *valueMatcher) matchQuery(query string) bool {
+	for query != "" {
+		pair := query
+		if i := strings.IndexByte(query, '&'); i >= 0 {
+			pair, query = query[:i], query[i+1:]
+		} else {
+			query = ""
+		}
+		if pair == "" || strings.IndexByte(pair, ';') >= 0 {
+			continue
+		}
+		key, value := pair, ""
+		if i := strings.IndexByte(pair, '='); i >= 0 {
+			key, value = pair[:i], pair[i+1:]
+		}
+		if k, ok := unescapeQuery(key); !ok || k != vm.name {
+			continue
+		}
+		if v, ok := unescapeQuery(value); ok && vm.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValue reports whether the value matches.
+// Only the presence is checked if there is neither value nor regex.
+func (vm *valueMatcher) matchValue(v string) bool {
+	if vm.reg != nil {
+		return vm.reg.MatchString(v)
+	}
+	return vm.value == "" || vm.value == v
+}
+
+func unescapeQuery(s string) (string, bool) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, true
+	}
+	s, err := url.QueryUnescape(s)
+	return s, err == nil
+}
+
+// matchScheme matches the scheme of the connection to the gateway.
+func matchScheme(schemes []string, r *http.Request) bool {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	for _, s := range schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
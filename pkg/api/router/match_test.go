@@ -0,0 +1,121 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func TestMatcher_Match(t *testing.T) {
+	tests := []struct {
+		name  string
+		proxy *api.Proxy
+		req   func() *http.Request
+		want  bool
+	}{
+		{
+			name:  "should be match the exact header",
+			proxy: &api.Proxy{Headers: []*api.Match{{Name: "accept-version", Value: "v2"}}},
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Accept-Version", "v2")
+				return r
+			},
+			want: true,
+		},
+		{
+			name:  "should be not match the different header",
+			proxy: &api.Proxy{Headers: []*api.Match{{Name: "Accept-Version", Value: "v2"}}},
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Accept-Version", "v1")
+				return r
+			},
+			want: false,
+		},
+		{
+			name:  "should be match the header regex",
+			proxy: &api.Proxy{Headers: []*api.Match{{Name: "Accept-Version", Regex: `^v2(\.\d+)?$`}}},
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Accept-Version", "v2.1")
+				return r
+			},
+			want: true,
+		},
+		{
+			name:  "should be match the presence of header",
+			proxy: &api.Proxy{Headers: []*api.Match{{Name: "X-Debug"}}},
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("X-Debug", "")
+				return r
+			},
+			want: true,
+		},
+		{
+			name:  "should be not match the absence of header",
+			proxy: &api.Proxy{Headers: []*api.Match{{Name: "X-Debug"}}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			want:  false,
+		},
+		{
+			name:  "should be match the query",
+			proxy: &api.Proxy{Queries: []*api.Match{{Name: "version", Value: "2"}, {Name: "debug"}}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/?version=2&debug", nil) },
+			want:  true,
+		},
+		{
+			name:  "should be match the escaped query",
+			proxy: &api.Proxy{Queries: []*api.Match{{Name: "q k", Regex: `^a b$`}}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/?x=1&q+k=a%20b", nil) },
+			want:  true,
+		},
+		{
+			name:  "should be match one of the repeated queries",
+			proxy: &api.Proxy{Queries: []*api.Match{{Name: "version", Value: "2"}}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/?version=1&&version=2", nil) },
+			want:  true,
+		},
+		{
+			name:  "should be not match the missing query",
+			proxy: &api.Proxy{Queries: []*api.Match{{Name: "version", Value: "2"}}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			want:  false,
+		},
+		{
+			name:  "should be match the https scheme",
+			proxy: &api.Proxy{Schemes: []string{"https"}},
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.TLS = &tls.ConnectionState{}
+				return r
+			},
+			want: true,
+		},
+		{
+			name:  "should be not match the http scheme",
+			proxy: &api.Proxy{Schemes: []string{"https"}},
+			req:   func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.proxy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, m.Match(tt.req()))
+		})
+	}
+
+	t.Run("should be nil if there is nothing to match", func(t *testing.T) {
+		m, err := newMatcher(&api.Proxy{Path: "/"})
+		assert.NoError(t, err)
+		assert.Nil(t, m)
+	})
+}
@@ -1,53 +1,82 @@
 package router
 
 import (
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strings"
 
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/clientauth"
 	"github.com/purini-to/plixy/pkg/middleware"
+	"github.com/purini-to/plixy/pkg/mirror"
+	"github.com/purini-to/plixy/pkg/upstream"
 
 	"github.com/purini-to/plixy/pkg/plugin"
 
 	"github.com/purini-to/plixy/pkg/api"
 
+	"github.com/purini-to/plixy/pkg/health"
 	"github.com/purini-to/plixy/pkg/httperr"
 	"github.com/purini-to/plixy/pkg/log"
 	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 
-	"github.com/gorilla/mux"
 	"github.com/purini-to/plixy/pkg/config"
 	pstats "github.com/purini-to/plixy/pkg/stats"
 )
 
 type Route struct {
-	api *api.Api
-	mw  []func(next http.Handler) http.Handler
+	api        *api.Api
+	split      *upstream.Split
+	mirror     *mirror.Mirror
+	hooks      *plugin.Hooks
+	clientAuth *clientauth.Policy
+	mw         []func(next http.Handler) http.Handler
+	closers    []io.Closer
 }
 
+// Router routes the request to the api by the tree of the api definition.
 type Router struct {
-	apiConfigMap map[string]*Route
-	mux          *mux.Router
+	routes []*Route
+	tree   *tree
 }
 
 func (r *Router) WithApiDefinition(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, req *http.Request) {
-		var match mux.RouteMatch
-		if ok := r.mux.Match(req, &match); !ok || match.MatchErr != nil {
-			httperr.Error(w, req, match.MatchErr)
+		e, mismatch := r.tree.lookup(req)
+		if e == nil {
+			if mismatch {
+				httperr.MethodNotAllowed(w)
+				return
+			}
+			httperr.NotFound(w)
 			return
 		}
+		v := e.route
+		apiDef := v.api
 
-		v, ok := r.apiConfigMap[match.Route.GetName()]
-		if !ok {
-			httperr.NotFound(w)
+		id := clientauth.FromRequest(req)
+		if v.clientAuth != nil && !v.clientAuth.Allow(id) {
+			log.FromContext(req.Context()).Debug("Reject client certificate", zap.String("name", apiDef.Name))
+			httperr.Forbidden(w)
 			return
 		}
-		apiDef := v.api
 
 		ctx := api.ToContext(req.Context(), apiDef)
-		ctx = api.VarsToContext(ctx, match.Vars)
-		log.FromContext(ctx).Debug("Match proxy api", zap.String("name", apiDef.Name))
+		ctx = api.VarsToContext(ctx, e.vars(req.URL.Path))
+		up := v.split.Select(req)
+		ctx = upstream.ToContext(ctx, up)
+		if v.mirror != nil {
+			ctx = mirror.ToContext(ctx, v.mirror)
+		}
+		if v.hooks != nil {
+			ctx = plugin.HooksToContext(ctx, v.hooks)
+		}
+		log.FromContext(ctx).Debug("Match proxy api", zap.String("name", apiDef.Name), zap.String("upstream", up.Name()))
 		if config.Global.Stats.Enable {
 			ctx, _ = tag.New(ctx, tag.Upsert(pstats.KeyApiName, apiDef.Name))
 		}
@@ -58,6 +87,7 @@ func (r *Router) WithApiDefinition(next http.Handler) http.Handler {
 		}
 
 		req.Header.Set(api.NameHeaderKey, apiDef.Name)
+		clientauth.SetHeaders(req.Header, id)
 
 		req = req.WithContext(ctx)
 		//next.ServeHTTP(w, req)
@@ -68,26 +98,133 @@ func (r *Router) WithApiDefinition(next http.Handler) http.Handler {
 
 func NewRouter(def *api.Definition) (*Router, error) {
 	r := &Router{
-		apiConfigMap: make(map[string]*Route, 0),
+		routes: make([]*Route, 0, len(def.Apis)),
+		tree:   newTree(),
 	}
 
-	m := mux.NewRouter()
-	for _, a := range def.Apis {
-		rt := m.Name(a.Name).Path(a.Proxy.Path)
-		if len(a.Proxy.Methods) > 0 {
-			rt = rt.Methods(a.Proxy.Methods...)
+	for i, a := range def.SortedApis() {
+		e, err := newEntry(i, a)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		if err = r.tree.add(e, a.Proxy.Path, a.Proxy.Hosts); err != nil {
+			_ = r.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("could not add route. name: %s", a.Name))
 		}
 
-		handlers, err := plugin.BuildBeforeProxy(a.Plugins)
+		plugins := def.ApiPlugins(a)
+		handlers, closers, err := plugin.BuildBeforeProxy(plugins)
 		if err != nil {
+			_ = r.Close()
 			return nil, err
 		}
-		r.apiConfigMap[a.Name] = &Route{
-			api: a,
-			mw:  handlers,
+		hooks, err := plugin.BuildHooks(a, plugins)
+		if err != nil {
+			_ = plugin.Close(closers)
+			_ = r.Close()
+			return nil, err
+		}
+
+		var policy *clientauth.Policy
+		if a.Proxy.ClientAuth != nil {
+			if policy, err = clientauth.NewPolicy(a.Proxy.ClientAuth); err != nil {
+				_ = plugin.Close(closers)
+				_ = r.Close()
+				return nil, errors.Wrap(err, fmt.Sprintf("could not new client auth policy. name: %s", a.Name))
+			}
+		}
+
+		split, err := upstream.NewSplit(a.Name, a.Proxy)
+		if err != nil {
+			_ = plugin.Close(closers)
+			_ = r.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("could not new upstream. name: %s", a.Name))
+		}
+		var m *mirror.Mirror
+		if a.Proxy.Mirror != nil {
+			if m, err = mirror.New(a.Name, a.Proxy.Mirror); err != nil {
+				_ = split.Close()
+				_ = plugin.Close(closers)
+				_ = r.Close()
+				return nil, errors.Wrap(err, fmt.Sprintf("could not new mirror. name: %s", a.Name))
+			}
+		}
+		e.route = &Route{
+			api:        a,
+			split:      split,
+			mirror:     m,
+			hooks:      hooks,
+			clientAuth: policy,
+			mw:         handlers,
+			closers:    closers,
 		}
+		r.routes = append(r.routes, e.route)
 	}
-	r.mux = m
 
 	return r, nil
 }
+
+// newEntry creates the tree entry of the api ranked by the order of the definition.
+func newEntry(rank int, a *api.Api) (*entry, error) {
+	m, err := newMatcher(a.Proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not new matcher. name: %s", a.Name))
+	}
+	methods := make([]string, 0, len(a.Proxy.Methods))
+	for _, method := range a.Proxy.Methods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+	return &entry{
+		rank:    rank,
+		prefix:  a.Proxy.PathType == "prefix",
+		methods: methods,
+		matcher: m,
+	}, nil
+}
+
+// TargetStatuses returns the health status of all upstream targets.
+func (r *Router) TargetStatuses() []*health.TargetStatus {
+	statuses := make([]*health.TargetStatus, 0)
+	for _, rt := range r.routes {
+		ups := rt.split.Upstreams()
+		if rt.mirror != nil {
+			ups = append(ups[:len(ups):len(ups)], rt.mirror.Upstream())
+		}
+		for _, up := range ups {
+			for _, t := range up.Targets() {
+				statuses = append(statuses, &health.TargetStatus{
+					Api:     up.Name(),
+					Target:  t.URL.String(),
+					Healthy: t.Healthy(),
+					Ejected: t.Ejected(),
+				})
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Api != statuses[j].Api {
+			return statuses[i].Api < statuses[j].Api
+		}
+		return statuses[i].Target < statuses[j].Target
+	})
+	return statuses
+}
+
+// Close stops the upstreams of the router and releases the resources of the plugins.
+func (r *Router) Close() error {
+	for _, rt := range r.routes {
+		if err := rt.split.Close(); err != nil {
+			return err
+		}
+		if rt.mirror != nil {
+			if err := rt.mirror.Close(); err != nil {
+				return err
+			}
+		}
+		if err := plugin.Close(rt.closers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
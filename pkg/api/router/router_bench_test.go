@@ -0,0 +1,174 @@
+package router
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// benchApis returns the apis of n services with static, variable and prefix paths.
+func benchApis(n int) []*api.Api {
+	apis := make([]*api.Api, 0, n*4)
+	for i := 0; i < n; i++ {
+		svc := fmt.Sprintf("/services/svc%d", i)
+		apis = append(apis,
+			&api.Api{Name: fmt.Sprintf("list%d", i), Proxy: &api.Proxy{Path: svc + "/users", Methods: []string{"GET"}}},
+			&api.Api{Name: fmt.Sprintf("get%d", i), Proxy: &api.Proxy{Path: svc + "/users/{id}", Methods: []string{"GET"}}},
+			&api.Api{Name: fmt.Sprintf("orders%d", i), Proxy: &api.Proxy{Path: svc + "/users/{id}/orders/{orderId:[0-9]+}"}},
+			&api.Api{Name: fmt.Sprintf("files%d", i), Proxy: &api.Proxy{Path: svc + "/files", PathType: "prefix"}},
+		)
+	}
+	return apis
+}
+
+// newMuxRouter builds the gorilla/mux router as the router did before the tree.
+func newMuxRouter(apis []*api.Api) (*mux.Router, map[string]*api.Api) {
+	m := mux.NewRouter()
+	names := make(map[string]*api.Api, len(apis))
+	for _, a := range apis {
+		var rt *mux.Route
+		if a.Proxy.PathType == "prefix" {
+			rt = m.Name(a.Name).PathPrefix(a.Proxy.Path)
+		} else {
+			rt = m.Name(a.Name).Path(a.Proxy.Path)
+		}
+		if len(a.Proxy.Methods) > 0 {
+			rt.Methods(a.Proxy.Methods...)
+		}
+		names[a.Name] = a
+	}
+	return m, names
+}
+
+var benchPaths = []string{
+	"/services/svc%d/users",
+	"/services/svc%d/users/123",
+	"/services/svc%d/users/123/orders/456",
+	"/services/svc%d/files/a/b/c.txt",
+}
+
+func benchmarkTree(b *testing.B, n int) {
+	tr := newTestTree(b, benchApis(n)...)
+	req := httptest.NewRequest("GET", fmt.Sprintf(benchPaths[2], n-1), nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if e, _ := tr.lookup(req); e == nil {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func benchmarkMux(b *testing.B, n int) {
+	m, names := newMuxRouter(benchApis(n))
+	req := httptest.NewRequest("GET", fmt.Sprintf(benchPaths[2], n-1), nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var match mux.RouteMatch
+		if !m.Match(req, &match) {
+			b.Fatal("not found")
+		}
+		if _, ok := names[match.Route.GetName()]; !ok {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func BenchmarkTree_Lookup10(b *testing.B)  { benchmarkTree(b, 10) }
+func BenchmarkTree_Lookup100(b *testing.B) { benchmarkTree(b, 100) }
+func BenchmarkTree_Lookup500(b *testing.B) { benchmarkTree(b, 500) }
+func BenchmarkMux_Match10(b *testing.B)    { benchmarkMux(b, 10) }
+func BenchmarkMux_Match100(b *testing.B)   { benchmarkMux(b, 100) }
+func BenchmarkMux_Match500(b *testing.B)   { benchmarkMux(b, 500) }
+
+// allBenchPaths returns the paths of all apis of n services.
+func allBenchPaths(n int) []string {
+	paths := make([]string, 0, len(benchPaths)*n)
+	for i := 0; i < n; i++ {
+		for _, p := range benchPaths {
+			paths = append(paths, fmt.Sprintf(p, i))
+		}
+	}
+	return paths
+}
+
+func BenchmarkTree_LookupAll(b *testing.B) {
+	tr := newTestTree(b, benchApis(100)...)
+	paths := allBenchPaths(100)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req.URL.Path = paths[i%len(paths)]
+		if e, _ := tr.lookup(req); e == nil {
+			b.Fatal("not found")
+		}
+	}
+}
+
+func BenchmarkMux_MatchAll(b *testing.B) {
+	m, names := newMuxRouter(benchApis(100))
+	paths := allBenchPaths(100)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req.URL.Path = paths[i%len(paths)]
+		var match mux.RouteMatch
+		if !m.Match(req, &match) {
+			b.Fatal("not found")
+		}
+		if _, ok := names[match.Route.GetName()]; !ok {
+			b.Fatal("not found")
+		}
+	}
+}
+
+// BenchmarkTree_LookupVars includes building the path variables of the matched route.
+// The lookup does not allocate, but the variables allocate a map per routed request
+// as it is passed to the plugins by the context, and the pattern variables also allocate by the regexp.
+func BenchmarkTree_LookupVars(b *testing.B) {
+	tr := newTestTree(b, benchApis(100)...)
+	req := httptest.NewRequest("GET", fmt.Sprintf(benchPaths[2], 99), nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e, _ := tr.lookup(req)
+		if e == nil {
+			b.Fatal("not found")
+		}
+		if vars := e.vars(req.URL.Path); vars["orderId"] != "456" {
+			b.Fatal("vars not found")
+		}
+	}
+}
+
+// BenchmarkTree_LookupQuery matches the routes by the query without allocations unless the query is escaped.
+func BenchmarkTree_LookupQuery(b *testing.B) {
+	apis := benchApis(100)
+	apis = append(apis, &api.Api{Name: "v2", Proxy: &api.Proxy{
+		Path:    "/services/svc99/users",
+		Methods: []string{"GET"},
+		Queries: []*api.Match{{Name: "version", Value: "2"}},
+	}})
+	tr := newTestTree(b, apis...)
+	req := httptest.NewRequest("GET", "/services/svc99/users?debug=1&version=2", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if e, _ := tr.lookup(req); e == nil || e.route.api.Name != "v2" {
+			b.Fatal("not found")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+func TestRouter_WithApiDefinition(t *testing.T) {
+	newApi := func(name string, p *api.Proxy) *api.Api {
+		p.Path = "/users"
+		p.Upstream = &api.Upstream{Target: "http://localhost:8080"}
+		return &api.Api{Name: name, Proxy: p}
+	}
+	rt, err := NewRouter(&api.Definition{Apis: []*api.Api{
+		newApi("tenant-v2", &api.Proxy{Hosts: []string{"*.example.com"}, Headers: []*api.Match{{Name: "Accept-Version", Value: "v2"}}}),
+		newApi("tenant", &api.Proxy{Hosts: []string{"*.example.com"}}),
+		newApi("default", &api.Proxy{}),
+	}})
+	assert.NoError(t, err)
+	defer rt.Close()
+
+	h := rt.WithApiDefinition(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(api.FromContext(r.Context()).Name))
+	}))
+
+	tests := []struct {
+		name    string
+		host    string
+		version string
+		want    string
+	}{
+		{name: "should be route by host and header", host: "a.example.com", version: "v2", want: "tenant-v2"},
+		{name: "should be route by host", host: "a.example.com", want: "tenant"},
+		{name: "should be route to the api without matchers", host: "other.test", version: "v2", want: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://"+tt.host+"/users", nil)
+			req = req.WithContext(log.ToContext(req.Context(), zap.NewNop()))
+			if tt.version != "" {
+				req.Header.Set("Accept-Version", tt.version)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.want, rec.Body.String())
+		})
+	}
+}
+
+func TestRouter_PathPrefix(t *testing.T) {
+	rt, err := NewRouter(&api.Definition{Apis: []*api.Api{
+		{Name: "users", Proxy: &api.Proxy{Path: "/users", PathType: "prefix", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+		{Name: "files", Proxy: &api.Proxy{Path: "/files/", PathType: "prefix", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+		{Name: "tenant", Proxy: &api.Proxy{Path: "/tenants/{id}", PathType: "prefix", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+	}})
+	assert.NoError(t, err)
+	defer rt.Close()
+
+	h := rt.WithApiDefinition(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(api.FromContext(r.Context()).Name))
+	}))
+
+	tests := []struct {
+		name string
+		path string
+		code int
+		want string
+	}{
+		{name: "should be match the prefix itself", path: "/users", code: http.StatusOK, want: "users"},
+		{name: "should be match sub paths", path: "/users/123/orders", code: http.StatusOK, want: "users"},
+		{name: "should be not match partial segment", path: "/usersX", code: http.StatusNotFound},
+		{name: "should be match sub paths of prefix with trailing slash", path: "/files/a/b", code: http.StatusOK, want: "files"},
+		{name: "should be match prefix with variables", path: "/tenants/1/users", code: http.StatusOK, want: "tenant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req = req.WithContext(log.ToContext(req.Context(), zap.NewNop()))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouter_Precedence(t *testing.T) {
+	rt, err := NewRouter(&api.Definition{Apis: []*api.Api{
+		{Name: "users", Proxy: &api.Proxy{Path: "/users", PathType: "prefix", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+		{Name: "me", Proxy: &api.Proxy{Path: "/users/me", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+		{Name: "orders", Proxy: &api.Proxy{Path: "/users/{id}/orders", Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+		{Name: "admin", Proxy: &api.Proxy{Path: "/users/{id}/{resource}", Methods: []string{"GET"}, Priority: 1, Upstream: &api.Upstream{Target: "http://localhost:8080"}}},
+	}})
+	assert.NoError(t, err)
+	defer rt.Close()
+
+	h := rt.WithApiDefinition(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(api.FromContext(r.Context()).Name))
+	}))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "should be match the specific api before the prefix api", path: "/users/me", want: "me"},
+		{name: "should be match the prefix api", path: "/users/1", want: "users"},
+		{name: "should be match the api with higher priority", path: "/users/1/orders", want: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req = req.WithContext(log.ToContext(req.Context(), zap.NewNop()))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tree is the routing table indexed by host and path segments.
+// Routes with exact hosts, wildcard hosts and without hosts are kept in separate tries,
+// and the route with the lowest rank among all tries wins.
+type tree struct {
+	hosts     map[string]*node
+	wildcards map[string]*node
+	any       *node
+}
+
+// node is a path segment of the trie.
+// Static children are looked up by the segment, and variables are tried in order.
+type node struct {
+	static   map[string]*node
+	patterns []*node
+	param    *node
+	reg      *regexp.Regexp
+	exact    []*entry
+	prefixes []*entry
+}
+
+// entry is a route registered in the tree.
+// rank is the order of the api in api.Definition.SortedApis.
+type entry struct {
+	route    *Route
+	rank     int
+	segments []*segment
+	prefix   bool
+	slash    bool
+	methods  []string
+	matcher  *matcher
+	hasVars  bool
+	varCount int
+}
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	patternSegment
+)
+
+// segment is a parsed path segment of the route.
+// A param segment is a single variable such as "{id}", and a pattern segment
+// is compiled to a regular expression such as "{id:[0-9]+}" or "file.{ext}".
+type segment struct {
+	kind   segmentKind
+	raw    string
+	names  []string
+	groups []int
+	reg    *regexp.Regexp
+}
+
+type search struct {
+	req      *http.Request
+	path     string
+	best     *entry
+	mismatch bool
+}
+
+func newTree() *tree {
+	return &tree{
+		hosts:     make(map[string]*node),
+		wildcards: make(map[string]*node),
+		any:       newNode(),
+	}
+}
+
+func newNode() *node {
+	return &node{static: make(map[string]*node)}
+}
+
+// add registers the entry of the path to the tries of the hosts.
+func (t *tree) add(e *entry, path string, hosts []string) error {
+	if e.prefix && strings.HasSuffix(path, "/") {
+		// "/files/" matches only sub paths of "/files"
+		e.slash = true
+		path = strings.TrimSuffix(path, "/")
+	}
+	segments, err := parseSegments(path)
+	if err != nil {
+		return err
+	}
+	e.segments = segments
+	for _, s := range segments {
+		if s.kind != staticSegment {
+			e.hasVars = true
+			e.varCount += len(s.names)
+		}
+	}
+
+	roots := make([]*node, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		m, key := t.hosts, h
+		if strings.HasPrefix(h, "*.") {
+			m, key = t.wildcards, h[1:]
+		}
+		if _, ok := m[key]; !ok {
+			m[key] = newNode()
+		}
+		roots = append(roots, m[key])
+	}
+	if len(hosts) == 0 {
+		roots = append(roots, t.any)
+	}
+
+	for _, n := range roots {
+		for _, s := range segments {
+			n = n.child(s)
+		}
+		if e.prefix {
+			n.prefixes = append(n.prefixes, e)
+		} else {
+			n.exact = append(n.exact, e)
+		}
+	}
+	return nil
+}
+
+func (n *node) child(s *segment) *node {
+	switch s.kind {
+	case paramSegment:
+		if n.param == nil {
+			n.param = newNode()
+		}
+		return n.param
+	case patternSegment:
+		for _, c := range n.patterns {
+			if c.reg.String() == s.reg.String() {
+				return c
+			}
+		}
+		c := newNode()
+		c.reg = s.reg
+		n.patterns = append(n.patterns, c)
+		return c
+	default:
+		c, ok := n.static[s.raw]
+		if !ok {
+			c = newNode()
+			n.static[s.raw] = c
+		}
+		return c
+	}
+}
+
+// lookup returns the matched entry of the request.
+// mismatch is true if no entry matched but an entry matched except for the method.
+func (t *tree) lookup(req *http.Request) (e *entry, mismatch bool) {
+	s := search{req: req, path: req.URL.Path}
+	if !strings.HasPrefix(s.path, "/") {
+		return nil, false
+	}
+
+	host := normalizeHost(req.Host)
+	if n, ok := t.hosts[host]; ok {
+		s.walk(n, 1)
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		if n, ok := t.wildcards[host[i:]]; ok {
+			s.walk(n, 1)
+		}
+	}
+	s.walk(t.any, 1)
+	return s.best, s.best == nil && s.mismatch
+}
+
+// walk matches the path from the start of the segment at i.
+// i is -1 if the whole path is consumed.
+func (s *search) walk(n *node, i int) {
+	for _, e := range n.prefixes {
+		if i >= 0 || !e.slash {
+			s.try(e)
+		}
+	}
+	if i < 0 {
+		for _, e := range n.exact {
+			s.try(e)
+		}
+		return
+	}
+
+	end, next := len(s.path), -1
+	if j := strings.IndexByte(s.path[i:], '/'); j >= 0 {
+		end, next = i+j, i+j+1
+	}
+	seg := s.path[i:end]
+
+	if c, ok := n.static[seg]; ok {
+		s.walk(c, next)
+	}
+	for _, c := range n.patterns {
+		if c.reg.MatchString(seg) {
+			s.walk(c, next)
+		}
+	}
+	if n.param != nil && seg != "" {
+		s.walk(n.param, next)
+	}
+}
+
+// try keeps the entry if it matches the request and has lower rank than the current one.
+func (s *search) try(e *entry) {
+	if s.best != nil && s.best.rank <= e.rank {
+		return
+	}
+	if e.matcher != nil && !e.matcher.Match(s.req) {
+		return
+	}
+	if len(e.methods) > 0 && !containsMethod(e.methods, s.req.Method) {
+		s.mismatch = true
+		return
+	}
+	s.best = e
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// vars returns the path variables of the matched path.
+// It returns nil if the route has no variables.
+func (e *entry) vars(path string) map[string]string {
+	if !e.hasVars {
+		return nil
+	}
+	vars := make(map[string]string, e.varCount)
+	path = strings.TrimPrefix(path, "/")
+	for _, s := range e.segments {
+		seg := path
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			seg, path = path[:i], path[i+1:]
+		}
+		switch s.kind {
+		case paramSegment:
+			vars[s.names[0]] = seg
+		case patternSegment:
+			match := s.reg.FindStringSubmatch(seg)
+			for i, name := range s.names {
+				if s.groups[i] < len(match) {
+					vars[name] = match[s.groups[i]]
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// normalizeHost returns the lower case host without the port and the trailing dot.
+func normalizeHost(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// parseSegments parses the path template such as "/users/{id:[0-9]+}/orders".
+// Variables match only within a segment.
+func parseSegments(path string) ([]*segment, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New(fmt.Sprintf("path must be start with '/'. path: %s", path))
+	}
+
+	segments := make([]*segment, 0)
+	depth, start := 0, 1
+	for i := 1; i <= len(path); i++ {
+		if i < len(path) {
+			switch path[i] {
+			case '{':
+				depth++
+				continue
+			case '}':
+				depth--
+				if depth < 0 {
+					return nil, errors.New(fmt.Sprintf("unbalanced braces in path. path: %s", path))
+				}
+				continue
+			case '/':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if depth != 0 {
+			return nil, errors.New(fmt.Sprintf("unbalanced braces in path. path: %s", path))
+		}
+		s, err := parseSegment(path[start:i])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse path. path: %s", path))
+		}
+		segments = append(segments, s)
+		start = i + 1
+	}
+	return segments, nil
+}
+
+func parseSegment(raw string) (*segment, error) {
+	if !strings.Contains(raw, "{") {
+		return &segment{kind: staticSegment, raw: raw}, nil
+	}
+
+	s := &segment{raw: raw}
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	depth, start := 0, 0
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '{':
+			if depth == 0 {
+				pattern.WriteString(regexp.QuoteMeta(raw[start:i]))
+				start = i + 1
+			}
+			depth++
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			name, expr := raw[start:i], "[^/]+"
+			if j := strings.IndexByte(name, ':'); j >= 0 {
+				name, expr = name[:j], name[j+1:]
+			}
+			if name == "" {
+				return nil, errors.New(fmt.Sprintf("variable name is required. segment: %s", raw))
+			}
+			pattern.WriteString(fmt.Sprintf("(?P<v%d>%s)", len(s.names), expr))
+			s.names = append(s.names, name)
+			start = i + 1
+		}
+	}
+	pattern.WriteString(regexp.QuoteMeta(raw[start:]))
+	pattern.WriteString("$")
+
+	if len(s.names) == 1 && raw == "{"+s.names[0]+"}" {
+		s.kind = paramSegment
+		return s, nil
+	}
+	reg, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not compile variable pattern. segment: %s", raw))
+	}
+	s.kind = patternSegment
+	s.reg = reg
+	// the index of the group of each variable, since the pattern may have its own groups
+	s.groups = make([]int, len(s.names))
+	for i := range s.groups {
+		key := fmt.Sprintf("v%d", i)
+		for j, name := range reg.SubexpNames() {
+			if name == key {
+				s.groups[i] = j
+				break
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func newTestTree(t testing.TB, apis ...*api.Api) *tree {
+	tr := newTree()
+	def := &api.Definition{Apis: apis}
+	for i, a := range def.SortedApis() {
+		e, err := newEntry(i, a)
+		assert.NoError(t, err)
+		e.route = &Route{api: a}
+		assert.NoError(t, tr.add(e, a.Proxy.Path, a.Proxy.Hosts))
+	}
+	return tr
+}
+
+func TestTree_Lookup(t *testing.T) {
+	tr := newTestTree(t,
+		&api.Api{Name: "root", Proxy: &api.Proxy{Path: "/"}},
+		&api.Api{Name: "users", Proxy: &api.Proxy{Path: "/users", Methods: []string{"GET"}}},
+		&api.Api{Name: "me", Proxy: &api.Proxy{Path: "/users/me"}},
+		&api.Api{Name: "user", Proxy: &api.Proxy{Path: "/users/{id}"}},
+		&api.Api{Name: "order", Proxy: &api.Proxy{Path: "/users/{id}/orders/{orderId:[0-9]+}"}},
+		&api.Api{Name: "file", Proxy: &api.Proxy{Path: "/files/{name}.{ext:json|yaml}"}},
+		&api.Api{Name: "static", Proxy: &api.Proxy{Path: "/static/", PathType: "prefix"}},
+		&api.Api{Name: "tenant", Proxy: &api.Proxy{Path: "/users", Hosts: []string{"*.example.com"}}},
+		&api.Api{Name: "admin", Proxy: &api.Proxy{Path: "/users", Hosts: []string{"admin.example.com"}}},
+	)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		want     string
+		vars     map[string]string
+		mismatch bool
+	}{
+		{name: "should be match the root", url: "/", want: "root"},
+		{name: "should be match the static path", url: "/users", want: "users"},
+		{name: "should be match the static segment before the variable", url: "/users/me", want: "me"},
+		{name: "should be match the variable", url: "/users/1", want: "user", vars: map[string]string{"id": "1"}},
+		{name: "should be match the variable pattern", url: "/users/1/orders/2", want: "order", vars: map[string]string{"id": "1", "orderId": "2"}},
+		{name: "should be not match the variable pattern", url: "/users/1/orders/a"},
+		{name: "should be match the variables in a segment", url: "/files/config.yaml", want: "file", vars: map[string]string{"name": "config", "ext": "yaml"}},
+		{name: "should be match the prefix", url: "/static/js/app.js", want: "static"},
+		{name: "should be not match the prefix without trailing slash", url: "/static"},
+		{name: "should be not match the empty variable", url: "/users/"},
+		{name: "should be return mismatch if method is not allowed", method: "POST", url: "/users", mismatch: true},
+		{name: "should be match the exact host without port", url: "http://ADMIN.example.com:8080/users", want: "admin"},
+		{name: "should be match the wildcard host", url: "http://tenant.example.com/users", want: "tenant"},
+		{name: "should be not match the wildcard host of multiple labels", url: "http://a.tenant.example.com/users", want: "users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, tt.url, nil)
+			e, mismatch := tr.lookup(req)
+			assert.Equal(t, tt.mismatch, mismatch)
+			if tt.want == "" {
+				assert.Nil(t, e)
+				return
+			}
+			if assert.NotNil(t, e) {
+				assert.Equal(t, tt.want, e.route.api.Name)
+				assert.Equal(t, tt.vars, e.vars(req.URL.Path))
+			}
+		})
+	}
+}
+
+func TestTree_LookupAllocs(t *testing.T) {
+	tr := newTestTree(t,
+		&api.Api{Name: "users", Proxy: &api.Proxy{Path: "/users", PathType: "prefix"}},
+		&api.Api{Name: "order", Proxy: &api.Proxy{Path: "/users/{id}/orders/{orderId:[0-9]+}", Methods: []string{"GET"}}},
+		&api.Api{Name: "tenant", Proxy: &api.Proxy{Path: "/users/{id}", Hosts: []string{"*.example.com"}}},
+	)
+	req := httptest.NewRequest("GET", "http://tenant.example.com:8080/users/1/orders/2", nil)
+
+	t.Run("should be lookup without allocations", func(t *testing.T) {
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = tr.lookup(req)
+		})
+		assert.Equal(t, float64(0), allocs)
+	})
+}
+
+func TestParseSegments(t *testing.T) {
+	t.Run("should be parse the variable pattern with braces", func(t *testing.T) {
+		segments, err := parseSegments("/codes/{code:[A-Z]{2}}")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(segments))
+		assert.Equal(t, patternSegment, segments[1].kind)
+		assert.True(t, segments[1].reg.MatchString("JP"))
+		assert.False(t, segments[1].reg.MatchString("JPN"))
+	})
+
+	t.Run("should be return error if braces are unbalanced", func(t *testing.T) {
+		_, err := parseSegments("/users/{id")
+		assert.Error(t, err)
+	})
+
+	t.Run("should be return error if variable has no name", func(t *testing.T) {
+		_, err := parseSegments("/users/{:[0-9]+}")
+		assert.Error(t, err)
+	})
+
+	t.Run("should be return error if pattern is invalid", func(t *testing.T) {
+		_, err := parseSegments("/users/{id:(}")
+		assert.Error(t, err)
+	})
+}
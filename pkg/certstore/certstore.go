@@ -0,0 +1,116 @@
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Store holds the certificates of the gateway by server name.
+// Certificates are swapped atomically, so handshakes in progress are not affected.
+type Store struct {
+	certs atomic.Value
+}
+
+type certMap struct {
+	names map[string]*tls.Certificate
+	def   *tls.Certificate
+}
+
+// New creates an empty certificate store.
+func New() *Store {
+	s := &Store{}
+	s.certs.Store(&certMap{names: make(map[string]*tls.Certificate)})
+	return s
+}
+
+// Set replaces all certificates of the store.
+// def is used if no certificate matches the server name.
+// The first certificate is used as default if def is nil.
+func (s *Store) Set(def *tls.Certificate, certs []*tls.Certificate) error {
+	m := &certMap{
+		names: make(map[string]*tls.Certificate),
+		def:   def,
+	}
+	for _, c := range certs {
+		names, err := names(c)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			// the first certificate wins if names are duplicated
+			if _, ok := m.names[n]; !ok {
+				m.names[n] = c
+			}
+		}
+		if m.def == nil {
+			m.def = c
+		}
+	}
+	if def != nil {
+		names, err := names(def)
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			if _, ok := m.names[n]; !ok {
+				m.names[n] = def
+			}
+		}
+	}
+	s.certs.Store(m)
+	return nil
+}
+
+// Len returns the number of server names in the store.
+func (s *Store) Len() int {
+	return len(s.certs.Load().(*certMap).names)
+}
+
+// GetCertificate returns the certificate of the server name.
+// It is used as tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m := s.certs.Load().(*certMap)
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if c, ok := m.names[name]; ok {
+			return c, nil
+		}
+		// wildcard matches only a single label
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if c, ok := m.names["*"+name[i:]]; ok {
+				return c, nil
+			}
+		}
+	}
+	if m.def != nil {
+		return m.def, nil
+	}
+	return nil, errors.New(fmt.Sprintf("not found certificate. server name: %s", hello.ServerName))
+}
+
+func names(c *tls.Certificate) ([]string, error) {
+	if c.Leaf == nil {
+		if len(c.Certificate) == 0 {
+			return nil, errors.New("certificate is empty")
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse certificate")
+		}
+		c.Leaf = leaf
+	}
+
+	names := make([]string, 0, len(c.Leaf.DNSNames)+1)
+	for _, n := range c.Leaf.DNSNames {
+		names = append(names, strings.ToLower(n))
+	}
+	if len(names) == 0 && c.Leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(c.Leaf.Subject.CommonName))
+	}
+	return names, nil
+}
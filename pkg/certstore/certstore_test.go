@@ -0,0 +1,164 @@
+package certstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func newCertificatePEM(t *testing.T, hosts ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func newCertificate(t *testing.T, hosts ...string) *tls.Certificate {
+	certPEM, keyPEM := newCertificatePEM(t, hosts...)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	return &cert
+}
+
+func TestStore_GetCertificate(t *testing.T) {
+	def := newCertificate(t, "default.test")
+	example := newCertificate(t, "example.com", "www.example.com")
+	wildcard := newCertificate(t, "*.example.com")
+
+	s := New()
+	assert.NoError(t, s.Set(def, []*tls.Certificate{example, wildcard}))
+
+	tests := []struct {
+		name       string
+		serverName string
+		want       *tls.Certificate
+	}{
+		{name: "should be match the exact name", serverName: "www.example.com", want: example},
+		{name: "should be match case insensitively", serverName: "Example.COM", want: example},
+		{name: "should be match the wildcard name", serverName: "api.example.com", want: wildcard},
+		{name: "should be not match the wildcard name of multiple labels", serverName: "v1.api.example.com", want: def},
+		{name: "should be default if no server name", serverName: "", want: def},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			assert.NoError(t, err)
+			assert.Same(t, tt.want, got)
+		})
+	}
+
+	t.Run("should be use the first certificate as default if default is nil", func(t *testing.T) {
+		s := New()
+		assert.NoError(t, s.Set(nil, []*tls.Certificate{example, wildcard}))
+		got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.test"})
+		assert.NoError(t, err)
+		assert.Same(t, example, got)
+	})
+
+	t.Run("should be return error if store is empty", func(t *testing.T) {
+		got, err := New().GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		assert.Error(t, err)
+		assert.Nil(t, got)
+	})
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certstore_test")
+	defer os.RemoveAll(dir)
+
+	certPEM, keyPEM := newCertificatePEM(t, "example.com")
+	_ = ioutil.WriteFile(filepath.Join(dir, "example.com.crt"), certPEM, 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, "example.com.key"), keyPEM, 0600)
+	_ = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0600)
+
+	t.Run("should be load pairs of crt and key", func(t *testing.T) {
+		certs, err := LoadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, certs, 1)
+	})
+
+	t.Run("should be return error if key is missing", func(t *testing.T) {
+		_ = ioutil.WriteFile(filepath.Join(dir, "missing.crt"), certPEM, 0600)
+		defer os.Remove(filepath.Join(dir, "missing.crt"))
+		_, err := LoadDir(dir)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadDefinition(t *testing.T) {
+	certPEM, keyPEM := newCertificatePEM(t, "example.com")
+
+	t.Run("should be load inline certificates", func(t *testing.T) {
+		certs, err := LoadDefinition(&api.Definition{
+			Certificates: []*api.Certificate{{Cert: string(certPEM), Key: string(keyPEM)}},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, certs, 1)
+	})
+
+	t.Run("should be skip acme certificates", func(t *testing.T) {
+		certs, err := LoadDefinition(&api.Definition{
+			Certificates: []*api.Certificate{{Acme: true, Hosts: []string{"example.com"}}},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, certs, 0)
+	})
+
+	t.Run("should be return error if certificate is invalid", func(t *testing.T) {
+		_, err := LoadDefinition(&api.Definition{
+			Certificates: []*api.Certificate{{Cert: "invalid", Key: "invalid"}},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestWatchDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "certstore_test")
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	WatchDir(ctx, dir, 10*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "example.com.crt"), []byte("test"), 0600)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Error("should be notified the change of the directory")
+	}
+}
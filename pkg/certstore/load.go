@@ -0,0 +1,118 @@
+package certstore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+const (
+	certExt = ".crt"
+	keyExt  = ".key"
+)
+
+// LoadDir loads the certificates of the directory.
+// A certificate is a pair of "<name>.crt" and "<name>.key".
+func LoadDir(dir string) ([]*tls.Certificate, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read certificate directory")
+	}
+
+	certs := make([]*tls.Certificate, 0)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != certExt {
+			continue
+		}
+		certFile := filepath.Join(dir, f.Name())
+		keyFile := strings.TrimSuffix(certFile, certExt) + keyExt
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not load certificate. file: %s", certFile))
+		}
+		certs = append(certs, &cert)
+	}
+	return certs, nil
+}
+
+// LoadDefinition loads the certificates of the api definition.
+// Certificates obtained via ACME are skipped.
+func LoadDefinition(def *api.Definition) ([]*tls.Certificate, error) {
+	certs := make([]*tls.Certificate, 0, len(def.Certificates))
+	for i, c := range def.Certificates {
+		if c.Acme {
+			continue
+		}
+		var (
+			cert tls.Certificate
+			err  error
+		)
+		if c.CertFile != "" {
+			cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		} else {
+			cert, err = tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not load certificate. certificates[%d]", i))
+		}
+		certs = append(certs, &cert)
+	}
+	return certs, nil
+}
+
+// WatchDir calls fn when files in the directory are changed.
+func WatchDir(ctx context.Context, dir string, interval time.Duration, fn func()) {
+	version := dirVersion(dir)
+	ticker := time.NewTicker(interval)
+
+	log.Debug("Start watch certificate directory", zap.String("dir", dir))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				v := dirVersion(dir)
+				if v == version {
+					continue
+				}
+				log.Info("Certificate directory change detected", zap.String("dir", dir))
+				version = v
+				fn()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// dirVersion returns a string that changes when files in the directory change.
+func dirVersion(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	var b strings.Builder
+	for _, f := range files {
+		if f.Mode()&os.ModeType != 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", f.Name(), f.Size(), f.ModTime().UnixNano())
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package clientauth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+const (
+	SubjectHeaderKey     string = "X-Plixy-Client-Subject"
+	SANHeaderKey         string = "X-Plixy-Client-San"
+	FingerprintHeaderKey string = "X-Plixy-Client-Fingerprint"
+)
+
+// Identity is the verified client certificate of the request.
+type Identity struct {
+	Subject     string
+	SANs        []string
+	Fingerprint string
+}
+
+// FromRequest returns the identity of the verified client certificate.
+// It returns nil if the client did not send a verified certificate.
+func FromRequest(r *http.Request) *Identity {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	sum := sha256.Sum256(cert.Raw)
+	return &Identity{
+		Subject:     cert.Subject.String(),
+		SANs:        sans(cert),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+}
+
+func sans(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.IPAddresses)+len(cert.URIs))
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// SetHeaders sets the identity to the headers forwarded to upstream.
+// The headers sent by the client are always removed so that they can not be spoofed.
+func SetHeaders(h http.Header, id *Identity) {
+	h.Del(SubjectHeaderKey)
+	h.Del(SANHeaderKey)
+	h.Del(FingerprintHeaderKey)
+	if id == nil {
+		return
+	}
+	h.Set(SubjectHeaderKey, id.Subject)
+	if len(id.SANs) > 0 {
+		h.Set(SANHeaderKey, strings.Join(id.SANs, ","))
+	}
+	h.Set(FingerprintHeaderKey, id.Fingerprint)
+}
+
+// Policy restricts the clients of an api by the client certificate.
+type Policy struct {
+	subjects []*regexp.Regexp
+	sans     []*regexp.Regexp
+}
+
+// NewPolicy creates the policy of the api definition.
+func NewPolicy(def *api.ClientAuth) (*Policy, error) {
+	subjects, err := compile(def.Subjects)
+	if err != nil {
+		return nil, err
+	}
+	sans, err := compile(def.SANs)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{subjects: subjects, sans: sans}, nil
+}
+
+func compile(patterns []string) ([]*regexp.Regexp, error) {
+	regs := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		reg, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not compile client auth pattern. pattern: %s", p))
+		}
+		regs = append(regs, reg)
+	}
+	return regs, nil
+}
+
+// Allow reports whether the identity is allowed.
+// A verified certificate is required, and if there are patterns,
+// either the subject or one of the SANs must match them.
+func (p *Policy) Allow(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+	if len(p.subjects) == 0 && len(p.sans) == 0 {
+		return true
+	}
+	for _, reg := range p.subjects {
+		if reg.MatchString(id.Subject) {
+			return true
+		}
+	}
+	for _, reg := range p.sans {
+		for _, san := range id.SANs {
+			if reg.MatchString(san) {
+				return true
+			}
+		}
+	}
+	return false
+}
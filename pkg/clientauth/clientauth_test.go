@@ -0,0 +1,99 @@
+package clientauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func newRequest(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	r.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return r
+}
+
+func TestFromRequest(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.com/partner")
+	cert := &x509.Certificate{
+		Raw:            []byte("raw"),
+		Subject:        pkix.Name{CommonName: "partner", Organization: []string{"Example"}},
+		DNSNames:       []string{"partner.example.com"},
+		EmailAddresses: []string{"partner@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+		URIs:           []*url.URL{u},
+	}
+
+	t.Run("should be return identity of the verified certificate", func(t *testing.T) {
+		id := FromRequest(newRequest(cert))
+		assert.Equal(t, "CN=partner,O=Example", id.Subject)
+		assert.Equal(t, []string{"partner.example.com", "partner@example.com", "127.0.0.1", "spiffe://example.com/partner"}, id.SANs)
+		assert.Equal(t, "d7439bee24773bcbfa2d0a97947ee36227b10d1022b1a55847e928965bb6bfde", id.Fingerprint)
+	})
+
+	t.Run("should be nil if certificate is not verified", func(t *testing.T) {
+		assert.Nil(t, FromRequest(newRequest(nil)))
+		assert.Nil(t, FromRequest(httptest.NewRequest(http.MethodGet, "/", nil)))
+	})
+}
+
+func TestSetHeaders(t *testing.T) {
+	t.Run("should be set identity headers", func(t *testing.T) {
+		h := http.Header{}
+		SetHeaders(h, &Identity{Subject: "CN=partner", SANs: []string{"a.example.com", "b.example.com"}, Fingerprint: "abc"})
+		assert.Equal(t, "CN=partner", h.Get(SubjectHeaderKey))
+		assert.Equal(t, "a.example.com,b.example.com", h.Get(SANHeaderKey))
+		assert.Equal(t, "abc", h.Get(FingerprintHeaderKey))
+	})
+
+	t.Run("should be remove headers sent by the client", func(t *testing.T) {
+		h := http.Header{}
+		h.Set(SubjectHeaderKey, "CN=spoofed")
+		h.Set(SANHeaderKey, "spoofed.example.com")
+		h.Set(FingerprintHeaderKey, "spoofed")
+		SetHeaders(h, nil)
+		assert.Equal(t, "", h.Get(SubjectHeaderKey))
+		assert.Equal(t, "", h.Get(SANHeaderKey))
+		assert.Equal(t, "", h.Get(FingerprintHeaderKey))
+	})
+}
+
+func TestPolicy_Allow(t *testing.T) {
+	id := &Identity{Subject: "CN=partner,O=Example", SANs: []string{"partner.example.com"}}
+
+	tests := []struct {
+		name string
+		def  *api.ClientAuth
+		id   *Identity
+		want bool
+	}{
+		{name: "should be allow any verified certificate if there are no patterns", def: &api.ClientAuth{}, id: id, want: true},
+		{name: "should be reject if there is no certificate", def: &api.ClientAuth{}, id: nil, want: false},
+		{name: "should be allow if subject matches", def: &api.ClientAuth{Subjects: []string{"^CN=partner,"}}, id: id, want: true},
+		{name: "should be allow if san matches", def: &api.ClientAuth{Subjects: []string{"^CN=other,"}, SANs: []string{`^partner\.example\.com$`}}, id: id, want: true},
+		{name: "should be reject if nothing matches", def: &api.ClientAuth{Subjects: []string{"^CN=other,"}, SANs: []string{`^other\.example\.com$`}}, id: id, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.def)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, p.Allow(tt.id))
+		})
+	}
+
+	t.Run("should be return error if pattern is invalid", func(t *testing.T) {
+		_, err := NewPolicy(&api.ClientAuth{SANs: []string{"("}})
+		assert.Error(t, err)
+	})
+}
@@ -10,24 +10,90 @@ import (
 var Global = &global{}
 
 type global struct {
-	Debug               bool
-	Port                uint
-	GraceTimeOut        time.Duration
-	DatabaseDSN         string
-	Watch               bool
-	WatchInterval       time.Duration
-	DialTimeout         time.Duration
-	MaxIdleConns        int
-	MaxIdleConnsPerHost int
-	IdleConnTimeout     time.Duration
-	Stats               Stats
-	Trace               Trace
+	Debug                 bool
+	Port                  uint
+	GraceTimeOut          time.Duration
+	DatabaseDSN           string
+	Watch                 bool
+	WatchInterval         time.Duration
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	HTTP2                 bool
+	RetryBudget           RetryBudget
+	TLS                   TLS
+	Plugin                Plugin
+	Stats                 Stats
+	Trace                 Trace
 }
 
 func (g *global) IsObservable() bool {
 	return g.Stats.Enable || g.Trace.Enable
 }
 
+// RetryBudget limits the retries to upstream across all apis.
+// Retries are allowed up to Ratio of requests plus MinRetriesPerSecond.
+type RetryBudget struct {
+	Ratio               float64
+	MinRetriesPerSecond int
+}
+
+// TLS is the tls settings of the gateway listener.
+// CertFile is the default certificate, and CertDir has certificates selected by SNI.
+// RedirectPort opens a listener that redirects http to https if it is not zero.
+// ClientAuth is one of none, optional and require, and client certificates are verified by ClientCAFile.
+type TLS struct {
+	Enable       bool
+	CertFile     string
+	KeyFile      string
+	CertDir      string
+	MinVersion   string
+	CipherSuites []string
+	RedirectPort uint
+	ClientAuth   string
+	ClientCAFile string
+	ACME         ACME
+}
+
+// ACME obtains and renews certificates of the hosts declared in the api definition.
+// HTTP-01 challenges are answered on the redirect listener, and TLS-ALPN-01 on the gateway listener.
+// CAFile is the root certificate of the ACME server, such as Pebble for testing,
+// and the server trusted by it is worked around for the responses of the test servers.
+type ACME struct {
+	Enable       bool
+	Email        string
+	DirectoryURL string
+	CacheDir     string
+	CAFile       string
+	RenewBefore  time.Duration
+}
+
+// Plugin is the settings of the plugins loaded at startup.
+// Dir has shared objects built with -buildmode=plugin, which requires plixy built with cgo.
+// External are the plugin processes called over gRPC.
+type Plugin struct {
+	Dir      string
+	External []ExternalPlugin
+}
+
+// ExternalPlugin is a plugin process supervised by plixy and registered as the plugin of Name.
+// Address is unix:/path/to/socket or host:port of localhost, and passed to the process by PLIXY_PLUGIN_ADDRESS.
+// The process is started by Command unless it is empty, and restarted if it exits or fails health checks.
+// Requests are rejected while the process is not serving unless FailOpen.
+type ExternalPlugin struct {
+	Name               string
+	Command            string
+	Args               []string
+	Env                []string
+	Address            string
+	Timeout            time.Duration
+	HealthInterval     time.Duration
+	UnhealthyThreshold int
+	FailOpen           bool
+}
+
 type Stats struct {
 	Enable      bool
 	Name        string
@@ -51,9 +117,20 @@ func init() {
 	viper.SetDefault("Watch", false)
 	viper.SetDefault("WatchInterval", 2*time.Second)
 	viper.SetDefault("DialTimeout", 30*time.Second)
+	viper.SetDefault("ResponseHeaderTimeout", 10*time.Second)
 	viper.SetDefault("MaxIdleConns", 512)
 	viper.SetDefault("MaxIdleConnsPerHost", 128)
 	viper.SetDefault("IdleConnTimeout", 90*time.Second)
+	viper.SetDefault("HTTP2", true)
+	viper.SetDefault("RetryBudget.Ratio", 0.2)
+	viper.SetDefault("RetryBudget.MinRetriesPerSecond", 10)
+	viper.SetDefault("TLS.Enable", false)
+	viper.SetDefault("TLS.MinVersion", "1.2")
+	viper.SetDefault("TLS.ClientAuth", "none")
+	viper.SetDefault("TLS.ACME.Enable", false)
+	viper.SetDefault("TLS.ACME.DirectoryURL", "https://acme-v02.api.letsencrypt.org/directory")
+	viper.SetDefault("TLS.ACME.CacheDir", "acme")
+	viper.SetDefault("TLS.ACME.RenewBefore", 30*24*time.Hour)
 	viper.SetDefault("Stats.Enable", false)
 	viper.SetDefault("Stats.Name", "prometheus")
 	viper.SetDefault("Stats.Port", 9090)
@@ -69,9 +146,29 @@ func init() {
 	viper.BindEnv("Watch", "PLIXY_WATCH")
 	viper.BindEnv("WatchInterval", "PLIXY_WATCH_INTERVAL")
 	viper.BindEnv("DialTimeout", "PLIXY_DIAL_TIMEOUT")
+	viper.BindEnv("ResponseHeaderTimeout", "PLIXY_RESPONSE_HEADER_TIMEOUT")
 	viper.BindEnv("MaxIdleConns", "PLIXY_MAX_IDLE_CONNS")
 	viper.BindEnv("MaxIdleConnsPerHost", "PLIXY_MAX_IDLE_CONNS_PER_HOST")
 	viper.BindEnv("IdleConnTimeout", "PLIXY_IDLE_CONN_TIMEOUT")
+	viper.BindEnv("HTTP2", "PLIXY_HTTP2")
+	viper.BindEnv("RetryBudget.Ratio", "PLIXY_RETRY_BUDGET_RATIO")
+	viper.BindEnv("RetryBudget.MinRetriesPerSecond", "PLIXY_RETRY_BUDGET_MIN_RETRIES_PER_SECOND")
+	viper.BindEnv("TLS.Enable", "PLIXY_TLS_ENABLE")
+	viper.BindEnv("TLS.CertFile", "PLIXY_TLS_CERT_FILE")
+	viper.BindEnv("TLS.KeyFile", "PLIXY_TLS_KEY_FILE")
+	viper.BindEnv("TLS.CertDir", "PLIXY_TLS_CERT_DIR")
+	viper.BindEnv("TLS.MinVersion", "PLIXY_TLS_MIN_VERSION")
+	viper.BindEnv("TLS.CipherSuites", "PLIXY_TLS_CIPHER_SUITES")
+	viper.BindEnv("TLS.RedirectPort", "PLIXY_TLS_REDIRECT_PORT")
+	viper.BindEnv("TLS.ClientAuth", "PLIXY_TLS_CLIENT_AUTH")
+	viper.BindEnv("TLS.ClientCAFile", "PLIXY_TLS_CLIENT_CA_FILE")
+	viper.BindEnv("TLS.ACME.Enable", "PLIXY_TLS_ACME_ENABLE")
+	viper.BindEnv("TLS.ACME.Email", "PLIXY_TLS_ACME_EMAIL")
+	viper.BindEnv("TLS.ACME.DirectoryURL", "PLIXY_TLS_ACME_DIRECTORY_URL")
+	viper.BindEnv("TLS.ACME.CacheDir", "PLIXY_TLS_ACME_CACHE_DIR")
+	viper.BindEnv("TLS.ACME.CAFile", "PLIXY_TLS_ACME_CA_FILE")
+	viper.BindEnv("TLS.ACME.RenewBefore", "PLIXY_TLS_ACME_RENEW_BEFORE")
+	viper.BindEnv("Plugin.Dir", "PLIXY_PLUGIN_DIR")
 	viper.BindEnv("Stats.Enable", "PLIXY_STATS_ENABLE")
 	viper.BindEnv("Stats.Name", "PLIXY_STATS_NAME")
 	viper.BindEnv("Stats.Port", "PLIXY_STATS_PORT")
@@ -7,6 +7,35 @@ import (
 	"github.com/purini-to/plixy/pkg/config"
 )
 
+// TargetStatus is the health status of an upstream target.
+type TargetStatus struct {
+	Api     string
+	Target  string
+	Healthy bool
+	Ejected bool
+}
+
+// StatusProvider provides the health status of upstream targets.
+type StatusProvider interface {
+	TargetStatuses() []*TargetStatus
+}
+
 func Handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "health: OK, version: %s", config.Version)
 }
+
+// NewHandler creates a handler that also writes the upstream target status.
+func NewHandler(p StatusProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Handler(w, r)
+		for _, s := range p.TargetStatuses() {
+			status := "healthy"
+			if !s.Healthy {
+				status = "unhealthy"
+			} else if s.Ejected {
+				status = "ejected"
+			}
+			fmt.Fprintf(w, "\nupstream: %s, target: %s, status: %s", s.Api, s.Target, status)
+		}
+	}
+}
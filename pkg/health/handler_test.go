@@ -3,6 +3,7 @@ package health
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/magiconair/properties/assert"
@@ -18,3 +19,25 @@ func TestHandler(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
+
+type statusProvider []*TargetStatus
+
+func (p statusProvider) TargetStatuses() []*TargetStatus {
+	return p
+}
+
+func TestNewHandler(t *testing.T) {
+	r := NewHandler(statusProvider{
+		{Api: "echo", Target: "http://localhost:9001", Healthy: true},
+		{Api: "echo", Target: "http://localhost:9002", Healthy: false},
+	})
+
+	req := httptest.NewRequest("GET", "/__health__", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, strings.Contains(rec.Body.String(), "upstream: echo, target: http://localhost:9001, status: healthy"))
+	assert.Equal(t, true, strings.Contains(rec.Body.String(), "upstream: echo, target: http://localhost:9002, status: unhealthy"))
+}
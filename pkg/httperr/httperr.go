@@ -7,13 +7,20 @@ import (
 
 	"github.com/purini-to/plixy/pkg/log"
 
-	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
 
 // HTTPStatusClientClosedRequest is status for client is closed
 var HTTPStatusClientClosedRequest = 499
 
+func BadRequest(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+func Forbidden(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
 func NotFound(w http.ResponseWriter) {
 	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 }
@@ -26,6 +33,14 @@ func BadGateway(w http.ResponseWriter) {
 	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 }
 
+func ServiceUnavailable(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+func GatewayTimeout(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+}
+
 func MethodNotAllowed(w http.ResponseWriter) {
 	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 }
@@ -45,12 +60,6 @@ func Error(w http.ResponseWriter, r *http.Request, err error) {
 
 func errorByString(w http.ResponseWriter, r *http.Request, err, cause error) {
 	switch cause.Error() {
-	case mux.ErrNotFound.Error():
-		NotFound(w)
-		return
-	case mux.ErrMethodMismatch.Error():
-		MethodNotAllowed(w)
-		return
 	case "context canceled":
 		ClientClosedRequest(w, cause)
 		return
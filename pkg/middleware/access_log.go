@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/purini-to/plixy/pkg/clientauth"
 	"github.com/purini-to/plixy/pkg/log"
 
 	"go.uber.org/zap"
@@ -29,7 +30,7 @@ func AccessLog(next http.Handler) http.Handler {
 		t1 := time.Now()
 		defer func() {
 			duration := time.Since(t1)
-			logger.Info("Completed handling request",
+			fields := []zap.Field{
 				zap.String("method", r.Method),
 				zap.String("host", r.Host),
 				zap.String("uri", r.RequestURI),
@@ -38,7 +39,15 @@ func AccessLog(next http.Handler) http.Handler {
 				zap.Int("code", ww.Status()),
 				zap.Duration("duration", duration),
 				zap.Int("bytes", ww.BytesWritten()),
-			)
+			}
+			if id := clientauth.FromRequest(r); id != nil {
+				fields = append(fields,
+					zap.String("client_subject", id.Subject),
+					zap.Strings("client_san", id.SANs),
+					zap.String("client_fingerprint", id.Fingerprint),
+				)
+			}
+			logger.Info("Completed handling request", fields...)
 		}()
 
 		next.ServeHTTP(ww, r)
@@ -0,0 +1,312 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	pstats "github.com/purini-to/plixy/pkg/stats"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+type mirrorKeyType int
+
+const mirrorContextKey mirrorKeyType = iota
+
+const (
+	// HeaderKey is set to the mirrored requests so that the shadow upstream can tell them.
+	HeaderKey string = "X-Plixy-Mirror"
+
+	defaultMaxBodySize    = 64 * 1024
+	defaultTimeout        = 10 * time.Second
+	defaultMaxConcurrency = 100
+)
+
+// Compare results
+const (
+	ResultMatch          = "match"
+	ResultStatusMismatch = "status_mismatch"
+	ResultBodyMismatch   = "body_mismatch"
+	ResultError          = "error"
+)
+
+// hopHeaders are removed from the mirrored requests as httputil.ReverseProxy does.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Mirror is the runtime state of the mirror of an api.
+type Mirror struct {
+	name        string
+	upstream    *upstream.Upstream
+	percentage  float64
+	maxBodySize int64
+	timeout     time.Duration
+	compare     bool
+	// inflight limits the mirrored requests in flight by its capacity.
+	inflight chan struct{}
+}
+
+// Result is the response of the primary upstream to compare.
+type Result struct {
+	Status int
+	Sum    []byte
+}
+
+// New creates the mirror of the api.
+// The upstream of the mirror is named "<api name>/mirror".
+func New(name string, def *api.Mirror) (*Mirror, error) {
+	up, err := upstream.New(fmt.Sprintf("%s/mirror", name), def.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{
+		name:        name,
+		upstream:    up,
+		percentage:  def.Percentage,
+		maxBodySize: def.MaxBodySize,
+		timeout:     def.Timeout,
+		compare:     def.Compare,
+	}
+	if m.percentage <= 0 {
+		m.percentage = 100
+	}
+	if m.maxBodySize <= 0 {
+		m.maxBodySize = defaultMaxBodySize
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultTimeout
+	}
+	maxConcurrency := def.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
+	}
+	m.inflight = make(chan struct{}, maxConcurrency)
+	return m, nil
+}
+
+// Upstream returns the shadow upstream.
+func (m *Mirror) Upstream() *upstream.Upstream {
+	return m.upstream
+}
+
+// Sample reports whether the request should be mirrored.
+func (m *Mirror) Sample() bool {
+	return m.percentage >= 100 || rand.Float64()*100 < m.percentage
+}
+
+// MaxBodySize returns the max size of the request body to mirror.
+func (m *Mirror) MaxBodySize() int64 {
+	return m.maxBodySize
+}
+
+// Compare reports whether the mirror response is compared with the primary response.
+func (m *Mirror) Compare() bool {
+	return m.compare
+}
+
+// Close stops the health checking of the shadow upstream.
+func (m *Mirror) Close() error {
+	return m.upstream.Close()
+}
+
+// Send sends the copy of the request with the buffered body to the shadow upstream.
+// It must be called before the request is proxied, and the result of the primary upstream
+// is received from primary if the mirror compares the responses.
+// The request is dropped without blocking if the mirrored requests in flight reach the limit.
+func (m *Mirror) Send(req *http.Request, body []byte, rt http.RoundTripper, primary <-chan *Result) {
+	logger := log.FromContext(req.Context())
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		logger.Debug("Drop mirror request over the limit", zap.String("name", m.name), zap.Int("limit", cap(m.inflight)))
+		m.recordDropped(req.Context())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	ctx = log.ToContext(ctx, logger)
+	ctx = upstream.ToContext(ctx, m.upstream)
+
+	out, err := m.newRequest(ctx, req, body)
+	if err != nil {
+		cancel()
+		<-m.inflight
+		logger.Warn("Could not create mirror request", zap.String("name", m.name), zap.Error(err))
+		return
+	}
+
+	go func() {
+		defer func() { <-m.inflight }()
+		defer cancel()
+		status, sum, latency, err := m.roundTrip(out, rt)
+		if err != nil {
+			logger.Debug("Error mirror request", zap.String("name", m.name), zap.Error(err))
+		}
+		m.record(ctx, status, latency)
+		if primary == nil {
+			return
+		}
+		select {
+		case res := <-primary:
+			result := compare(res, status, sum, err)
+			if result != ResultMatch {
+				logger.Info("Mirror response is different from primary response",
+					zap.String("name", m.name),
+					zap.String("result", result),
+					zap.Int("primary_status", res.Status),
+					zap.Int("mirror_status", status),
+				)
+			}
+			m.recordCompare(ctx, result)
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (m *Mirror) newRequest(ctx context.Context, req *http.Request, body []byte) (*http.Request, error) {
+	target, err := m.upstream.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = upstream.TargetToContext(ctx, target)
+
+	u := *req.URL
+	u.Scheme = target.URL.Scheme
+	u.Host = target.URL.Host
+	if m.upstream.Definition().FixedPath {
+		u.Path = target.Path(ctx)
+	} else {
+		u.Path = upstream.JoinPath(target.Path(ctx), m.upstream.RewritePath(req.URL.Path))
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+
+	out, err := http.NewRequest(req.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	out = out.WithContext(ctx)
+	out.Header = req.Header.Clone()
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	out.Header.Set(HeaderKey, "true")
+	if body == nil {
+		out.Body = http.NoBody
+	}
+	return out, nil
+}
+
+// roundTrip sends the request and returns the status and the hash of the response body.
+func (m *Mirror) roundTrip(req *http.Request, rt http.RoundTripper) (int, []byte, time.Duration, error) {
+	target := upstream.TargetFromContext(req.Context())
+	target.Acquire()
+	defer target.Release()
+
+	start := time.Now()
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		target.ReportFailure()
+		return 0, nil, time.Since(start), err
+	}
+	defer res.Body.Close()
+
+	h := sha256.New()
+	var w io.Writer = ioutil.Discard
+	if m.compare {
+		w = h
+	}
+	_, err = io.Copy(w, res.Body)
+	latency := time.Since(start)
+	if res.StatusCode >= http.StatusInternalServerError {
+		target.ReportFailure()
+	} else {
+		target.ReportSuccess()
+	}
+	return res.StatusCode, h.Sum(nil), latency, err
+}
+
+func compare(res *Result, status int, sum []byte, err error) string {
+	switch {
+	case err != nil:
+		return ResultError
+	case res.Status != status:
+		return ResultStatusMismatch
+	case !bytes.Equal(res.Sum, sum):
+		return ResultBodyMismatch
+	}
+	return ResultMatch
+}
+
+func (m *Mirror) record(ctx context.Context, status int, latency time.Duration) {
+	if !config.Global.Stats.Enable {
+		return
+	}
+	s := "error"
+	if status > 0 {
+		s = strconv.Itoa(status)
+	}
+	ctx, err := tag.New(ctx, tag.Upsert(pstats.KeyApiName, m.name), tag.Upsert(pstats.KeyStatus, s))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, pstats.MirrorLatency.M(float64(latency)/float64(time.Millisecond)))
+}
+
+func (m *Mirror) recordCompare(ctx context.Context, result string) {
+	if !config.Global.Stats.Enable {
+		return
+	}
+	ctx, err := tag.New(ctx, tag.Upsert(pstats.KeyApiName, m.name), tag.Upsert(pstats.KeyResult, result))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, pstats.MirrorCompareCount.M(1))
+}
+
+func (m *Mirror) recordDropped(ctx context.Context) {
+	if !config.Global.Stats.Enable {
+		return
+	}
+	ctx, err := tag.New(ctx, tag.Upsert(pstats.KeyApiName, m.name))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, pstats.MirrorDroppedCount.M(1))
+}
+
+func ToContext(ctx context.Context, m *Mirror) context.Context {
+	return context.WithValue(ctx, mirrorContextKey, m)
+}
+
+func FromContext(ctx context.Context) *Mirror {
+	if m, ok := ctx.Value(mirrorContextKey).(*Mirror); ok {
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("should be set defaults", func(t *testing.T) {
+		m, err := New("test", &api.Mirror{Upstream: &api.Upstream{Target: "http://shadow.test"}})
+		assert.NoError(t, err)
+		defer m.Close()
+		assert.Equal(t, "test/mirror", m.Upstream().Name())
+		assert.Equal(t, int64(defaultMaxBodySize), m.MaxBodySize())
+		assert.True(t, m.Sample())
+	})
+}
+
+func TestMirror_Sample(t *testing.T) {
+	t.Run("should be sample requests by the percentage", func(t *testing.T) {
+		m, err := New("test", &api.Mirror{Upstream: &api.Upstream{Target: "http://shadow.test"}, Percentage: 10})
+		assert.NoError(t, err)
+		defer m.Close()
+
+		n := 0
+		for i := 0; i < 10000; i++ {
+			if m.Sample() {
+				n++
+			}
+		}
+		assert.InDelta(t, 1000, n, 200)
+	})
+}
+
+func TestMirror_newRequest(t *testing.T) {
+	t.Run("should be rewrite the request to the shadow upstream", func(t *testing.T) {
+		m, err := New("test", &api.Mirror{Upstream: &api.Upstream{Target: "http://shadow.test/v2", StripPrefix: "/api"}})
+		assert.NoError(t, err)
+		defer m.Close()
+
+		req := httptest.NewRequest("GET", "http://gateway.test/api/users?id=1", nil)
+		req.Header.Set("Connection", "close")
+		req.Header.Set("X-Request-Id", "abc")
+		out, err := m.newRequest(req.Context(), req, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "http://shadow.test/v2/users?id=1", out.URL.String())
+		assert.Equal(t, "abc", out.Header.Get("X-Request-Id"))
+		assert.Equal(t, "", out.Header.Get("Connection"))
+		assert.Equal(t, "true", out.Header.Get(HeaderKey))
+	})
+}
+
+func TestMirror_Send(t *testing.T) {
+	release := make(chan struct{})
+	var received int32
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		<-release
+	}))
+	defer shadow.Close()
+	m, err := New("test", &api.Mirror{Upstream: &api.Upstream{Target: shadow.URL}, MaxConcurrency: 2})
+	assert.NoError(t, err)
+	defer m.Close()
+
+	send := func() {
+		req := httptest.NewRequest("GET", "http://gateway.test/users", nil)
+		req = req.WithContext(log.ToContext(req.Context(), zap.NewNop()))
+		m.Send(req, nil, http.DefaultTransport, nil)
+	}
+
+	t.Run("should be drop the requests over the limit without blocking by the stalled mirror", func(t *testing.T) {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			send()
+		}
+		assert.True(t, time.Since(start) < time.Second)
+		assert.Len(t, m.inflight, 2)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should be mirror again after the requests in flight finished", func(t *testing.T) {
+		close(release)
+		assert.Eventually(t, func() bool { return len(m.inflight) == 0 }, time.Second, 10*time.Millisecond)
+		send()
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&received) == 3 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		sum    []byte
+		err    error
+		want   string
+	}{
+		{name: "should be match", status: 200, sum: []byte("a"), want: ResultMatch},
+		{name: "should be status mismatch", status: 500, sum: []byte("a"), want: ResultStatusMismatch},
+		{name: "should be body mismatch", status: 200, sum: []byte("b"), want: ResultBodyMismatch},
+		{name: "should be error", err: assert.AnError, want: ResultError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compare(&Result{Status: 200, Sum: []byte("a")}, tt.status, tt.sum, tt.err))
+		})
+	}
+}
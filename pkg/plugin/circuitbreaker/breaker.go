@@ -0,0 +1,158 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+type state int
+
+const (
+	stateClosed state = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s state) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type settings struct {
+	window              time.Duration
+	consecutiveFailures int
+	errorRatio          float64
+	minRequests         int
+	openTimeout         time.Duration
+	halfOpenRequests    int
+}
+
+// breaker is a circuit breaker that counts the outcomes within a fixed window.
+type breaker struct {
+	sync.Mutex
+	settings     *settings
+	onChange     func(from, to state)
+	state        state
+	windowStart  time.Time
+	requests     int
+	failures     int
+	consecutive  int
+	openedAt     time.Time
+	probes       int
+	probeSuccess int
+}
+
+func newBreaker(s *settings, onChange func(from, to state)) *breaker {
+	return &breaker{settings: s, onChange: onChange}
+}
+
+// ready reports whether the breaker lets a request through without reserving it.
+func (b *breaker) ready(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.currentState(now) {
+	case stateOpen:
+		return false
+	case stateHalfOpen:
+		return b.probes < b.settings.halfOpenRequests
+	default:
+		return true
+	}
+}
+
+// allow reports whether the breaker lets a request through.
+// The request must be reported by done if allowed.
+func (b *breaker) allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.currentState(now) {
+	case stateOpen:
+		return false
+	case stateHalfOpen:
+		if b.probes >= b.settings.halfOpenRequests {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// done reports the outcome of an allowed request.
+func (b *breaker) done(now time.Time, success bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.currentState(now) {
+	case stateOpen:
+		return
+	case stateHalfOpen:
+		if !success {
+			b.setState(now, stateOpen)
+			return
+		}
+		b.probeSuccess++
+		if b.probeSuccess >= b.settings.halfOpenRequests {
+			b.setState(now, stateClosed)
+		}
+		return
+	}
+
+	if now.Sub(b.windowStart) >= b.settings.window {
+		b.windowStart = now
+		b.requests, b.failures = 0, 0
+	}
+	b.requests++
+	if success {
+		b.consecutive = 0
+	} else {
+		b.failures++
+		b.consecutive++
+	}
+	if b.shouldTrip() {
+		b.setState(now, stateOpen)
+	}
+}
+
+func (b *breaker) shouldTrip() bool {
+	s := b.settings
+	if s.consecutiveFailures > 0 && b.consecutive >= s.consecutiveFailures {
+		return true
+	}
+	if s.errorRatio > 0 && b.requests >= s.minRequests &&
+		float64(b.failures)/float64(b.requests) >= s.errorRatio {
+		return true
+	}
+	return false
+}
+
+func (b *breaker) currentState(now time.Time) state {
+	if b.state == stateOpen && now.Sub(b.openedAt) >= b.settings.openTimeout {
+		b.setState(now, stateHalfOpen)
+	}
+	return b.state
+}
+
+func (b *breaker) setState(now time.Time, s state) {
+	if b.state == s {
+		return
+	}
+	from := b.state
+	b.state = s
+	b.windowStart = now
+	b.requests, b.failures, b.consecutive = 0, 0, 0
+	b.probes, b.probeSuccess = 0, 0
+	if s == stateOpen {
+		b.openedAt = now
+	}
+	if b.onChange != nil {
+		b.onChange(from, s)
+	}
+}
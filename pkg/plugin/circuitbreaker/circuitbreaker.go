@@ -0,0 +1,220 @@
+package circuitbreaker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/httperr"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/middleware"
+	"github.com/purini-to/plixy/pkg/plugin"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+const (
+	defaultWindow           = "10s"
+	defaultOpenTimeout      = "30s"
+	defaultMinRequests      = 10
+	defaultHalfOpenRequests = 1
+	defaultStatus           = http.StatusServiceUnavailable
+)
+
+func init() {
+	plugin.Register("circuitbreaker", &plugin.Plugin{
+		Config:      newConfig,
+		BeforeProxy: BeforeProxy,
+	})
+}
+
+type Config struct {
+	PerTarget           bool    `json:"perTarget"`
+	Window              string  `json:"window"`
+	ConsecutiveFailures int     `json:"consecutiveFailures"`
+	ErrorRatio          float64 `json:"errorRatio" valid:"range(0|1)~must be between 0 and 1"`
+	MinRequests         int     `json:"minRequests"`
+	OpenTimeout         string  `json:"openTimeout"`
+	HalfOpenRequests    int     `json:"halfOpenRequests"`
+	FailureStatusCodes  []int   `json:"failureStatusCodes"`
+	Status              int     `json:"status"`
+	Body                string  `json:"body"`
+}
+
+func newConfig() interface{} {
+	return &Config{
+		Window:           defaultWindow,
+		OpenTimeout:      defaultOpenTimeout,
+		MinRequests:      defaultMinRequests,
+		HalfOpenRequests: defaultHalfOpenRequests,
+		Status:           defaultStatus,
+	}
+}
+
+// Validate validates the config that the valid tags cannot.
+func (c *Config) Validate() error {
+	if c.ConsecutiveFailures <= 0 && c.ErrorRatio <= 0 {
+		return &plugin.ConfigError{Message: "consecutiveFailures or errorRatio is required"}
+	}
+	if _, err := time.ParseDuration(c.Window); err != nil {
+		return &plugin.ConfigError{Field: "window", Message: err.Error()}
+	}
+	if _, err := time.ParseDuration(c.OpenTimeout); err != nil {
+		return &plugin.ConfigError{Field: "openTimeout", Message: err.Error()}
+	}
+	return nil
+}
+
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config by circuitbreaker plugin")
+	}
+
+	window, _ := time.ParseDuration(c.Window)
+	openTimeout, _ := time.ParseDuration(c.OpenTimeout)
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = defaultHalfOpenRequests
+	}
+
+	cb := &circuitBreaker{
+		config: c,
+		settings: &settings{
+			window:              window,
+			consecutiveFailures: c.ConsecutiveFailures,
+			errorRatio:          c.ErrorRatio,
+			minRequests:         c.MinRequests,
+			openTimeout:         openTimeout,
+			halfOpenRequests:    c.HalfOpenRequests,
+		},
+	}
+	cb.breaker = cb.newBreaker("")
+
+	return cb.handler, nil
+}
+
+type circuitBreaker struct {
+	config   *Config
+	settings *settings
+	breaker  *breaker
+	targets  sync.Map
+}
+
+func (cb *circuitBreaker) newBreaker(target string) *breaker {
+	return newBreaker(cb.settings, func(from, to state) {
+		log.Warn("Circuit breaker state changed",
+			zap.String("target", target), zap.Stringer("from", from), zap.Stringer("to", to))
+	})
+}
+
+func (cb *circuitBreaker) targetBreaker(t *upstream.Target) *breaker {
+	if v, ok := cb.targets.Load(t); ok {
+		return v.(*breaker)
+	}
+	v, _ := cb.targets.LoadOrStore(t, cb.newBreaker(t.URL.Host))
+	return v.(*breaker)
+}
+
+func (cb *circuitBreaker) handler(next http.Handler) http.Handler {
+	if cb.config.PerTarget {
+		return cb.perTarget(next)
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !cb.breaker.allow(time.Now()) {
+			cb.reject(w, r)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			cb.breaker.done(time.Now(), cb.isSuccess(ww.Status()))
+		}()
+		next.ServeHTTP(ww, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (cb *circuitBreaker) perTarget(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if up := upstream.FromContext(ctx); up != nil && !cb.anyReady(up) {
+			cb.reject(w, r)
+			return
+		}
+
+		h := &hook{cb: cb}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		// the attempt not reported by the proxy is reported by the response status.
+		defer func() {
+			if h.breaker != nil {
+				h.breaker.done(time.Now(), cb.isSuccess(ww.Status()))
+			}
+		}()
+		next.ServeHTTP(ww, r.WithContext(upstream.HookToContext(ctx, h)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (cb *circuitBreaker) anyReady(up *upstream.Upstream) bool {
+	now := time.Now()
+	for _, t := range up.Targets() {
+		if cb.targetBreaker(t).ready(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cb *circuitBreaker) isSuccess(status int) bool {
+	if status == httperr.HTTPStatusClientClosedRequest {
+		return true
+	}
+	if len(cb.config.FailureStatusCodes) == 0 {
+		return status < http.StatusInternalServerError
+	}
+	for _, code := range cb.config.FailureStatusCodes {
+		if status == code {
+			return false
+		}
+	}
+	return true
+}
+
+func (cb *circuitBreaker) reject(w http.ResponseWriter, r *http.Request) {
+	log.FromContext(r.Context()).Debug("Circuit breaker is open", zap.String("name", api.FromContext(r.Context()).Name))
+	body := cb.config.Body
+	if body == "" {
+		body = http.StatusText(cb.config.Status)
+	}
+	http.Error(w, body, cb.config.Status)
+}
+
+// hook excludes the targets whose circuit is open from the election,
+// and reports the result of each attempt to the breaker of the tried target.
+type hook struct {
+	cb      *circuitBreaker
+	breaker *breaker
+}
+
+func (h *hook) Allow(t *upstream.Target) bool {
+	return h.cb.targetBreaker(t).ready(time.Now())
+}
+
+func (h *hook) Elected(t *upstream.Target) {
+	h.breaker = nil
+	b := h.cb.targetBreaker(t)
+	if b.allow(time.Now()) {
+		h.breaker = b
+	}
+}
+
+func (h *hook) Done(t *upstream.Target, status int) {
+	if h.breaker == nil {
+		return
+	}
+	h.breaker.done(time.Now(), h.cb.isSuccess(status))
+	h.breaker = nil
+}
@@ -0,0 +1,181 @@
+package circuitbreaker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+func TestBreaker(t *testing.T) {
+	s := &settings{
+		window:              time.Minute,
+		consecutiveFailures: 3,
+		errorRatio:          0.5,
+		minRequests:         4,
+		openTimeout:         10 * time.Second,
+		halfOpenRequests:    2,
+	}
+	now := time.Now()
+
+	t.Run("should be open after consecutive failures", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for i := 0; i < 3; i++ {
+			assert.True(t, b.allow(now))
+			b.done(now, false)
+		}
+		assert.False(t, b.allow(now))
+	})
+
+	t.Run("should be open if error ratio exceeds threshold", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for _, success := range []bool{false, true, false, true} {
+			assert.True(t, b.allow(now))
+			b.done(now, success)
+		}
+		assert.False(t, b.allow(now))
+	})
+
+	t.Run("should be not open before min requests", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for _, success := range []bool{false, true, false} {
+			b.done(now, success)
+		}
+		assert.True(t, b.allow(now))
+	})
+
+	t.Run("should be reset counts when window elapsed", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for _, success := range []bool{false, true, false} {
+			b.done(now, success)
+		}
+		b.done(now.Add(time.Minute), true)
+		assert.True(t, b.allow(now.Add(time.Minute)))
+	})
+
+	t.Run("should be closed after half-open probes succeed", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for i := 0; i < 3; i++ {
+			b.done(now, false)
+		}
+		later := now.Add(10 * time.Second)
+		assert.True(t, b.allow(later))
+		assert.True(t, b.allow(later))
+		assert.False(t, b.allow(later))
+		b.done(later, true)
+		b.done(later, true)
+		assert.Equal(t, stateClosed, b.state)
+	})
+
+	t.Run("should be open again if half-open probe fails", func(t *testing.T) {
+		b := newBreaker(s, nil)
+		for i := 0; i < 3; i++ {
+			b.done(now, false)
+		}
+		later := now.Add(10 * time.Second)
+		assert.True(t, b.allow(later))
+		b.done(later, false)
+		assert.False(t, b.allow(later))
+	})
+}
+
+func TestBeforeProxy(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		ctx := log.ToContext(req.Context(), logger)
+		ctx = api.ToContext(ctx, &api.Api{Name: "test"})
+		return req.WithContext(ctx)
+	}
+
+	t.Run("should be return error if no trip condition", func(t *testing.T) {
+		_, err := BeforeProxy(map[string]interface{}{})
+		assert.Error(t, err)
+	})
+
+	t.Run("should be short-circuit with configured response while open", func(t *testing.T) {
+		mw, err := BeforeProxy(map[string]interface{}{
+			"consecutiveFailures": 2,
+			"status":              502,
+			"body":                "circuit open",
+		})
+		assert.NoError(t, err)
+
+		calls := 0
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		for i := 0; i < 3; i++ {
+			h.ServeHTTP(httptest.NewRecorder(), newRequest())
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest())
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, 502, rec.Code)
+		assert.Equal(t, "circuit open\n", rec.Body.String())
+	})
+
+	t.Run("should be exclude the target whose circuit is open if per target", func(t *testing.T) {
+		mw, err := BeforeProxy(map[string]interface{}{
+			"consecutiveFailures": 1,
+			"perTarget":           true,
+		})
+		assert.NoError(t, err)
+
+		up, _ := upstream.New("test", &api.Upstream{Targets: []*api.Target{
+			{Target: "http://localhost:9001", Weight: 1},
+			{Target: "http://localhost:9002", Weight: 1},
+		}})
+		bad := up.Targets()[0]
+		var elected []*upstream.Target
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target, _ := up.Next(r.Context())
+			elected = append(elected, target)
+			if target == bad {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+
+		for i := 0; i < 4; i++ {
+			req := newRequest()
+			h.ServeHTTP(httptest.NewRecorder(), req.WithContext(upstream.ToContext(req.Context(), up)))
+		}
+		count := 0
+		for _, target := range elected {
+			if target == bad {
+				count++
+			}
+		}
+		assert.Equal(t, 1, count)
+		assert.Len(t, elected, 4)
+	})
+
+	t.Run("should be short-circuit if all target circuits are open", func(t *testing.T) {
+		mw, _ := BeforeProxy(map[string]interface{}{
+			"consecutiveFailures": 1,
+			"perTarget":           true,
+		})
+		up, _ := upstream.New("test", &api.Upstream{Target: "http://localhost:9001"})
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = up.Next(r.Context())
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+
+		ctx := upstream.ToContext(context.Background(), up)
+		h.ServeHTTP(httptest.NewRecorder(), newRequest().WithContext(ctx))
+		rec := httptest.NewRecorder()
+		req := newRequest()
+		h.ServeHTTP(rec, req.WithContext(upstream.ToContext(req.Context(), up)))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
@@ -0,0 +1,161 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// ConfigFunc returns the new config of the plugin filled with the default values.
+// The type of the config is the schema to validate and export the config.
+type ConfigFunc func() interface{}
+
+// ConfigError is the error of the plugin config with the path of the invalid field.
+type ConfigError struct {
+	Field   string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ParseConfig decodes the config into v and validates it by the `valid` tags.
+// Unknown fields are rejected, and v is also validated by its Validate method if it has.
+func ParseConfig(config map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "error marshal plugin config")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+
+	if _, err = govalidator.ValidateStruct(v); err != nil {
+		return validationError(reflect.TypeOf(v), err)
+	}
+
+	if vv, ok := v.(interface{ Validate() error }); ok {
+		if err = vv.Validate(); err != nil {
+			if _, ok := err.(*ConfigError); ok {
+				return err
+			}
+			return &ConfigError{Message: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// ValidateConfig validates the config by the schema of the plugin.
+func ValidateConfig(name string, config map[string]interface{}) error {
+	fn, err := loadConfig(name)
+	if err != nil || fn == nil {
+		return err
+	}
+	return ParseConfig(config, fn())
+}
+
+// Validate validates the plugins applied to all apis in the definition
+// and reports the path of the invalid config like `apis[3].plugins[0].config.per`.
+func Validate(def *api.Definition) error {
+	for g, plugins := range def.PluginGroups {
+		for j, p := range plugins {
+			if _, err := load(p.Name); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("pluginGroups.%s[%d].name", g, j))
+			}
+		}
+	}
+	for _, a := range def.Apis {
+		for _, p := range def.ApiPlugins(a) {
+			if _, err := load(p.Name); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("%s.name", p.Path))
+			}
+			if err := ValidateConfig(p.Name, p.Config); err != nil {
+				field := fmt.Sprintf("%s.config", p.Path)
+				if cerr, ok := err.(*ConfigError); ok && cerr.Field != "" {
+					field = fmt.Sprintf("%s.%s", field, cerr.Field)
+					err = errors.New(cerr.Message)
+				}
+				return errors.Wrap(err, fmt.Sprintf("invalid plugin config. name: %s plugin: %s %s", a.Name, p.Name, field))
+			}
+		}
+	}
+	return nil
+}
+
+func decodeError(err error) error {
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		return &ConfigError{Field: e.Field, Message: fmt.Sprintf("must be %s, got %s", schemaType(e.Type), e.Value)}
+	}
+	const unknown = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, unknown) {
+		field, uerr := strconv.Unquote(strings.TrimPrefix(msg, unknown))
+		if uerr == nil {
+			return &ConfigError{Field: field, Message: "unknown field"}
+		}
+	}
+	return &ConfigError{Message: err.Error()}
+}
+
+// validationError converts the first error of govalidator into ConfigError.
+func validationError(t reflect.Type, err error) error {
+	for {
+		errs, ok := err.(govalidator.Errors)
+		if !ok || len(errs) == 0 {
+			break
+		}
+		err = errs[0]
+	}
+	e, ok := err.(govalidator.Error)
+	if !ok {
+		return &ConfigError{Message: err.Error()}
+	}
+	field := append(jsonPath(t, e.Path), e.Name)
+	return &ConfigError{Field: strings.Join(field, "."), Message: e.Err.Error()}
+}
+
+// jsonPath converts the path of the struct fields into the json names.
+func jsonPath(t reflect.Type, path []string) []string {
+	names := make([]string, 0, len(path))
+	for _, p := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			names = append(names, p)
+			continue
+		}
+		f, ok := t.FieldByName(p)
+		if !ok {
+			names = append(names, p)
+			continue
+		}
+		names = append(names, jsonName(f))
+		t = f.Type
+	}
+	return names
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+type testRules struct {
+	Names []string `json:"names" valid:"required"`
+}
+
+type testConfig struct {
+	Per      string            `json:"per" valid:"required,in(s|m)~must be contains [s|m]"`
+	Ratio    float64           `json:"ratio" valid:"range(0|1)"`
+	Rules    *testRules        `json:"rules"`
+	Labels   map[string]string `json:"labels"`
+	Disabled bool              `json:"disabled"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.Disabled && c.Ratio > 0 {
+		return errors.New("ratio must not be set if disabled")
+	}
+	return nil
+}
+
+func newTestConfig() interface{} {
+	return &testConfig{Per: "s"}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		err    string
+	}{
+		{name: "should be parse the valid config", config: map[string]interface{}{"per": "m", "rules": map[string]interface{}{"names": []interface{}{"a"}}}},
+		{name: "should be use the default values", config: nil},
+		{name: "should be report the invalid value", config: map[string]interface{}{"per": "x"}, err: "per: must be contains [s|m]"},
+		{name: "should be report the invalid type", config: map[string]interface{}{"ratio": "x"}, err: "ratio: must be number, got string"},
+		{name: "should be report the unknown field", config: map[string]interface{}{"unknown": 1}, err: "unknown: unknown field"},
+		{name: "should be report the nested field", config: map[string]interface{}{"rules": map[string]interface{}{}}, err: "rules.names: non zero value required"},
+		{name: "should be report the error of Validate", config: map[string]interface{}{"disabled": true, "ratio": 0.5}, err: "ratio must not be set if disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ParseConfig(tt.config, newTestConfig())
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	Register("test-config", &Plugin{Config: newTestConfig})
+	Register("test-noconfig", &Plugin{})
+
+	newDef := func(plugins ...*api.Plugin) *api.Definition {
+		return &api.Definition{Apis: []*api.Api{
+			{Name: "first"},
+			{Name: "second", Plugins: plugins},
+		}}
+	}
+
+	t.Run("should be valid", func(t *testing.T) {
+		assert.NoError(t, Validate(newDef(
+			&api.Plugin{Name: "test-noconfig", Config: map[string]interface{}{"any": 1}},
+			&api.Plugin{Name: "test-config", Config: map[string]interface{}{"per": "m"}},
+		)))
+	})
+
+	t.Run("should be report the path of the unknown plugin", func(t *testing.T) {
+		err := Validate(newDef(&api.Plugin{Name: "test-unknown"}))
+		assert.EqualError(t, err, "apis[1].plugins[0].name: not found plugin. name: test-unknown")
+	})
+
+	t.Run("should be report the path of the invalid config", func(t *testing.T) {
+		err := Validate(newDef(
+			&api.Plugin{Name: "test-noconfig"},
+			&api.Plugin{Name: "test-config", Config: map[string]interface{}{"per": "x"}},
+		))
+		assert.EqualError(t, err, "invalid plugin config. name: second plugin: test-config apis[1].plugins[1].config.per: must be contains [s|m]")
+	})
+
+	t.Run("should be report the path of the inherited plugin", func(t *testing.T) {
+		def := newDef()
+		def.Plugins = []*api.Plugin{{Name: "test-config", Config: map[string]interface{}{"per": "x"}}}
+		err := Validate(def)
+		assert.EqualError(t, err, "invalid plugin config. name: first plugin: test-config plugins[0].config.per: must be contains [s|m]")
+	})
+
+	t.Run("should be validate the config merged with the override", func(t *testing.T) {
+		def := newDef(&api.Plugin{Name: "test-config", Config: map[string]interface{}{"ratio": 0.5}})
+		def.PluginGroups = map[string][]*api.Plugin{"group": {{Name: "test-config", Config: map[string]interface{}{"disabled": true}}}}
+		def.Apis[1].PluginGroups = []string{"group"}
+		err := Validate(def)
+		assert.EqualError(t, err, "invalid plugin config. name: second plugin: test-config apis[1].plugins[0].config: ratio must not be set if disabled")
+	})
+}
+
+func TestConfigSchema(t *testing.T) {
+	Register("test-config", &Plugin{Config: newTestConfig})
+	Register("test-noconfig", &Plugin{})
+
+	t.Run("should be return nil if the plugin has no schema", func(t *testing.T) {
+		s, err := ConfigSchema("test-noconfig")
+		assert.NoError(t, err)
+		assert.Nil(t, s)
+	})
+
+	t.Run("should be build the schema from the config struct", func(t *testing.T) {
+		s, err := ConfigSchema("test-config")
+		assert.NoError(t, err)
+		assert.Equal(t, Schema{
+			"type": "object",
+			"properties": Schema{
+				"per":   Schema{"type": "string", "enum": []interface{}{"s", "m"}, "default": "s"},
+				"ratio": Schema{"type": "number", "minimum": float64(0), "maximum": float64(1)},
+				"rules": Schema{
+					"type":                 "object",
+					"properties":           Schema{"names": Schema{"type": "array", "items": Schema{"type": "string"}}},
+					"required":             []string{"names"},
+					"additionalProperties": false,
+				},
+				"labels":   Schema{"type": "object", "additionalProperties": Schema{"type": "string"}},
+				"disabled": Schema{"type": "boolean"},
+			},
+			"required":             []string{"per"},
+			"additionalProperties": false,
+		}, s)
+	})
+
+	t.Run("should be include the plugins in the definition schema", func(t *testing.T) {
+		s := DefinitionSchema()
+		assert.Contains(t, s["definitions"], "test-config")
+		assert.NotContains(t, s["definitions"], "test-noconfig")
+	})
+}
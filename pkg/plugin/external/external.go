@@ -0,0 +1,169 @@
+// Package external calls the plugin processes over gRPC.
+// The protocol is defined by proto/plugin.proto so that plugins can be written in any language.
+package external
+
+//go:generate protoc -Iproto --go_out=plugins=grpc,paths=source_relative:proto proto/plugin.proto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/httperr"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/plugin"
+	pb "github.com/purini-to/plixy/pkg/plugin/external/proto"
+)
+
+// Supervisor starts, health checks and restarts the plugin processes.
+type Supervisor struct {
+	processes []*process
+}
+
+// New registers the external plugins. The processes are started by Start.
+func New(defs []config.ExternalPlugin) (*Supervisor, error) {
+	s := &Supervisor{processes: make([]*process, 0, len(defs))}
+	registered := make(map[string]bool)
+	for _, name := range plugin.Names() {
+		registered[name] = true
+	}
+	for i, def := range defs {
+		if def.Name == "" {
+			return nil, errors.New(fmt.Sprintf("external plugin name is required. external[%d]", i))
+		}
+		if def.Address == "" {
+			return nil, errors.New(fmt.Sprintf("external plugin address is required. name: %s", def.Name))
+		}
+		if !isLocalAddress(def.Address) {
+			return nil, errors.New(fmt.Sprintf("external plugin address must be unix socket or localhost. name: %s address: %s", def.Name, def.Address))
+		}
+		if registered[def.Name] {
+			return nil, errors.New(fmt.Sprintf("external plugin name is already registered. name: %s", def.Name))
+		}
+		registered[def.Name] = true
+
+		p := newProcess(def)
+		s.processes = append(s.processes, p)
+		plugin.Register(def.Name, &plugin.Plugin{BeforeProxy: p.beforeProxy})
+	}
+	return s, nil
+}
+
+// isLocalAddress reports whether the address is a unix socket or a loopback address,
+// as the requests are sent to the plugin in plaintext.
+func isLocalAddress(address string) bool {
+	if strings.HasPrefix(address, "unix:") {
+		return len(address) > len("unix:")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start starts supervising the plugin processes.
+func (s *Supervisor) Start() error {
+	for _, p := range s.processes {
+		if err := p.start(); err != nil {
+			_ = s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the plugin processes.
+func (s *Supervisor) Close() error {
+	for _, p := range s.processes {
+		p.stop()
+	}
+	return nil
+}
+
+func (p *process) beforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error marshal config by external plugin. name: %s", p.def.Name))
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !p.Serving() {
+				p.unavailable(w, r, next, errors.New("plugin process is not serving"))
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+			res, err := p.client.BeforeProxy(ctx, newRequest(r, b))
+			cancel()
+			if err != nil {
+				p.unavailable(w, r, next, err)
+				return
+			}
+
+			if res.GetAction() == pb.BeforeProxyResponse_RESPOND {
+				for _, h := range res.GetHeaders() {
+					w.Header()[http.CanonicalHeaderKey(h.GetName())] = h.GetValues()
+				}
+				status := int(res.GetStatus())
+				if status == 0 {
+					status = http.StatusOK
+				}
+				w.WriteHeader(status)
+				_, _ = w.Write(res.GetBody())
+				return
+			}
+
+			for _, name := range res.GetRemoveHeaders() {
+				r.Header.Del(name)
+			}
+			for _, h := range res.GetSetHeaders() {
+				r.Header[http.CanonicalHeaderKey(h.GetName())] = h.GetValues()
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
+
+func (p *process) unavailable(w http.ResponseWriter, r *http.Request, next http.Handler, err error) {
+	log.FromContext(r.Context()).Warn("Could not call external plugin",
+		zap.String("name", p.def.Name), zap.Bool("fail_open", p.def.FailOpen), zap.Error(err))
+	if p.def.FailOpen {
+		next.ServeHTTP(w, r)
+		return
+	}
+	httperr.ServiceUnavailable(w)
+}
+
+func newRequest(r *http.Request, config []byte) *pb.BeforeProxyRequest {
+	ctx := r.Context()
+	req := &pb.BeforeProxyRequest{
+		Api:        api.FromContext(ctx).Name,
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		RawQuery:   r.URL.RawQuery,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    make([]*pb.Header, 0, len(r.Header)),
+		Vars:       api.VarsFromContext(ctx),
+		Config:     config,
+	}
+	for name, values := range r.Header {
+		req.Headers = append(req.Headers, &pb.Header{Name: name, Values: values})
+	}
+	return req
+}
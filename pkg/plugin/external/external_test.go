@@ -0,0 +1,222 @@
+package external
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/plugin"
+	pb "github.com/purini-to/plixy/pkg/plugin/external/proto"
+)
+
+const helperEnvKey = "PLIXY_TEST_EXTERNAL_PLUGIN"
+
+// TestMain runs the test binary as the plugin process if helperEnvKey is set.
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnvKey) == "1" {
+		address := os.Getenv(AddressEnvKey)
+		path, _ := unixPath(address)
+		lis, err := net.Listen("unix", path)
+		if err != nil {
+			os.Exit(2)
+		}
+		_ = serve(lis, &testServer{exit: true})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type testServer struct {
+	exit bool
+	got  *pb.BeforeProxyRequest
+}
+
+func (s *testServer) BeforeProxy(ctx context.Context, req *pb.BeforeProxyRequest) (*pb.BeforeProxyResponse, error) {
+	s.got = req
+	if s.exit {
+		os.Exit(1)
+	}
+	if strings.Contains(string(req.GetConfig()), "respond") {
+		return &pb.BeforeProxyResponse{
+			Action:  pb.BeforeProxyResponse_RESPOND,
+			Status:  http.StatusUnauthorized,
+			Headers: []*pb.Header{{Name: "x-reason", Values: []string{"denied"}}},
+			Body:    []byte("denied"),
+		}, nil
+	}
+	return &pb.BeforeProxyResponse{
+		SetHeaders:    []*pb.Header{{Name: "x-user", Values: []string{"alice"}}},
+		RemoveHeaders: []string{"Authorization"},
+	}, nil
+}
+
+func serve(lis net.Listener, s pb.PluginServer) error {
+	srv := grpc.NewServer()
+	pb.RegisterPluginServer(srv, s)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv.Serve(lis)
+}
+
+func newTestRequest(header http.Header) *http.Request {
+	logger, _ := zap.NewDevelopment()
+	req := httptest.NewRequest("GET", "/users/1?q=1", nil)
+	req.Header = header
+	ctx := log.ToContext(req.Context(), logger)
+	ctx = api.ToContext(ctx, &api.Api{Name: "test"})
+	ctx = api.VarsToContext(ctx, map[string]string{"id": "1"})
+	return req.WithContext(ctx)
+}
+
+func waitServing(t *testing.T, p *process, serving bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Serving() != serving {
+		if time.Now().After(deadline) {
+			t.Fatalf("process is not serving: %v", serving)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNew(t *testing.T) {
+	plugin.Register("test-builtin", &plugin.Plugin{})
+
+	tests := []struct {
+		name    string
+		defs    []config.ExternalPlugin
+		wantErr string
+	}{
+		{name: "should be register the plugins", defs: []config.ExternalPlugin{{Name: "test-external", Address: "127.0.0.1:1"}}},
+		{name: "should be error if the name is empty", defs: []config.ExternalPlugin{{Address: "127.0.0.1:1"}}, wantErr: "external plugin name is required. external[0]"},
+		{name: "should be error if the address is empty", defs: []config.ExternalPlugin{{Name: "test-noaddress"}}, wantErr: "external plugin address is required. name: test-noaddress"},
+		{name: "should be register the plugins of local addresses", defs: []config.ExternalPlugin{{Name: "test-localhost", Address: "localhost:1"}, {Name: "test-ipv6", Address: "[::1]:1"}, {Name: "test-unix", Address: "unix:/tmp/plugin.sock"}}},
+		{name: "should be error if the address is remote", defs: []config.ExternalPlugin{{Name: "test-remote", Address: "10.0.0.1:50051"}}, wantErr: "external plugin address must be unix socket or localhost. name: test-remote address: 10.0.0.1:50051"},
+		{name: "should be error if the address has no port", defs: []config.ExternalPlugin{{Name: "test-noport", Address: "localhost"}}, wantErr: "external plugin address must be unix socket or localhost. name: test-noport address: localhost"},
+		{name: "should be error if the name is registered", defs: []config.ExternalPlugin{{Name: "test-builtin", Address: "127.0.0.1:1"}}, wantErr: "external plugin name is already registered. name: test-builtin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.defs)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestProcess_beforeProxy(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &testServer{}
+	go func() { _ = serve(lis, srv) }()
+
+	p := newProcess(config.ExternalPlugin{Name: "test", Address: lis.Addr().String(), HealthInterval: 50 * time.Millisecond})
+	assert.NoError(t, p.start())
+	defer p.stop()
+	waitServing(t, p, true)
+
+	var proxied *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r
+	})
+
+	t.Run("should be continue with the modified headers", func(t *testing.T) {
+		mw, err := p.beforeProxy(map[string]interface{}{"key": "value"})
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		mw(next).ServeHTTP(rec, newTestRequest(http.Header{"Authorization": {"secret"}}))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", proxied.Header.Get("X-User"))
+		assert.Equal(t, "", proxied.Header.Get("Authorization"))
+		assert.Equal(t, "test", srv.got.GetApi())
+		assert.Equal(t, "/users/1", srv.got.GetPath())
+		assert.Equal(t, "q=1", srv.got.GetRawQuery())
+		assert.Equal(t, map[string]string{"id": "1"}, srv.got.GetVars())
+		assert.JSONEq(t, `{"key":"value"}`, string(srv.got.GetConfig()))
+	})
+
+	t.Run("should be respond without proxying", func(t *testing.T) {
+		proxied = nil
+		mw, err := p.beforeProxy(map[string]interface{}{"action": "respond"})
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		mw(next).ServeHTTP(rec, newTestRequest(http.Header{}))
+
+		assert.Nil(t, proxied)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "denied", rec.Header().Get("X-Reason"))
+		assert.Equal(t, "denied", rec.Body.String())
+	})
+}
+
+func TestProcess_unavailable(t *testing.T) {
+	newMiddleware := func(failOpen bool) http.Handler {
+		p := newProcess(config.ExternalPlugin{Name: "test", Address: "127.0.0.1:1", FailOpen: failOpen})
+		mw, err := p.beforeProxy(nil)
+		assert.NoError(t, err)
+		return mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+
+	t.Run("should be reject the request if the process is not serving", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(false).ServeHTTP(rec, newTestRequest(http.Header{}))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("should be proxy the request if fail open", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(true).ServeHTTP(rec, newTestRequest(http.Header{}))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
+
+func TestProcess_supervise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plixy-external")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p := newProcess(config.ExternalPlugin{
+		Name:           "test",
+		Command:        os.Args[0],
+		Env:            []string{helperEnvKey + "=1"},
+		Address:        "unix:" + filepath.Join(dir, "plugin.sock"),
+		HealthInterval: 50 * time.Millisecond,
+	})
+	assert.NoError(t, p.start())
+	defer p.stop()
+
+	t.Run("should be start the process", func(t *testing.T) {
+		waitServing(t, p, true)
+	})
+
+	t.Run("should be restart the process if it exits", func(t *testing.T) {
+		mw, err := p.beforeProxy(nil)
+		assert.NoError(t, err)
+		rec := httptest.NewRecorder()
+		mw(http.NotFoundHandler()).ServeHTTP(rec, newTestRequest(http.Header{}))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		waitServing(t, p, false)
+		waitServing(t, p, true)
+	})
+}
@@ -0,0 +1,271 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	pb "github.com/purini-to/plixy/pkg/plugin/external/proto"
+)
+
+const (
+	// AddressEnvKey is the environment variable passed to the process with the address to listen.
+	AddressEnvKey = "PLIXY_PLUGIN_ADDRESS"
+
+	defaultTimeout            = time.Second
+	defaultHealthInterval     = 5 * time.Second
+	defaultUnhealthyThreshold = 3
+	minRestartBackoff         = 500 * time.Millisecond
+	maxRestartBackoff         = 30 * time.Second
+	stopTimeout               = 5 * time.Second
+)
+
+// process is a plugin process and the connection to it.
+type process struct {
+	def                config.ExternalPlugin
+	timeout            time.Duration
+	healthInterval     time.Duration
+	unhealthyThreshold int
+
+	conn    *grpc.ClientConn
+	client  pb.PluginClient
+	health  healthpb.HealthClient
+	serving int32
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newProcess(def config.ExternalPlugin) *process {
+	p := &process{
+		def:                def,
+		timeout:            def.Timeout,
+		healthInterval:     def.HealthInterval,
+		unhealthyThreshold: def.UnhealthyThreshold,
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	if p.healthInterval <= 0 {
+		p.healthInterval = defaultHealthInterval
+	}
+	if p.unhealthyThreshold <= 0 {
+		p.unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	return p
+}
+
+// Serving reports whether the process has passed the last health check.
+func (p *process) Serving() bool {
+	return atomic.LoadInt32(&p.serving) == 1
+}
+
+func (p *process) setServing(serving bool) {
+	var v int32
+	if serving {
+		v = 1
+	}
+	if atomic.SwapInt32(&p.serving, v) != v {
+		if serving {
+			log.Info("External plugin became serving", zap.String("name", p.def.Name))
+		} else {
+			log.Warn("External plugin became not serving", zap.String("name", p.def.Name))
+		}
+	}
+}
+
+func (p *process) start() error {
+	conn, err := grpc.Dial(p.def.Address,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(dial),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: minRestartBackoff, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second},
+			MinConnectTimeout: p.timeout,
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not dial external plugin. name: %s address: %s", p.def.Name, p.def.Address))
+	}
+	p.conn = conn
+	p.client = pb.NewPluginClient(conn)
+	p.health = healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.wg.Add(1)
+	go p.supervise(ctx)
+	return nil
+}
+
+func (p *process) stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+	_ = p.conn.Close()
+}
+
+// supervise runs the process and restarts it if it exits or fails the health checks.
+// The process is not run if the command is empty, and only health checked.
+func (p *process) supervise(ctx context.Context) {
+	defer p.wg.Done()
+
+	restartBackoff := minRestartBackoff
+	for {
+		var r *running
+		if p.def.Command != "" {
+			r = p.run()
+		}
+
+		served, err := p.watch(ctx, r)
+		if ctx.Err() != nil {
+			atomic.StoreInt32(&p.serving, 0)
+			r.kill()
+			return
+		}
+		p.setServing(false)
+		r.kill()
+		if served {
+			restartBackoff = minRestartBackoff
+		}
+		log.Warn("Restart external plugin", zap.String("name", p.def.Name), zap.Duration("backoff", restartBackoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartBackoff):
+		}
+		restartBackoff *= 2
+		if restartBackoff > maxRestartBackoff {
+			restartBackoff = maxRestartBackoff
+		}
+	}
+}
+
+// watch health checks the process until it exits or becomes unhealthy, and reports whether it has served.
+// The error is nil only if ctx is done.
+func (p *process) watch(ctx context.Context, r *running) (bool, error) {
+	var exited <-chan struct{}
+	if r != nil {
+		exited = r.done
+	}
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+
+	served, failures := false, 0
+	for {
+		if p.check(ctx) {
+			served, failures = true, 0
+			p.setServing(true)
+		} else {
+			failures++
+			if failures >= p.unhealthyThreshold {
+				p.setServing(false)
+				if r != nil {
+					return served, errors.New("failed health checks")
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return served, nil
+		case <-exited:
+			if r.err != nil {
+				return served, errors.Wrap(r.err, "exited")
+			}
+			return served, errors.New("exited")
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *process) check(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	res, err := p.health.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		log.Debug("Failed external plugin health check", zap.String("name", p.def.Name), zap.Error(err))
+		return false
+	}
+	return res.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
+
+// running is a started process. done is closed when it exits with err.
+type running struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+func (p *process) run() *running {
+	r := &running{done: make(chan struct{})}
+	if path, ok := unixPath(p.def.Address); ok {
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+	}
+
+	r.cmd = exec.Command(p.def.Command, p.def.Args...)
+	r.cmd.Env = append(os.Environ(), p.def.Env...)
+	r.cmd.Env = append(r.cmd.Env, fmt.Sprintf("%s=%s", AddressEnvKey, p.def.Address))
+	r.cmd.Stdout = os.Stdout
+	r.cmd.Stderr = os.Stderr
+	if err := r.cmd.Start(); err != nil {
+		log.Error("Could not start external plugin", zap.String("name", p.def.Name), zap.Error(err))
+		r.cmd = nil
+		r.err = err
+		close(r.done)
+		return r
+	}
+	log.Info("Start external plugin", zap.String("name", p.def.Name), zap.Int("pid", r.cmd.Process.Pid))
+	go func() {
+		r.err = r.cmd.Wait()
+		close(r.done)
+	}()
+	return r
+}
+
+// kill stops the process gracefully, and kills it if it does not exit in time.
+func (r *running) kill() {
+	if r == nil || r.cmd == nil {
+		return
+	}
+	_ = r.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-r.done:
+	case <-time.After(stopTimeout):
+		_ = r.cmd.Process.Kill()
+		<-r.done
+	}
+}
+
+func unixPath(address string) (string, bool) {
+	if !strings.HasPrefix(address, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//"), true
+}
+
+func dial(ctx context.Context, address string) (net.Conn, error) {
+	var d net.Dialer
+	if path, ok := unixPath(address); ok {
+		return d.DialContext(ctx, "unix", path)
+	}
+	return d.DialContext(ctx, "tcp", address)
+}
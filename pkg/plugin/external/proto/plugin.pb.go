@@ -0,0 +1,418 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: plugin.proto
+
+package proto
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type BeforeProxyResponse_Action int32
+
+const (
+	// CONTINUE proxies the request after modifying the request headers.
+	BeforeProxyResponse_CONTINUE BeforeProxyResponse_Action = 0
+	// RESPOND writes the response without proxying the request.
+	BeforeProxyResponse_RESPOND BeforeProxyResponse_Action = 1
+)
+
+var BeforeProxyResponse_Action_name = map[int32]string{
+	0: "CONTINUE",
+	1: "RESPOND",
+}
+
+var BeforeProxyResponse_Action_value = map[string]int32{
+	"CONTINUE": 0,
+	"RESPOND":  1,
+}
+
+func (x BeforeProxyResponse_Action) String() string {
+	return proto.EnumName(BeforeProxyResponse_Action_name, int32(x))
+}
+
+func (BeforeProxyResponse_Action) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_22a625af4bc1cc87, []int{2, 0}
+}
+
+// Header is a header with all of its values.
+type Header struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Values               []string `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Header) Reset()         { *m = Header{} }
+func (m *Header) String() string { return proto.CompactTextString(m) }
+func (*Header) ProtoMessage()    {}
+func (*Header) Descriptor() ([]byte, []int) {
+	return fileDescriptor_22a625af4bc1cc87, []int{0}
+}
+
+func (m *Header) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Header.Unmarshal(m, b)
+}
+func (m *Header) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Header.Marshal(b, m, deterministic)
+}
+func (m *Header) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Header.Merge(m, src)
+}
+func (m *Header) XXX_Size() int {
+	return xxx_messageInfo_Header.Size(m)
+}
+func (m *Header) XXX_DiscardUnknown() {
+	xxx_messageInfo_Header.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Header proto.InternalMessageInfo
+
+func (m *Header) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Header) GetValues() []string {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+// BeforeProxyRequest is the metadata of the request to proxy.
+type BeforeProxyRequest struct {
+	// api is the name of the matched api.
+	Api        string    `protobuf:"bytes,1,opt,name=api,proto3" json:"api,omitempty"`
+	Method     string    `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Host       string    `protobuf:"bytes,3,opt,name=host,proto3" json:"host,omitempty"`
+	Path       string    `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	RawQuery   string    `protobuf:"bytes,5,opt,name=raw_query,json=rawQuery,proto3" json:"raw_query,omitempty"`
+	RemoteAddr string    `protobuf:"bytes,6,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	Headers    []*Header `protobuf:"bytes,7,rep,name=headers,proto3" json:"headers,omitempty"`
+	// vars are the path variables of the matched api.
+	Vars map[string]string `protobuf:"bytes,8,rep,name=vars,proto3" json:"vars,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// config is the JSON encoded config of the plugin in the api definition.
+	Config               []byte   `protobuf:"bytes,9,opt,name=config,proto3" json:"config,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BeforeProxyRequest) Reset()         { *m = BeforeProxyRequest{} }
+func (m *BeforeProxyRequest) String() string { return proto.CompactTextString(m) }
+func (*BeforeProxyRequest) ProtoMessage()    {}
+func (*BeforeProxyRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_22a625af4bc1cc87, []int{1}
+}
+
+func (m *BeforeProxyRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeforeProxyRequest.Unmarshal(m, b)
+}
+func (m *BeforeProxyRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeforeProxyRequest.Marshal(b, m, deterministic)
+}
+func (m *BeforeProxyRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeforeProxyRequest.Merge(m, src)
+}
+func (m *BeforeProxyRequest) XXX_Size() int {
+	return xxx_messageInfo_BeforeProxyRequest.Size(m)
+}
+func (m *BeforeProxyRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeforeProxyRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeforeProxyRequest proto.InternalMessageInfo
+
+func (m *BeforeProxyRequest) GetApi() string {
+	if m != nil {
+		return m.Api
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetRawQuery() string {
+	if m != nil {
+		return m.RawQuery
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetRemoteAddr() string {
+	if m != nil {
+		return m.RemoteAddr
+	}
+	return ""
+}
+
+func (m *BeforeProxyRequest) GetHeaders() []*Header {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *BeforeProxyRequest) GetVars() map[string]string {
+	if m != nil {
+		return m.Vars
+	}
+	return nil
+}
+
+func (m *BeforeProxyRequest) GetConfig() []byte {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+// BeforeProxyResponse is the decision of the plugin.
+type BeforeProxyResponse struct {
+	Action BeforeProxyResponse_Action `protobuf:"varint,1,opt,name=action,proto3,enum=plixy.plugin.v1.BeforeProxyResponse_Action" json:"action,omitempty"`
+	// set_headers are set to the request headers on CONTINUE.
+	SetHeaders []*Header `protobuf:"bytes,2,rep,name=set_headers,json=setHeaders,proto3" json:"set_headers,omitempty"`
+	// remove_headers are removed from the request headers on CONTINUE.
+	RemoveHeaders []string `protobuf:"bytes,3,rep,name=remove_headers,json=removeHeaders,proto3" json:"remove_headers,omitempty"`
+	// status, headers and body are the response on RESPOND.
+	Status               int32     `protobuf:"varint,4,opt,name=status,proto3" json:"status,omitempty"`
+	Headers              []*Header `protobuf:"bytes,5,rep,name=headers,proto3" json:"headers,omitempty"`
+	Body                 []byte    `protobuf:"bytes,6,opt,name=body,proto3" json:"body,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *BeforeProxyResponse) Reset()         { *m = BeforeProxyResponse{} }
+func (m *BeforeProxyResponse) String() string { return proto.CompactTextString(m) }
+func (*BeforeProxyResponse) ProtoMessage()    {}
+func (*BeforeProxyResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_22a625af4bc1cc87, []int{2}
+}
+
+func (m *BeforeProxyResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeforeProxyResponse.Unmarshal(m, b)
+}
+func (m *BeforeProxyResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeforeProxyResponse.Marshal(b, m, deterministic)
+}
+func (m *BeforeProxyResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeforeProxyResponse.Merge(m, src)
+}
+func (m *BeforeProxyResponse) XXX_Size() int {
+	return xxx_messageInfo_BeforeProxyResponse.Size(m)
+}
+func (m *BeforeProxyResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeforeProxyResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeforeProxyResponse proto.InternalMessageInfo
+
+func (m *BeforeProxyResponse) GetAction() BeforeProxyResponse_Action {
+	if m != nil {
+		return m.Action
+	}
+	return BeforeProxyResponse_CONTINUE
+}
+
+func (m *BeforeProxyResponse) GetSetHeaders() []*Header {
+	if m != nil {
+		return m.SetHeaders
+	}
+	return nil
+}
+
+func (m *BeforeProxyResponse) GetRemoveHeaders() []string {
+	if m != nil {
+		return m.RemoveHeaders
+	}
+	return nil
+}
+
+func (m *BeforeProxyResponse) GetStatus() int32 {
+	if m != nil {
+		return m.Status
+	}
+	return 0
+}
+
+func (m *BeforeProxyResponse) GetHeaders() []*Header {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *BeforeProxyResponse) GetBody() []byte {
+	if m != nil {
+		return m.Body
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("plixy.plugin.v1.BeforeProxyResponse_Action", BeforeProxyResponse_Action_name, BeforeProxyResponse_Action_value)
+	proto.RegisterType((*Header)(nil), "plixy.plugin.v1.Header")
+	proto.RegisterType((*BeforeProxyRequest)(nil), "plixy.plugin.v1.BeforeProxyRequest")
+	proto.RegisterMapType((map[string]string)(nil), "plixy.plugin.v1.BeforeProxyRequest.VarsEntry")
+	proto.RegisterType((*BeforeProxyResponse)(nil), "plixy.plugin.v1.BeforeProxyResponse")
+}
+
+func init() { proto.RegisterFile("plugin.proto", fileDescriptor_22a625af4bc1cc87) }
+
+var fileDescriptor_22a625af4bc1cc87 = []byte{
+	// 502 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0x5d, 0x6f, 0xda, 0x30,
+	0x14, 0x1d, 0x01, 0x02, 0x5c, 0x58, 0x87, 0xbc, 0xa9, 0xb3, 0xba, 0x87, 0x21, 0xba, 0x49, 0x48,
+	0x53, 0x13, 0x95, 0x4d, 0x5a, 0xd5, 0xed, 0x85, 0x76, 0x48, 0xdd, 0x0b, 0x65, 0xde, 0x87, 0xa6,
+	0xbd, 0x20, 0x43, 0x6e, 0x49, 0x04, 0xc4, 0xa9, 0xed, 0x50, 0xf2, 0xeb, 0xf6, 0x83, 0xf6, 0x27,
+	0x26, 0x3b, 0xa1, 0xea, 0x56, 0xa9, 0xe2, 0x05, 0xee, 0x39, 0xbe, 0xe7, 0x3a, 0xe7, 0xdc, 0x04,
+	0x5a, 0xc9, 0x32, 0x9d, 0x47, 0xb1, 0x97, 0x48, 0xa1, 0x05, 0x79, 0x92, 0x2c, 0xa3, 0x4d, 0xe6,
+	0x15, 0xdc, 0xfa, 0xb8, 0xfb, 0x0e, 0xdc, 0x0b, 0xe4, 0x01, 0x4a, 0x42, 0xa0, 0x12, 0xf3, 0x15,
+	0xd2, 0x52, 0xa7, 0xd4, 0x6b, 0x30, 0x5b, 0x93, 0x7d, 0x70, 0xd7, 0x7c, 0x99, 0xa2, 0xa2, 0x4e,
+	0xa7, 0xdc, 0x6b, 0xb0, 0x02, 0x75, 0xff, 0x38, 0x40, 0xce, 0xf0, 0x4a, 0x48, 0x1c, 0x4b, 0xb1,
+	0xc9, 0x18, 0x5e, 0xa7, 0xa8, 0x34, 0x69, 0x43, 0x99, 0x27, 0x51, 0x31, 0xc1, 0x94, 0x66, 0xc0,
+	0x0a, 0x75, 0x28, 0x02, 0xea, 0x58, 0xb2, 0x40, 0xe6, 0xb2, 0x50, 0x28, 0x4d, 0xcb, 0xf9, 0x65,
+	0xa6, 0x36, 0x5c, 0xc2, 0x75, 0x48, 0x2b, 0x39, 0x67, 0x6a, 0xf2, 0x02, 0x1a, 0x92, 0xdf, 0x4c,
+	0xae, 0x53, 0x94, 0x19, 0xad, 0xda, 0x83, 0xba, 0xe4, 0x37, 0x5f, 0x0c, 0x26, 0x2f, 0xa1, 0x29,
+	0x71, 0x25, 0x34, 0x4e, 0x78, 0x10, 0x48, 0xea, 0xda, 0x63, 0xc8, 0xa9, 0x41, 0x10, 0x48, 0x72,
+	0x0c, 0xb5, 0xd0, 0x9a, 0x53, 0xb4, 0xd6, 0x29, 0xf7, 0x9a, 0xfd, 0xe7, 0xde, 0x7f, 0xfe, 0xbd,
+	0xdc, 0x3c, 0xdb, 0xf6, 0x91, 0x01, 0x54, 0xd6, 0x5c, 0x2a, 0x5a, 0xb7, 0xfd, 0x47, 0xf7, 0xfa,
+	0xef, 0xbb, 0xf6, 0x7e, 0x70, 0xa9, 0x86, 0xb1, 0x96, 0x19, 0xb3, 0x52, 0xe3, 0x79, 0x26, 0xe2,
+	0xab, 0x68, 0x4e, 0x1b, 0x9d, 0x52, 0xaf, 0xc5, 0x0a, 0x74, 0xf0, 0x1e, 0x1a, 0xb7, 0xad, 0x26,
+	0xaa, 0x05, 0x66, 0xdb, 0xa8, 0x16, 0x98, 0x91, 0x67, 0x50, 0xb5, 0xe9, 0x16, 0x49, 0xe5, 0xe0,
+	0xd4, 0x39, 0x29, 0x75, 0x7f, 0x3b, 0xf0, 0xf4, 0x9f, 0x7b, 0x55, 0x22, 0x62, 0x85, 0xe4, 0x1c,
+	0x5c, 0x3e, 0xd3, 0x91, 0x88, 0xed, 0x98, 0xbd, 0xfe, 0x9b, 0x87, 0x9f, 0x36, 0x57, 0x79, 0x03,
+	0x2b, 0x61, 0x85, 0x94, 0x9c, 0x40, 0x53, 0xa1, 0x9e, 0x6c, 0x73, 0x72, 0x1e, 0xce, 0x09, 0x14,
+	0xea, 0x8b, 0x22, 0xaa, 0xd7, 0xb0, 0x67, 0xb2, 0x5e, 0xe3, 0xad, 0xb8, 0x6c, 0x5f, 0x92, 0xc7,
+	0x39, 0xbb, 0x6d, 0xdb, 0x07, 0x57, 0x69, 0xae, 0x53, 0x65, 0x17, 0x5b, 0x65, 0x05, 0xba, 0xbb,
+	0x9c, 0xea, 0x8e, 0xcb, 0x21, 0x50, 0x99, 0x8a, 0x20, 0xb3, 0x9b, 0x6e, 0x31, 0x5b, 0x77, 0x0f,
+	0xc1, 0xcd, 0x1d, 0x91, 0x16, 0xd4, 0xcf, 0x2f, 0x47, 0xdf, 0x3e, 0x8f, 0xbe, 0x0f, 0xdb, 0x8f,
+	0x48, 0x13, 0x6a, 0x6c, 0xf8, 0x75, 0x7c, 0x39, 0xfa, 0xd4, 0x2e, 0xf5, 0xa7, 0xe0, 0x8e, 0xed,
+	0x54, 0xf2, 0x13, 0x9a, 0x77, 0x42, 0x21, 0x87, 0x3b, 0x2c, 0xf8, 0xe0, 0xd5, 0x2e, 0xb9, 0x9e,
+	0x7d, 0xfc, 0x75, 0x3a, 0x8f, 0x74, 0x98, 0x4e, 0xbd, 0x99, 0x58, 0xf9, 0x49, 0x2a, 0xa3, 0x38,
+	0x3a, 0xd2, 0xc2, 0xb7, 0x5a, 0x3f, 0x59, 0xcc, 0xfd, 0x5c, 0xef, 0xe3, 0x46, 0xa3, 0x8c, 0xf9,
+	0xd2, 0xb7, 0x9f, 0xe4, 0x07, 0xfb, 0x3b, 0x75, 0xed, 0xdf, 0xdb, 0xbf, 0x03, 0x00, 0x4c, 0xc0,
+	0x06, 0xba, 0xaf, 0x03, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// PluginClient is the client API for Plugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type PluginClient interface {
+	// BeforeProxy decides whether the request is proxied to the upstream.
+	BeforeProxy(ctx context.Context, in *BeforeProxyRequest, opts ...grpc.CallOption) (*BeforeProxyResponse, error)
+}
+
+type pluginClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewPluginClient(cc *grpc.ClientConn) PluginClient {
+	return &pluginClient{cc}
+}
+
+func (c *pluginClient) BeforeProxy(ctx context.Context, in *BeforeProxyRequest, opts ...grpc.CallOption) (*BeforeProxyResponse, error) {
+	out := new(BeforeProxyResponse)
+	err := c.cc.Invoke(ctx, "/plixy.plugin.v1.Plugin/BeforeProxy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PluginServer is the server API for Plugin service.
+type PluginServer interface {
+	// BeforeProxy decides whether the request is proxied to the upstream.
+	BeforeProxy(context.Context, *BeforeProxyRequest) (*BeforeProxyResponse, error)
+}
+
+// UnimplementedPluginServer can be embedded to have forward compatible implementations.
+type UnimplementedPluginServer struct {
+}
+
+func (*UnimplementedPluginServer) BeforeProxy(ctx context.Context, req *BeforeProxyRequest) (*BeforeProxyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeforeProxy not implemented")
+}
+
+func RegisterPluginServer(s *grpc.Server, srv PluginServer) {
+	s.RegisterService(&_Plugin_serviceDesc, srv)
+}
+
+func _Plugin_BeforeProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeforeProxyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginServer).BeforeProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plixy.plugin.v1.Plugin/BeforeProxy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginServer).BeforeProxy(ctx, req.(*BeforeProxyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Plugin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "plixy.plugin.v1.Plugin",
+	HandlerType: (*PluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BeforeProxy",
+			Handler:    _Plugin_BeforeProxy_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
@@ -0,0 +1,79 @@
+package headers
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+func init() {
+	plugin.Register("headers", &plugin.Plugin{
+		Config:      newConfig,
+		BeforeProxy: BeforeProxy,
+		AfterProxy:  AfterProxy,
+	})
+}
+
+// Rules are applied in the order of remove, set and add.
+type Rules struct {
+	Set    map[string]string `json:"set"`
+	Add    map[string]string `json:"add"`
+	Remove []string          `json:"remove"`
+}
+
+type Config struct {
+	Request  *Rules `json:"request"`
+	Response *Rules `json:"response"`
+}
+
+func newConfig() interface{} {
+	return &Config{}
+}
+
+// BeforeProxy rewrites the headers of the request to the upstream.
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config by headers plugin")
+	}
+
+	return func(next http.Handler) http.Handler {
+		if c.Request == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Request.apply(r.Header)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// AfterProxy rewrites the headers of the response from the upstream.
+func AfterProxy(_ *api.Api, config map[string]interface{}) (plugin.AfterProxyHook, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config by headers plugin")
+	}
+
+	return func(res *http.Response) error {
+		if c.Response != nil {
+			c.Response.apply(res.Header)
+		}
+		return nil
+	}, nil
+}
+
+func (r *Rules) apply(h http.Header) {
+	for _, k := range r.Remove {
+		h.Del(k)
+	}
+	for k, v := range r.Set {
+		h.Set(k, v)
+	}
+	for k, v := range r.Add {
+		h.Add(k, v)
+	}
+}
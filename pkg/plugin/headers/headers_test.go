@@ -0,0 +1,55 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaders(t *testing.T) {
+	config := map[string]interface{}{
+		"request": map[string]interface{}{
+			"set":    map[string]interface{}{"X-Set": "set"},
+			"remove": []interface{}{"X-Remove"},
+		},
+		"response": map[string]interface{}{
+			"add":    map[string]interface{}{"X-Add": "add"},
+			"remove": []interface{}{"Server"},
+		},
+	}
+
+	t.Run("should be rewrite the request headers", func(t *testing.T) {
+		mw, err := BeforeProxy(config)
+		assert.NoError(t, err)
+
+		var got http.Header
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header
+		}))
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Set", "old")
+		req.Header.Set("X-Remove", "remove")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, "set", got.Get("X-Set"))
+		assert.Equal(t, "", got.Get("X-Remove"))
+	})
+
+	t.Run("should be rewrite the response headers", func(t *testing.T) {
+		hook, err := AfterProxy(nil, config)
+		assert.NoError(t, err)
+
+		res := &http.Response{Header: http.Header{}}
+		res.Header.Set("Server", "upstream")
+		res.Header.Set("X-Add", "upstream")
+		assert.NoError(t, hook(res))
+		assert.Equal(t, "", res.Header.Get("Server"))
+		assert.Equal(t, []string{"upstream", "add"}, res.Header["X-Add"])
+	})
+
+	t.Run("should be return error if the config is invalid", func(t *testing.T) {
+		_, err := AfterProxy(nil, map[string]interface{}{"response": "invalid"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,384 @@
+// Package jwt authenticates the requests by the bearer tokens of JSON Web Token.
+//
+// The signature is verified by the keys of the JSON Web Key Set fetched from jwksUrl or read from jwksFile,
+// or by the secret for HS256. The exp claim is required, and nbf, iss and aud are checked if configured.
+// The claims are authorized by requiredScopes and requiredClaims, and forwarded to the upstream by forwardClaims.
+// The claim names can be the dotted path of the nested claims like `realm_access.roles`.
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/httperr"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+const (
+	defaultLeeway          = "30s"
+	defaultRefreshInterval = "10m"
+)
+
+var supportedAlgorithms = []string{
+	string(jose.RS256), string(jose.ES256), string(jose.HS256), string(jose.EdDSA),
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+func init() {
+	plugin.Register("jwt", &plugin.Plugin{
+		Config:      newConfig,
+		BeforeProxy: BeforeProxy,
+	})
+}
+
+type Config struct {
+	// JwksURL is the url of the JSON Web Key Set of the issuer.
+	JwksURL string `json:"jwksUrl"`
+	// JwksFile is the path of the JSON Web Key Set file.
+	JwksFile string `json:"jwksFile"`
+	// Secret is the shared key of HS256.
+	Secret string `json:"secret"`
+	// Algorithms are the accepted signature algorithms.
+	Algorithms []string `json:"algorithms"`
+	Issuer     string   `json:"issuer"`
+	// Audiences are the accepted audiences, and the token must have one of them.
+	Audiences []string `json:"audiences"`
+	// Leeway is the allowed clock skew on exp and nbf.
+	Leeway string `json:"leeway"`
+	// RefreshInterval is how long the keys fetched from jwksUrl are cached.
+	RefreshInterval string `json:"refreshInterval"`
+	// ForwardClaims maps the claim names to the request headers to the upstream.
+	ForwardClaims map[string]string `json:"forwardClaims"`
+	// RequiredScopes must be all included in the scope or scp claim.
+	RequiredScopes []string `json:"requiredScopes"`
+	// RequiredClaims maps the claim names to the accepted values, and the claim must have one of them.
+	RequiredClaims map[string][]string `json:"requiredClaims"`
+
+	leeway time.Duration
+	keys   keySource
+}
+
+func newConfig() interface{} {
+	return &Config{
+		Algorithms:      append([]string(nil), supportedAlgorithms...),
+		Leeway:          defaultLeeway,
+		RefreshInterval: defaultRefreshInterval,
+	}
+}
+
+// Validate reads the key set of jwksFile so that invalid keys are found when the definition is loaded.
+func (c *Config) Validate() error {
+	if c.JwksURL != "" && c.JwksFile != "" {
+		return &plugin.ConfigError{Message: "either jwksUrl or jwksFile can be set"}
+	}
+	if c.JwksURL == "" && c.JwksFile == "" && c.Secret == "" {
+		return &plugin.ConfigError{Message: "one of jwksUrl, jwksFile or secret is required"}
+	}
+	if len(c.Algorithms) == 0 {
+		return &plugin.ConfigError{Field: "algorithms", Message: "must not be empty"}
+	}
+	for _, alg := range c.Algorithms {
+		if !contains(supportedAlgorithms, alg) {
+			return &plugin.ConfigError{Field: "algorithms",
+				Message: fmt.Sprintf("must be contains [%s]", strings.Join(supportedAlgorithms, "|"))}
+		}
+	}
+
+	leeway, err := time.ParseDuration(c.Leeway)
+	if err != nil {
+		return &plugin.ConfigError{Field: "leeway", Message: err.Error()}
+	}
+	if leeway < 0 {
+		return &plugin.ConfigError{Field: "leeway", Message: "must not be negative"}
+	}
+	c.leeway = leeway
+
+	refresh, err := time.ParseDuration(c.RefreshInterval)
+	if err != nil {
+		return &plugin.ConfigError{Field: "refreshInterval", Message: err.Error()}
+	}
+	if refresh <= 0 {
+		return &plugin.ConfigError{Field: "refreshInterval", Message: "must be greater than 0"}
+	}
+
+	switch {
+	case c.JwksFile != "":
+		set, err := readKeySet(c.JwksFile)
+		if err != nil {
+			return &plugin.ConfigError{Field: "jwksFile", Message: err.Error()}
+		}
+		c.keys = staticKeys{set: set}
+	case c.JwksURL != "":
+		if !strings.HasPrefix(c.JwksURL, "http://") && !strings.HasPrefix(c.JwksURL, "https://") {
+			return &plugin.ConfigError{Field: "jwksUrl", Message: "must be http or https url"}
+		}
+		c.keys = remoteKeysOf(c.JwksURL, refresh)
+	}
+	return nil
+}
+
+// BeforeProxy responds 401 to the requests without the valid token,
+// and 403 to the requests whose claims do not satisfy the rules.
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config by jwt plugin")
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			logger := log.FromContext(r.Context())
+			token, ok := bearerToken(r)
+			if !ok {
+				unauthorized(w, "")
+				return
+			}
+
+			claims, err := c.verify(r, token)
+			if err != nil {
+				if errors.Cause(err) == errKeysUnavailable {
+					logger.Error("Could not get keys by jwt plugin",
+						zap.String("name", api.FromContext(r.Context()).Name), zap.String("jwksUrl", c.JwksURL), zap.Error(err))
+					httperr.ServiceUnavailable(w)
+					return
+				}
+				logger.Debug("Rejected the token by jwt plugin", zap.Error(err))
+				unauthorized(w, "invalid_token")
+				return
+			}
+			if err := c.authorize(claims); err != nil {
+				logger.Debug("Rejected the claims by jwt plugin", zap.Error(err))
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				httperr.Forbidden(w)
+				return
+			}
+
+			for name, header := range c.ForwardClaims {
+				r.Header.Del(header)
+				if v, ok := claimValue(claims, name); ok {
+					r.Header.Set(header, formatClaim(v))
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// verify verifies the signature and the registered claims of the token, and returns all claims.
+func (c *Config) verify(r *http.Request, token string) (map[string]interface{}, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed token")
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errInvalidToken
+	}
+	h := tok.Headers[0]
+	if !contains(c.Algorithms, h.Algorithm) {
+		return nil, errors.New(fmt.Sprintf("unaccepted algorithm: %s", h.Algorithm))
+	}
+
+	keys, err := c.candidateKeys(r, h)
+	if err != nil {
+		return nil, err
+	}
+	var std jwt.Claims
+	var claims map[string]interface{}
+	verified := false
+	for _, key := range keys {
+		if err := tok.Claims(key, &std, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New(fmt.Sprintf("signature is not verified. kid: %s", h.KeyID))
+	}
+
+	if std.Expiry == nil {
+		return nil, errors.New("exp is required")
+	}
+	if err := std.ValidateWithLeeway(jwt.Expected{Issuer: c.Issuer, Time: time.Now()}, c.leeway); err != nil {
+		return nil, err
+	}
+	if len(c.Audiences) > 0 {
+		accepted := false
+		for _, aud := range c.Audiences {
+			if std.Audience.Contains(aud) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return nil, jwt.ErrInvalidAudience
+		}
+	}
+	return claims, nil
+}
+
+// candidateKeys returns the keys that can verify the token signed by the algorithm and the key id of the header.
+func (c *Config) candidateKeys(r *http.Request, h jose.Header) ([]interface{}, error) {
+	if h.Algorithm == string(jose.HS256) && c.Secret != "" {
+		return []interface{}{[]byte(c.Secret)}, nil
+	}
+	if c.keys == nil {
+		return nil, errors.New(fmt.Sprintf("no keys for algorithm: %s", h.Algorithm))
+	}
+	set, err := c.keys.get(r.Context(), h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := set.Keys
+	if h.KeyID != "" {
+		jwks = set.Key(h.KeyID)
+	}
+	var keys []interface{}
+	for _, k := range jwks {
+		if (k.Use == "" || k.Use == "sig") && (k.Algorithm == "" || k.Algorithm == h.Algorithm) {
+			keys = append(keys, k.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New(fmt.Sprintf("key is not found. kid: %s", h.KeyID))
+	}
+	return keys, nil
+}
+
+// authorize checks the claims by requiredScopes and requiredClaims.
+func (c *Config) authorize(claims map[string]interface{}) error {
+	if len(c.RequiredScopes) > 0 {
+		scopes := scopesOf(claims)
+		for _, s := range c.RequiredScopes {
+			if !contains(scopes, s) {
+				return errors.New(fmt.Sprintf("scope is required: %s", s))
+			}
+		}
+	}
+	for name, accepted := range c.RequiredClaims {
+		v, ok := claimValue(claims, name)
+		if !ok || !matchClaim(v, accepted) {
+			return errors.New(fmt.Sprintf("claim is not accepted: %s", name))
+		}
+	}
+	return nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+	return token, token != ""
+}
+
+func unauthorized(w http.ResponseWriter, code string) {
+	challenge := "Bearer"
+	if code != "" {
+		challenge += fmt.Sprintf(` error="%s"`, code)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// scopesOf returns the scopes of the space separated scope claim or the scp claim of a string or an array.
+func scopesOf(claims map[string]interface{}) []string {
+	var scopes []string
+	for _, name := range []string{"scope", "scp"} {
+		switch v := claims[name].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(v)...)
+		case []interface{}:
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+		}
+	}
+	return scopes
+}
+
+// claimValue returns the claim of the name, which is the dotted path of the nested claims.
+func claimValue(claims map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := claims[name]; ok {
+		return v, true
+	}
+	var v interface{} = claims
+	for _, key := range strings.Split(name, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// matchClaim reports whether the claim or one of the elements of the array claim is accepted.
+func matchClaim(v interface{}, accepted []string) bool {
+	if vs, ok := v.([]interface{}); ok {
+		for _, v := range vs {
+			if matchClaim(v, accepted) {
+				return true
+			}
+		}
+		return false
+	}
+	switch v.(type) {
+	case map[string]interface{}, nil:
+		return false
+	}
+	return contains(accepted, formatClaim(v))
+}
+
+// formatClaim formats the claim as the header value.
+// The array of strings is joined by commas, and the other objects are encoded as JSON.
+func formatClaim(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, s := range v {
+			s, ok := s.(string)
+			if !ok {
+				b, _ := json.Marshal(v)
+				return string(b)
+			}
+			ss = append(ss, s)
+		}
+		return strings.Join(ss, ",")
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
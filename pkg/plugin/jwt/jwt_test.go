@@ -0,0 +1,334 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+type testKey struct {
+	alg jose.SignatureAlgorithm
+	kid string
+	key interface{}
+	pub interface{}
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return []testKey{
+		{alg: jose.RS256, kid: "rsa", key: rsaKey, pub: &rsaKey.PublicKey},
+		{alg: jose.ES256, kid: "ec", key: ecKey, pub: &ecKey.PublicKey},
+		{alg: jose.EdDSA, kid: "ed", key: edKey, pub: edPub},
+	}
+}
+
+func keySet(keys []testKey) []byte {
+	set := jose.JSONWebKeySet{}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: k.pub, KeyID: k.kid, Algorithm: string(k.alg), Use: "sig"})
+	}
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func sign(t *testing.T, k testKey, claims map[string]interface{}) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if k.kid != "" {
+		opts = opts.WithHeader("kid", k.kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: k.alg, Key: k.key}, opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return token
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user1",
+		"iss":   "https://issuer.example.com",
+		"aud":   []string{"plixy"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "read write",
+		"roles": []string{"admin", "user"},
+		"org":   map[string]interface{}{"id": 10},
+	}
+}
+
+func serve(t *testing.T, config map[string]interface{}, token string) (*httptest.ResponseRecorder, *http.Request) {
+	mw, err := BeforeProxy(config)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var upstream *http.Request
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r
+	}))
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("X-User-Id", "spoofed")
+	ctx := log.ToContext(req.Context(), zap.NewNop())
+	ctx = api.ToContext(ctx, &api.Api{Name: "test"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req.WithContext(ctx))
+	return rec, upstream
+}
+
+func TestBeforeProxy(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(keySet(keys))
+	}))
+	defer jwks.Close()
+	hs := testKey{alg: jose.HS256, key: []byte(testSecret)}
+
+	config := func() map[string]interface{} {
+		return map[string]interface{}{
+			"jwksUrl":       jwks.URL,
+			"secret":        testSecret,
+			"issuer":        "https://issuer.example.com",
+			"audiences":     []string{"other", "plixy"},
+			"forwardClaims": map[string]string{"sub": "X-User-Id", "roles": "X-Roles", "org.id": "X-Org-Id"},
+		}
+	}
+
+	for _, k := range append(keys, hs) {
+		k := k
+		t.Run("should be accept the token signed by "+string(k.alg), func(t *testing.T) {
+			rec, upstream := serve(t, config(), sign(t, k, validClaims()))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			if assert.NotNil(t, upstream) {
+				assert.Equal(t, "user1", upstream.Header.Get("X-User-Id"))
+				assert.Equal(t, "admin,user", upstream.Header.Get("X-Roles"))
+				assert.Equal(t, "10", upstream.Header.Get("X-Org-Id"))
+			}
+		})
+	}
+
+	t.Run("should be reject the invalid tokens", func(t *testing.T) {
+		expired := validClaims()
+		expired["exp"] = time.Now().Add(-time.Hour).Unix()
+		noExp := validClaims()
+		delete(noExp, "exp")
+		notBefore := validClaims()
+		notBefore["nbf"] = time.Now().Add(time.Hour).Unix()
+		issuer := validClaims()
+		issuer["iss"] = "https://evil.example.com"
+		audience := validClaims()
+		audience["aud"] = "unknown"
+		unknownKid := keys[0]
+		unknownKid.kid = "unknown"
+		otherSecret := testKey{alg: jose.HS256, key: []byte("another secret another secret ..")}
+
+		tests := []struct {
+			name  string
+			token string
+		}{
+			{name: "missing", token: ""},
+			{name: "malformed", token: "a.b.c"},
+			{name: "expired", token: sign(t, keys[0], expired)},
+			{name: "without exp", token: sign(t, keys[0], noExp)},
+			{name: "not before", token: sign(t, keys[1], notBefore)},
+			{name: "issuer", token: sign(t, keys[2], issuer)},
+			{name: "audience", token: sign(t, keys[0], audience)},
+			{name: "unknown kid", token: sign(t, unknownKid, validClaims())},
+			{name: "other secret", token: sign(t, otherSecret, validClaims())},
+		}
+		for _, tt := range tests {
+			rec, upstream := serve(t, config(), tt.token)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, tt.name)
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer", tt.name)
+			assert.Nil(t, upstream, tt.name)
+		}
+	})
+
+	t.Run("should be reject the algorithm not accepted", func(t *testing.T) {
+		c := config()
+		c["algorithms"] = []string{"RS256"}
+		rec, _ := serve(t, c, sign(t, hs, validClaims()))
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("should be authorize by the claims", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			rules  map[string]interface{}
+			status int
+		}{
+			{name: "scopes", rules: map[string]interface{}{"requiredScopes": []string{"read", "write"}}, status: http.StatusOK},
+			{name: "missing scope", rules: map[string]interface{}{"requiredScopes": []string{"admin"}}, status: http.StatusForbidden},
+			{name: "claims", rules: map[string]interface{}{"requiredClaims": map[string][]string{"roles": {"admin"}, "org.id": {"10"}}}, status: http.StatusOK},
+			{name: "unaccepted claim", rules: map[string]interface{}{"requiredClaims": map[string][]string{"roles": {"owner"}}}, status: http.StatusForbidden},
+			{name: "missing claim", rules: map[string]interface{}{"requiredClaims": map[string][]string{"tenant": {"a"}}}, status: http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			c := config()
+			for k, v := range tt.rules {
+				c[k] = v
+			}
+			rec, _ := serve(t, c, sign(t, keys[0], validClaims()))
+
+			assert.Equal(t, tt.status, rec.Code, tt.name)
+		}
+	})
+
+	t.Run("should be verify by the keys of the file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwt")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "jwks.json")
+		assert.NoError(t, ioutil.WriteFile(file, keySet(keys), 0600))
+
+		rec, _ := serve(t, map[string]interface{}{"jwksFile": file}, sign(t, keys[1], validClaims()))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec, _ = serve(t, map[string]interface{}{"jwksFile": file}, sign(t, hs, validClaims()))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("should be unavailable if the keys cannot be fetched", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		defer down.Close()
+		rec, _ := serve(t, map[string]interface{}{"jwksUrl": down.URL}, sign(t, keys[0], validClaims()))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+func TestRemoteKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	fetched := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		if fetched > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(keySet(keys[:1]))
+	}))
+	defer server.Close()
+	k := &remoteKeys{url: server.URL, interval: time.Hour, client: server.Client()}
+
+	t.Run("should be cache the keys", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			set, err := k.get(context.Background(), "rsa")
+			assert.NoError(t, err)
+			assert.Len(t, set.Keys, 1)
+		}
+		assert.Equal(t, 1, fetched)
+	})
+
+	t.Run("should be use the stale keys if the fetch failed", func(t *testing.T) {
+		k.fetchedAt = time.Now().Add(-2 * time.Hour)
+		k.checkedAt = k.fetchedAt
+		set, err := k.get(context.Background(), "rsa")
+
+		assert.NoError(t, err)
+		assert.Len(t, set.Keys, 1)
+		assert.Equal(t, 2, fetched)
+	})
+
+	t.Run("should be limit the fetches of the unknown key ids", func(t *testing.T) {
+		_, err := k.get(context.Background(), "unknown")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, fetched)
+	})
+}
+
+func TestRemoteKeys_canceled(t *testing.T) {
+	keys := newTestKeys(t)
+	release := make(chan struct{})
+	var fetched int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		_, _ = w.Write(keySet(keys[:1]))
+	}))
+	defer server.Close()
+	k := &remoteKeys{url: server.URL, interval: time.Hour, client: server.Client()}
+
+	t.Run("should be not wait the fetch after the request is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := k.get(ctx, "rsa")
+
+		assert.Equal(t, errKeysUnavailable, err)
+	})
+
+	t.Run("should be share the fetch not canceled by the request", func(t *testing.T) {
+		close(release)
+		set, err := k.get(context.Background(), "rsa")
+
+		assert.NoError(t, err)
+		if assert.NotNil(t, set) {
+			assert.Len(t, set.Keys, 1)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{name: "should be valid with the secret", config: map[string]interface{}{"secret": testSecret}},
+		{name: "should be error without the keys", config: map[string]interface{}{}, wantErr: "one of jwksUrl, jwksFile or secret is required"},
+		{name: "should be error with both of the key sets", config: map[string]interface{}{"jwksUrl": "https://example.com", "jwksFile": "jwks.json"}, wantErr: "either jwksUrl or jwksFile can be set"},
+		{name: "should be error by the unsupported algorithm", config: map[string]interface{}{"secret": testSecret, "algorithms": []string{"none"}}, wantErr: "algorithms: must be contains"},
+		{name: "should be error by the invalid leeway", config: map[string]interface{}{"secret": testSecret, "leeway": "-1s"}, wantErr: "leeway: must not be negative"},
+		{name: "should be error by the invalid url", config: map[string]interface{}{"jwksUrl": "file:///jwks.json"}, wantErr: "jwksUrl: must be http or https url"},
+		{name: "should be error if the file is not found", config: map[string]interface{}{"jwksFile": "notfound.json"}, wantErr: "jwksFile: open notfound.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BeforeProxy(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
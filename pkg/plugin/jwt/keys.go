@@ -0,0 +1,146 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	fetchTimeout  = 5 * time.Second
+	maxKeySetSize = 1 << 20
+	// minRefreshInterval limits the fetches of the unknown key ids and the retries after the failures.
+	minRefreshInterval = 10 * time.Second
+)
+
+var errKeysUnavailable = errors.New("keys are unavailable")
+
+// remotes are the key sets fetched from the urls, which are shared between the reloads of the definition.
+var remotes sync.Map
+
+type keySource interface {
+	// get returns the key set that has the key of kid if it is known.
+	get(ctx context.Context, kid string) (*jose.JSONWebKeySet, error)
+}
+
+type staticKeys struct {
+	set *jose.JSONWebKeySet
+}
+
+func (k staticKeys) get(_ context.Context, _ string) (*jose.JSONWebKeySet, error) {
+	return k.set, nil
+}
+
+func readKeySet(file string) (*jose.JSONWebKeySet, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeySet(b)
+}
+
+func parseKeySet(b []byte) (*jose.JSONWebKeySet, error) {
+	set := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, errors.Wrap(err, "invalid key set")
+	}
+	return set, nil
+}
+
+// remoteKeys caches the key set fetched from the url for the interval.
+// The stale key set is used while the url is failing.
+// The fetch is shared by the concurrent requests and is not canceled by them.
+type remoteKeys struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	group    singleflight.Group
+
+	mu        sync.Mutex
+	set       *jose.JSONWebKeySet
+	fetchedAt time.Time
+	checkedAt time.Time
+}
+
+func remoteKeysOf(url string, interval time.Duration) *remoteKeys {
+	k, _ := remotes.LoadOrStore(fmt.Sprintf("%s %s", url, interval), &remoteKeys{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: fetchTimeout},
+	})
+	return k.(*remoteKeys)
+}
+
+func (k *remoteKeys) get(ctx context.Context, kid string) (*jose.JSONWebKeySet, error) {
+	k.mu.Lock()
+	set := k.set
+	now := time.Now()
+	refresh := set == nil || now.Sub(k.fetchedAt) >= k.interval ||
+		(kid != "" && len(set.Key(kid)) == 0)
+	refresh = refresh && now.Sub(k.checkedAt) >= minRefreshInterval
+	k.mu.Unlock()
+
+	if refresh {
+		select {
+		case res := <-k.group.DoChan(k.url, k.refresh):
+			if res.Err != nil && set == nil {
+				return nil, errors.Wrap(errKeysUnavailable, res.Err.Error())
+			}
+			if res.Err == nil {
+				set = res.Val.(*jose.JSONWebKeySet)
+			}
+		case <-ctx.Done():
+			// the fetch goes on for the next requests
+		}
+	}
+	if set == nil {
+		return nil, errKeysUnavailable
+	}
+	return set, nil
+}
+
+// refresh fetches the key set with its own time limit and caches it.
+func (k *remoteKeys) refresh() (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	set, err := k.fetch(ctx)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.checkedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	k.set = set
+	k.fetchedAt = k.checkedAt
+	return set, nil
+}
+
+func (k *remoteKeys) fetch(ctx context.Context) (*jose.JSONWebKeySet, error) {
+	req, err := http.NewRequest(http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("unexpected status: %d", res.StatusCode))
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxKeySetSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseKeySet(b)
+}
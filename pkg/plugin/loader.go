@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	goplugin "plugin"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+const (
+	// APIVersion is the version of the plugin api, changed when Plugin or the hooks are changed incompatibly.
+	APIVersion = "1"
+
+	// VersionSymbol is the string variable of the shared object set to APIVersion it is built with.
+	VersionSymbol = "PlixyPluginAPIVersion"
+	// RegisterSymbol is the function of the shared object that registers its plugins by Register.
+	RegisterSymbol = "PlixyRegister"
+)
+
+// loading serializes the loads of the shared objects, as they share the guard of Register.
+var loading sync.Mutex
+
+type lookupFunc func(symbol string) (goplugin.Symbol, error)
+
+// OpenDir opens the shared objects in the directory in the order of the file names.
+func OpenDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read plugin dir. dir: %s", dir))
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".so") {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, f.Name()))
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := Open(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open opens the shared object built with -buildmode=plugin and registers its plugins.
+// The shared object must be built by the same Go version and the same versions of the packages as plixy.
+func Open(path string) error {
+	return register(path, func() (lookupFunc, error) {
+		p, err := goplugin.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not open plugin, it must be built with -buildmode=plugin by %s and the same packages as plixy %s, and plixy must be built with cgo. file: %s",
+				runtime.Version(), config.Version, path))
+		}
+		return p.Lookup, nil
+	})
+}
+
+// register opens the shared object by open and registers its plugins.
+// The shared object cannot replace the registered plugins even from its init functions,
+// and the plugins it registered are removed if it fails.
+func register(path string, open func() (lookupFunc, error)) (err error) {
+	loading.Lock()
+	defer loading.Unlock()
+
+	names := make([]string, 0)
+	rejected := make([]string, 0)
+	setGuard(func(name string) bool {
+		if _, err := load(name); err == nil {
+			rejected = append(rejected, name)
+			return false
+		}
+		names = append(names, name)
+		return true
+	})
+	defer func() {
+		setGuard(nil)
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("plugin panicked in %s. file: %s panic: %v", RegisterSymbol, path, r))
+		}
+		if err != nil {
+			for _, name := range names {
+				unregister(name)
+			}
+		}
+	}()
+
+	lookup, err := open()
+	if err != nil {
+		return err
+	}
+	sym, err := lookup(VersionSymbol)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("plugin must export %s. file: %s", VersionSymbol, path))
+	}
+	version, ok := sym.(*string)
+	if !ok {
+		return errors.New(fmt.Sprintf("%s of plugin must be string, got %T. file: %s", VersionSymbol, sym, path))
+	}
+	if *version != APIVersion {
+		return errors.New(fmt.Sprintf("plugin api version is not compatible, rebuild the plugin with plixy %s. file: %s version: %s want: %s",
+			config.Version, path, *version, APIVersion))
+	}
+
+	sym, err = lookup(RegisterSymbol)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("plugin must export %s. file: %s", RegisterSymbol, path))
+	}
+	fn, ok := sym.(func())
+	if !ok {
+		return errors.New(fmt.Sprintf("%s of plugin must be func(), got %T. file: %s", RegisterSymbol, sym, path))
+	}
+
+	fn()
+
+	if len(rejected) > 0 {
+		return errors.New(fmt.Sprintf("plugin cannot replace the registered plugins. file: %s names: %s", path, strings.Join(rejected, ", ")))
+	}
+	if len(names) == 0 {
+		return errors.New(fmt.Sprintf("plugin registers no plugin. file: %s", path))
+	}
+	log.Info("Load plugin", zap.String("file", path), zap.Strings("names", names))
+	return nil
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	goplugin "plugin"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newOpen returns the func that opens the shared object exporting the symbols.
+func newOpen(symbols map[string]goplugin.Symbol) func() (lookupFunc, error) {
+	return func() (lookupFunc, error) {
+		return func(name string) (goplugin.Symbol, error) {
+			if s, ok := symbols[name]; ok {
+				return s, nil
+			}
+			return nil, errors.New("symbol not found")
+		}, nil
+	}
+}
+
+func TestRegister(t *testing.T) {
+	version := APIVersion
+	oldVersion := "0"
+
+	tests := []struct {
+		name    string
+		symbols map[string]goplugin.Symbol
+		wantErr string
+	}{
+		{
+			name: "should be register the plugins",
+			symbols: map[string]goplugin.Symbol{
+				VersionSymbol:  &version,
+				RegisterSymbol: func() { Register("test-loaded", &Plugin{}) },
+			},
+		},
+		{
+			name:    "should be error if the version is not exported",
+			symbols: map[string]goplugin.Symbol{},
+			wantErr: "plugin must export PlixyPluginAPIVersion. file: test.so: symbol not found",
+		},
+		{
+			name:    "should be error if the version is not string",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &[]int{1}},
+			wantErr: "PlixyPluginAPIVersion of plugin must be string, got *[]int. file: test.so",
+		},
+		{
+			name:    "should be error if the version is not compatible",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &oldVersion},
+			wantErr: "plugin api version is not compatible, rebuild the plugin with plixy v0.0.0-dev. file: test.so version: 0 want: 1",
+		},
+		{
+			name:    "should be error if the register func is not func()",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() error { return nil }},
+			wantErr: "PlixyRegister of plugin must be func(), got func() error. file: test.so",
+		},
+		{
+			name:    "should be error if no plugin is registered",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() {}},
+			wantErr: "plugin registers no plugin. file: test.so",
+		},
+		{
+			name:    "should be error if the registered plugin is replaced",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() { Register("test-loaded", &Plugin{}) }},
+			wantErr: "plugin cannot replace the registered plugins. file: test.so names: test-loaded",
+		},
+		{
+			name:    "should be error if the register func panics",
+			symbols: map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() { panic("boom") }},
+			wantErr: "plugin panicked in PlixyRegister. file: test.so panic: boom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := register("test.so", newOpen(tt.symbols))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRegister_replace(t *testing.T) {
+	version := APIVersion
+	builtin := &Plugin{}
+	Register("test-builtin", builtin)
+
+	err := register("test.so", newOpen(map[string]goplugin.Symbol{
+		VersionSymbol: &version,
+		RegisterSymbol: func() {
+			Register("test-new", &Plugin{})
+			Register("test-builtin", &Plugin{})
+		},
+	}))
+
+	assert.EqualError(t, err, "plugin cannot replace the registered plugins. file: test.so names: test-builtin")
+	t.Run("should be keep the registered plugin", func(t *testing.T) {
+		found, err := load("test-builtin")
+		assert.NoError(t, err)
+		assert.True(t, builtin == found)
+	})
+	t.Run("should be remove the plugins registered by the failed shared object", func(t *testing.T) {
+		_, err := load("test-new")
+		assert.Error(t, err)
+	})
+	t.Run("should be register the plugins after the failed shared object", func(t *testing.T) {
+		Register("test-after", &Plugin{})
+		_, err := load("test-after")
+		assert.NoError(t, err)
+	})
+}
+
+func TestRegister_init(t *testing.T) {
+	version := APIVersion
+	builtin := &Plugin{}
+	Register("test-init-builtin", builtin)
+
+	t.Run("should be reject the plugins registered by the init functions", func(t *testing.T) {
+		open := newOpen(map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() {}})
+		err := register("test.so", func() (lookupFunc, error) {
+			Register("test-init-builtin", &Plugin{})
+			return open()
+		})
+
+		assert.EqualError(t, err, "plugin cannot replace the registered plugins. file: test.so names: test-init-builtin")
+		found, err := load("test-init-builtin")
+		assert.NoError(t, err)
+		assert.True(t, builtin == found)
+	})
+
+	t.Run("should be serialize the loads of the shared objects", func(t *testing.T) {
+		errs := make(chan error, 2)
+		for _, name := range []string{"test-init-a", "test-init-b"} {
+			name := name
+			go func() {
+				errs <- register(name+".so", func() (lookupFunc, error) {
+					Register(name, &Plugin{})
+					return newOpen(map[string]goplugin.Symbol{VersionSymbol: &version, RegisterSymbol: func() {}})()
+				})
+			}()
+		}
+		assert.NoError(t, <-errs)
+		assert.NoError(t, <-errs)
+	})
+}
+
+func TestOpenDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plixy-plugin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("should be error if the dir does not exist", func(t *testing.T) {
+		assert.Error(t, OpenDir(filepath.Join(dir, "unknown")))
+	})
+
+	t.Run("should be ignore the files other than shared objects", func(t *testing.T) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("plugins"), 0644))
+		assert.NoError(t, OpenDir(dir))
+	})
+
+	t.Run("should be error if the shared object is invalid", func(t *testing.T) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "invalid.so"), []byte("invalid"), 0644))
+		err := OpenDir(dir)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "could not open plugin")
+	})
+}
@@ -1,7 +1,9 @@
 package plugin
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 
@@ -13,41 +15,216 @@ import (
 	"go.uber.org/zap"
 )
 
+type hooksKeyType int
+
+const hooksContextKey hooksKeyType = iota
+
 type cache struct {
 	validateConfig sync.Map
-	beforeProxy    sync.Map
+	plugins        sync.Map
 }
 
 var registered = &cache{}
 
 type BeforeProxyFunc func(config map[string]interface{}) (func(next http.Handler) http.Handler, error)
 
+// BeforeProxyCloserFunc builds the middleware like BeforeProxyFunc
+// with the closer that releases the resources of the middleware when the router is closed.
+type BeforeProxyCloserFunc func(config map[string]interface{}) (func(next http.Handler) http.Handler, io.Closer, error)
+
+// AfterProxyFunc builds the hook that modifies the response of the upstream.
+type AfterProxyFunc func(def *api.Api, config map[string]interface{}) (AfterProxyHook, error)
+
+// AfterProxyHook is called with the response of the upstream before it is written to the client.
+// The error is passed to the OnError hooks and responded as 502 by default.
+// As the upstream has responded, it is neither counted as a failure of the target nor retried.
+type AfterProxyHook func(res *http.Response) error
+
+// OnErrorFunc builds the hook that handles the error of the request to the upstream.
+type OnErrorFunc func(def *api.Api, config map[string]interface{}) (OnErrorHook, error)
+
+// OnErrorHook is called when the request to the upstream failed.
+// It returns true if it has written the response, otherwise the next hook or the default error response is used.
+type OnErrorHook func(w http.ResponseWriter, r *http.Request, err error) bool
+
 type Plugin struct {
+	Config      ConfigFunc
 	BeforeProxy BeforeProxyFunc
+	// BeforeProxyCloser is used instead of BeforeProxy if it is set.
+	BeforeProxyCloser BeforeProxyCloserFunc
+	AfterProxy        AfterProxyFunc
+	OnError           OnErrorFunc
+}
+
+// Hooks are the response phase hooks of an api.
+// They are called in the reverse order of the plugins, as the response goes back through the middlewares.
+type Hooks struct {
+	AfterProxy []AfterProxyHook
+	OnError    []OnErrorHook
+}
+
+// guard rejects the registration of the name if allow returns false.
+// It is set by the loader while a shared object is opened and registers its plugins.
+var guard struct {
+	sync.RWMutex
+	allow func(name string) bool
+}
+
+func setGuard(allow func(name string) bool) {
+	guard.Lock()
+	defer guard.Unlock()
+	guard.allow = allow
 }
 
 func Register(name string, plg *Plugin) {
+	guard.RLock()
+	allow := guard.allow
+	guard.RUnlock()
+	if allow != nil && !allow(name) {
+		return
+	}
 	log.Debug("Register plugin", zap.String("name", name))
 
-	if plg.BeforeProxy != nil {
-		registered.beforeProxy.Store(name, plg.BeforeProxy)
+	registered.plugins.Store(name, plg)
+	if plg.Config != nil {
+		registered.validateConfig.Store(name, plg.Config)
+	} else {
+		registered.validateConfig.Delete(name)
+	}
+}
+
+func unregister(name string) {
+	registered.plugins.Delete(name)
+	registered.validateConfig.Delete(name)
+}
+
+func load(name string) (*Plugin, error) {
+	value, ok := registered.plugins.Load(name)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("not found plugin. name: %s", name))
 	}
+	return value.(*Plugin), nil
 }
 
-func BuildBeforeProxy(plg []*api.Plugin) ([]func(next http.Handler) http.Handler, error) {
+// loadConfig returns the config schema of the plugin, or nil if the plugin has no schema.
+func loadConfig(name string) (ConfigFunc, error) {
+	if _, err := load(name); err != nil {
+		return nil, err
+	}
+	value, ok := registered.validateConfig.Load(name)
+	if !ok {
+		return nil, nil
+	}
+	return value.(ConfigFunc), nil
+}
+
+// BuildBeforeProxy builds the middlewares of the plugins applied to the api,
+// and the closers to be closed with the router.
+func BuildBeforeProxy(plg []*api.Plugin) ([]func(next http.Handler) http.Handler, []io.Closer, error) {
 	mw := make([]func(next http.Handler) http.Handler, 0)
+	var closers []io.Closer
 	for _, p := range plg {
-		value, ok := registered.beforeProxy.Load(p.Name)
-		if !ok {
-			return nil, errors.New(fmt.Sprintf("not found plugin. name: %s", p.Name))
+		found, err := load(p.Name)
+		if err != nil {
+			_ = Close(closers)
+			return nil, nil, err
+		}
+
+		var h func(next http.Handler) http.Handler
+		switch {
+		case found.BeforeProxyCloser != nil:
+			var c io.Closer
+			h, c, err = found.BeforeProxyCloser(p.Config)
+			if err == nil && c != nil {
+				closers = append(closers, c)
+			}
+		case found.BeforeProxy != nil:
+			h, err = found.BeforeProxy(p.Config)
+		default:
+			continue
 		}
-		f := value.(BeforeProxyFunc)
-		h, err := f(p.Config)
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("failed BeforeProxy plugin. name: %s", p.Name))
+			_ = Close(closers)
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("failed BeforeProxy plugin. name: %s", p.Name))
 		}
 		mw = append(mw, h)
 	}
 
-	return mw, nil
+	return mw, closers, nil
+}
+
+// Close closes all closers and returns the first error.
+func Close(closers []io.Closer) error {
+	var first error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// BuildHooks builds the response phase hooks of the plugins applied to the api.
+// It returns nil if no plugin has the hooks.
+func BuildHooks(def *api.Api, plg []*api.Plugin) (*Hooks, error) {
+	var hooks *Hooks
+	for i := len(plg) - 1; i >= 0; i-- {
+		p := plg[i]
+		found, err := load(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		if found.AfterProxy != nil {
+			h, err := found.AfterProxy(def, p.Config)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed AfterProxy plugin. name: %s", p.Name))
+			}
+			if hooks == nil {
+				hooks = &Hooks{}
+			}
+			hooks.AfterProxy = append(hooks.AfterProxy, h)
+		}
+		if found.OnError != nil {
+			h, err := found.OnError(def, p.Config)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed OnError plugin. name: %s", p.Name))
+			}
+			if hooks == nil {
+				hooks = &Hooks{}
+			}
+			hooks.OnError = append(hooks.OnError, h)
+		}
+	}
+	return hooks, nil
+}
+
+// ModifyResponse calls the AfterProxy hooks and stops at the first error.
+func (h *Hooks) ModifyResponse(res *http.Response) error {
+	for _, hook := range h.AfterProxy {
+		if err := hook(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleError calls the OnError hooks until one of them writes the response.
+func (h *Hooks) HandleError(w http.ResponseWriter, r *http.Request, err error) bool {
+	for _, hook := range h.OnError {
+		if hook(w, r, err) {
+			return true
+		}
+	}
+	return false
+}
+
+func HooksToContext(ctx context.Context, h *Hooks) context.Context {
+	return context.WithValue(ctx, hooksContextKey, h)
+}
+
+func HooksFromContext(ctx context.Context) *Hooks {
+	if h, ok := ctx.Value(hooksContextKey).(*Hooks); ok {
+		return h
+	}
+	return nil
 }
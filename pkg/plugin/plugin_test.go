@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func registerTestPlugin(name string, called *[]string) {
+	Register(name, &Plugin{
+		AfterProxy: func(def *api.Api, config map[string]interface{}) (AfterProxyHook, error) {
+			return func(res *http.Response) error {
+				*called = append(*called, name)
+				return nil
+			}, nil
+		},
+		OnError: func(def *api.Api, config map[string]interface{}) (OnErrorHook, error) {
+			return func(w http.ResponseWriter, r *http.Request, err error) bool {
+				*called = append(*called, name)
+				return config["write"] == true
+			}, nil
+		},
+	})
+}
+
+type testCloser struct {
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestBuildBeforeProxy(t *testing.T) {
+	closer := &testCloser{}
+	Register("test-closer", &Plugin{
+		BeforeProxyCloser: func(config map[string]interface{}) (func(next http.Handler) http.Handler, io.Closer, error) {
+			return func(next http.Handler) http.Handler { return next }, closer, nil
+		},
+	})
+	Register("test-failure", &Plugin{
+		BeforeProxy: func(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+			return nil, errors.New("failure")
+		},
+	})
+
+	t.Run("should be return the closers of the middlewares", func(t *testing.T) {
+		mw, closers, err := BuildBeforeProxy([]*api.Plugin{{Name: "test-closer"}})
+		assert.NoError(t, err)
+		assert.Len(t, mw, 1)
+		assert.Equal(t, []io.Closer{closer}, closers)
+		assert.False(t, closer.closed)
+	})
+
+	t.Run("should be close the built middlewares if the next plugin failed", func(t *testing.T) {
+		_, _, err := BuildBeforeProxy([]*api.Plugin{{Name: "test-closer"}, {Name: "test-failure"}})
+		assert.EqualError(t, err, "failed BeforeProxy plugin. name: test-failure: failure")
+		assert.True(t, closer.closed)
+	})
+}
+
+func TestBuildHooks(t *testing.T) {
+	var called []string
+	registerTestPlugin("test-first", &called)
+	registerTestPlugin("test-second", &called)
+	Register("test-before", &Plugin{})
+
+	t.Run("should be return nil if no plugin has the hooks", func(t *testing.T) {
+		hooks, err := BuildHooks(&api.Api{}, []*api.Plugin{{Name: "test-before"}})
+		assert.NoError(t, err)
+		assert.Nil(t, hooks)
+	})
+
+	t.Run("should be return error if the plugin is not registered", func(t *testing.T) {
+		_, err := BuildHooks(&api.Api{}, []*api.Plugin{{Name: "test-unknown"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("should be call the after proxy hooks in the reverse order", func(t *testing.T) {
+		called = nil
+		hooks, err := BuildHooks(&api.Api{}, []*api.Plugin{{Name: "test-first"}, {Name: "test-second"}})
+		assert.NoError(t, err)
+		assert.NoError(t, hooks.ModifyResponse(&http.Response{}))
+		assert.Equal(t, []string{"test-second", "test-first"}, called)
+	})
+
+	t.Run("should be stop the on error hooks once the response is written", func(t *testing.T) {
+		called = nil
+		hooks, err := BuildHooks(&api.Api{}, []*api.Plugin{
+			{Name: "test-first"},
+			{Name: "test-second", Config: map[string]interface{}{"write": true}},
+		})
+		assert.NoError(t, err)
+		assert.True(t, hooks.HandleError(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), nil))
+		assert.Equal(t, []string{"test-second"}, called)
+	})
+}
@@ -1,14 +1,11 @@
 package rate
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/purini-to/plixy/pkg/api"
 
-	"github.com/asaskevich/govalidator"
-
 	"github.com/throttled/throttled"
 	"github.com/throttled/throttled/store/memstore"
 
@@ -24,6 +21,7 @@ const (
 
 func init() {
 	plugin.Register("rate", &plugin.Plugin{
+		Config:      newConfig,
 		BeforeProxy: BeforeProxy,
 	})
 }
@@ -35,12 +33,16 @@ type Config struct {
 	MaxStoreSize int    `json:"maxStoreSize"`
 }
 
-func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
-	c := &Config{
+func newConfig() interface{} {
+	return &Config{
 		Per:          defaultPer,
 		MaxStoreSize: defaultMaxStoreSize,
 	}
-	err := parseConfig(config, c)
+}
+
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	c := newConfig().(*Config)
+	err := plugin.ParseConfig(config, c)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("cannot parse config by rate plugin"))
 	}
@@ -82,21 +84,3 @@ func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Ha
 		return httpRateLimiter.RateLimit(next)
 	}, nil
 }
-
-func parseConfig(c map[string]interface{}, v interface{}) error {
-	bytes, err := json.Marshal(c)
-	if err != nil {
-		return errors.Wrap(err, "error marshal config by rate plugin")
-	}
-
-	if err = json.Unmarshal(bytes, v); err != nil {
-		return errors.Wrap(err, "error unmarshal config by rate plugin")
-	}
-
-	_, err = govalidator.ValidateStruct(v)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
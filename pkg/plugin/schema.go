@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var (
+	inTag    = regexp.MustCompile(`^in\((.*)\)$`)
+	rangeTag = regexp.MustCompile(`^range\((.*)\|(.*)\)$`)
+)
+
+// Schema is a JSON Schema document.
+type Schema map[string]interface{}
+
+// ConfigSchema returns the JSON Schema of the config of the plugin.
+// It returns nil if the plugin has no config schema.
+func ConfigSchema(name string) (Schema, error) {
+	fn, err := loadConfig(name)
+	if err != nil || fn == nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(fn())
+	s := typeSchema(v.Type())
+	setDefaults(s, v)
+	return s, nil
+}
+
+// Names returns the sorted names of the registered plugins.
+func Names() []string {
+	names := make([]string, 0)
+	registered.plugins.Range(func(key, value interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// DefinitionSchema returns the JSON Schema of the plugins in the api definition
+// so that editors can complete the config of the registered plugins.
+func DefinitionSchema() Schema {
+	definitions := Schema{}
+	items := make([]interface{}, 0)
+	for _, name := range Names() {
+		config := Schema{"type": "object"}
+		if s, _ := ConfigSchema(name); s != nil {
+			definitions[name] = s
+			config = Schema{"$ref": "#/definitions/" + name}
+		}
+		items = append(items, Schema{
+			"type": "object",
+			"properties": Schema{
+				"name":     Schema{"const": name},
+				"config":   config,
+				"disabled": Schema{"type": "boolean"},
+			},
+			"required":             []string{"name"},
+			"additionalProperties": false,
+		})
+	}
+	plugins := Schema{"type": "array", "items": Schema{"oneOf": items}}
+	return Schema{
+		"$schema":     schemaDraft,
+		"definitions": definitions,
+		"type":        "object",
+		"properties": Schema{
+			"plugins":      plugins,
+			"pluginGroups": Schema{"type": "object", "additionalProperties": plugins},
+			"apis": Schema{
+				"type": "array",
+				"items": Schema{"type": "object", "properties": Schema{
+					"pluginGroups": Schema{"type": "array", "items": Schema{"type": "string"}},
+					"plugins":      plugins,
+				}},
+			},
+		},
+	}
+}
+
+func typeSchema(t reflect.Type) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return Schema{}
+	}
+	s := Schema{"type": schemaType(t)}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := Schema{}
+		required := make([]string, 0)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				continue
+			}
+			name := jsonName(f)
+			p := typeSchema(f.Type)
+			for _, v := range strings.Split(f.Tag.Get("valid"), ",") {
+				v = strings.SplitN(v, "~", 2)[0]
+				switch {
+				case v == "required":
+					required = append(required, name)
+				case inTag.MatchString(v):
+					enum := make([]interface{}, 0)
+					for _, e := range strings.Split(inTag.FindStringSubmatch(v)[1], "|") {
+						enum = append(enum, e)
+					}
+					p["enum"] = enum
+				case rangeTag.MatchString(v):
+					m := rangeTag.FindStringSubmatch(v)
+					if min, err := strconv.ParseFloat(m[1], 64); err == nil {
+						p["minimum"] = min
+					}
+					if max, err := strconv.ParseFloat(m[2], 64); err == nil {
+						p["maximum"] = max
+					}
+				}
+			}
+			properties[name] = p
+		}
+		s["properties"] = properties
+		s["additionalProperties"] = false
+		if len(required) > 0 {
+			s["required"] = required
+		}
+	case reflect.Slice, reflect.Array:
+		s["items"] = typeSchema(t.Elem())
+	case reflect.Map:
+		s["additionalProperties"] = typeSchema(t.Elem())
+	}
+	return s
+}
+
+// setDefaults sets the non zero values of the config struct as the default values.
+func setDefaults(s Schema, v reflect.Value) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	properties, ok := s["properties"].(Schema)
+	if v.Kind() != reflect.Struct || !ok {
+		return
+	}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		p, ok := properties[jsonName(f)].(Schema)
+		if !ok || v.Field(i).IsZero() {
+			continue
+		}
+		if p["type"] == "object" {
+			setDefaults(p, v.Field(i))
+			continue
+		}
+		p["default"] = v.Field(i).Interface()
+	}
+}
+
+func schemaType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
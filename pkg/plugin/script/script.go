@@ -0,0 +1,166 @@
+// Package script runs a Lua script per request before proxying it.
+//
+// The script reads the request from the global `request` table
+// (method, path, query, headers, vars, client_ip and api)
+// and changes it by the global functions:
+//
+//	set_header(name, value)
+//	add_header(name, value)
+//	remove_header(name)
+//	set_path(path)
+//	reject(status[, body])
+//
+// reject responds instead of proxying the request, and the script should return right after it.
+// The path set by set_path is the request path, so the stripPrefix and rewrite of the upstream are applied to it.
+// Globals assigned by the script are not shared between requests,
+// and the string, table and math libraries are read-only.
+package script
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/httperr"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+const (
+	defaultTimeout  = "50ms"
+	defaultPoolSize = 32
+)
+
+func init() {
+	plugin.Register("script", &plugin.Plugin{
+		Config:      newConfig,
+		BeforeProxy: BeforeProxy,
+	})
+}
+
+type Config struct {
+	// Source is the inline script. Either Source or File is required.
+	Source string `json:"source"`
+	File   string `json:"file"`
+	// Timeout is the execution time limit of the script per request.
+	Timeout string `json:"timeout"`
+	// PoolSize is the max number of idle VMs kept for reuse.
+	PoolSize int `json:"poolSize" valid:"range(1|1024)~must be between 1 and 1024"`
+
+	proto   *lua.FunctionProto
+	timeout time.Duration
+}
+
+func newConfig() interface{} {
+	return &Config{
+		Timeout:  defaultTimeout,
+		PoolSize: defaultPoolSize,
+	}
+}
+
+// Validate compiles the script so that syntax errors are found when the definition is loaded.
+func (c *Config) Validate() error {
+	if (c.Source == "") == (c.File == "") {
+		return &plugin.ConfigError{Message: "either source or file is required"}
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return &plugin.ConfigError{Field: "timeout", Message: err.Error()}
+	}
+	if timeout <= 0 {
+		return &plugin.ConfigError{Field: "timeout", Message: "must be greater than 0"}
+	}
+	c.timeout = timeout
+
+	field, name, source := "source", "<source>", c.Source
+	if c.File != "" {
+		b, err := ioutil.ReadFile(c.File)
+		if err != nil {
+			return &plugin.ConfigError{Field: "file", Message: err.Error()}
+		}
+		field, name, source = "file", c.File, string(b)
+	}
+	if c.proto, err = compile(name, source); err != nil {
+		return &plugin.ConfigError{Field: field, Message: err.Error()}
+	}
+	return nil
+}
+
+func compile(name, source string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// BeforeProxy runs the script and proxies the request unless the script rejects it.
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, errors.Wrap(err, "cannot parse config by script plugin")
+	}
+	p := newPool(c.PoolSize)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			v := p.get()
+			res, err := v.run(r, c.proto, c.timeout)
+			if err != nil {
+				v.close()
+				log.FromContext(r.Context()).Error("Failed script plugin",
+					zap.String("name", api.FromContext(r.Context()).Name), zap.Error(err))
+				httperr.InternalServerError(w, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			p.put(v)
+
+			if res.rejected {
+				w.WriteHeader(res.status)
+				_, _ = w.Write([]byte(res.body))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// result is the outcome of the script for a request.
+type result struct {
+	rejected bool
+	status   int
+	body     string
+}
+
+// run runs the script for the request within the timeout.
+func (v *vm) run(r *http.Request, proto *lua.FunctionProto, timeout time.Duration) (*result, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	v.state.SetContext(ctx)
+	defer v.state.RemoveContext()
+
+	v.req, v.res = r, &result{}
+	defer func() { v.req, v.res = nil, nil }()
+
+	fn := v.state.NewFunctionFromProto(proto)
+	fn.Env = v.newEnv(r)
+
+	v.state.Push(fn)
+	if err := v.state.PCall(0, 0, nil); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New(fmt.Sprintf("script exceeded the time limit. timeout: %s", timeout))
+		}
+		return nil, err
+	}
+	return v.res, nil
+}
@@ -0,0 +1,185 @@
+package script
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+func withRoute(req *http.Request) *http.Request {
+	ctx := log.ToContext(req.Context(), zap.NewNop())
+	ctx = api.ToContext(ctx, &api.Api{Name: "test"})
+	ctx = api.VarsToContext(ctx, map[string]string{"id": "1"})
+	return req.WithContext(ctx)
+}
+
+func serve(t *testing.T, config map[string]interface{}, req *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+	mw, err := BeforeProxy(config)
+	assert.NoError(t, err)
+
+	var proxied *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	mw(next).ServeHTTP(rec, withRoute(req))
+	return rec, proxied
+}
+
+func TestBeforeProxy(t *testing.T) {
+	t.Run("should be read the request", func(t *testing.T) {
+		source := `
+if request.method == "GET" and request.path == "/users/1" and request.query.q == "a"
+  and request.headers["X-Token"] == "t" and request.vars.id == "1"
+  and request.client_ip == "10.0.0.1" and request.api == "test" then
+  set_header("X-Result", "ok")
+end`
+		req := httptest.NewRequest("GET", "/users/1?q=a", nil)
+		req.Header.Set("X-Token", "t")
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec, proxied := serve(t, map[string]interface{}{"source": source}, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "ok", proxied.Header.Get("X-Result"))
+	})
+
+	t.Run("should be modify the headers and the path", func(t *testing.T) {
+		source := `
+set_header("X-User", request.vars.id)
+add_header("X-Tag", "b")
+remove_header("Authorization")
+set_path("/v2" .. request.path)`
+		req := httptest.NewRequest("GET", "/users/1", nil)
+		req.Header.Set("Authorization", "secret")
+		req.Header.Set("X-Tag", "a")
+		_, proxied := serve(t, map[string]interface{}{"source": source}, req)
+
+		assert.Equal(t, "1", proxied.Header.Get("X-User"))
+		assert.Equal(t, []string{"a", "b"}, proxied.Header["X-Tag"])
+		assert.Equal(t, "", proxied.Header.Get("Authorization"))
+		assert.Equal(t, "/v2/users/1", proxied.URL.Path)
+	})
+
+	t.Run("should be reject the request", func(t *testing.T) {
+		source := `
+if request.headers["X-Api-Key"] ~= "secret" then
+  return reject(401, "invalid api key")
+end`
+		rec, proxied := serve(t, map[string]interface{}{"source": source}, httptest.NewRequest("GET", "/", nil))
+
+		assert.Nil(t, proxied)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "invalid api key", rec.Body.String())
+	})
+
+	t.Run("should be not share the globals between requests", func(t *testing.T) {
+		config := map[string]interface{}{"source": `
+if seen then return reject(409) end
+seen = true`, "poolSize": 1}
+		mw, err := BeforeProxy(config)
+		assert.NoError(t, err)
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, withRoute(httptest.NewRequest("GET", "/", nil)))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("should be not share the changes of the libraries between requests", func(t *testing.T) {
+		config := map[string]interface{}{"source": `
+if string.seen or math.seen or table.seen or _G.seen or ("").seen then return reject(409) end
+pcall(function() string.seen = true end)
+pcall(function() math.seen = true end)
+pcall(function() table.seen = true end)
+pcall(function() getmetatable("").seen = true end)
+_G.seen = true
+string = nil`, "poolSize": 1}
+		mw, err := BeforeProxy(config)
+		assert.NoError(t, err)
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, withRoute(httptest.NewRequest("GET", "/", nil)))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("should be error by changing the libraries", func(t *testing.T) {
+		rec, proxied := serve(t, map[string]interface{}{"source": `string.upper = nil`}, httptest.NewRequest("GET", "/", nil))
+
+		assert.Nil(t, proxied)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("should be stop the script exceeded the time limit", func(t *testing.T) {
+		config := map[string]interface{}{"source": `while true do end`, "timeout": "10ms"}
+		rec, proxied := serve(t, config, httptest.NewRequest("GET", "/", nil))
+
+		assert.Nil(t, proxied)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("should be error by the runtime error", func(t *testing.T) {
+		rec, proxied := serve(t, map[string]interface{}{"source": `set_path("relative")`}, httptest.NewRequest("GET", "/", nil))
+
+		assert.Nil(t, proxied)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("should be not open the unsafe functions", func(t *testing.T) {
+		source := `
+if dofile or loadfile or load or require or os or io or getfenv or setfenv
+  or rawget or rawset or setmetatable or print or getmetatable("") then
+  return reject(500)
+end
+if string.upper("a") ~= "A" or ("a"):upper() ~= "A" or math.max(1, 2) ~= 2 then return reject(500) end`
+		rec, _ := serve(t, map[string]interface{}{"source": source}, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	f, err := ioutil.TempFile("", "plixy-script-*.lua")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString(`set_header("X-File", "1")`)
+	_ = f.Close()
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr string
+	}{
+		{name: "should be compile the source", config: &Config{Source: `return`, Timeout: "1s"}},
+		{name: "should be compile the file", config: &Config{File: f.Name(), Timeout: "1s"}},
+		{name: "should be error if no script", config: &Config{Timeout: "1s"}, wantErr: "either source or file is required"},
+		{name: "should be error if both scripts", config: &Config{Source: `return`, File: f.Name(), Timeout: "1s"}, wantErr: "either source or file is required"},
+		{name: "should be error by the invalid timeout", config: &Config{Source: `return`, Timeout: "0s"}, wantErr: "timeout: must be greater than 0"},
+		{name: "should be error by the syntax error", config: &Config{Source: `if then`, Timeout: "1s"}, wantErr: "source: <source>"},
+		{name: "should be error if the file is not found", config: &Config{File: "notfound.lua", Timeout: "1s"}, wantErr: "file: open notfound.lua"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.NotNil(t, tt.config.proto)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
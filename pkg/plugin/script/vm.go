@@ -0,0 +1,203 @@
+package script
+
+import (
+	"net"
+	"net/http"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// pool keeps the idle VMs up to its capacity.
+type pool struct {
+	idle chan *vm
+}
+
+func newPool(size int) *pool {
+	return &pool{idle: make(chan *vm, size)}
+}
+
+func (p *pool) get() *vm {
+	select {
+	case v := <-p.idle:
+		return v
+	default:
+		return newVM()
+	}
+}
+
+func (p *pool) put(v *vm) {
+	select {
+	case p.idle <- v:
+	default:
+		v.close()
+	}
+}
+
+// vm is a sandboxed Lua state with the functions to change the request.
+// The functions act on the request currently run by the vm.
+type vm struct {
+	state *lua.LState
+	// global is the metatable of the per request environment to look up the globals.
+	global *lua.LTable
+	// libs are the metatables of the read-only proxies of the library tables.
+	libs map[string]*lua.LTable
+
+	req *http.Request
+	res *result
+}
+
+func newVM() *vm {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{
+		"dofile", "loadfile", "load", "loadstring", "module", "require",
+		"getfenv", "setfenv", "rawget", "rawset", "setmetatable", "print", "_printregs",
+	} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	v := &vm{state: L, libs: make(map[string]*lua.LTable)}
+	readOnly := L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("cannot modify the library table")
+		return 0
+	})
+	for _, name := range []string{lua.TabLibName, lua.StringLibName, lua.MathLibName} {
+		mt := L.NewTable()
+		mt.RawSetString("__index", L.GetGlobal(name))
+		mt.RawSetString("__newindex", readOnly)
+		mt.RawSetString("__metatable", lua.LFalse)
+		v.libs[name] = mt
+	}
+	// the metatable of the strings looks up the string library
+	if mt, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		mt.RawSetString("__metatable", lua.LFalse)
+	}
+
+	L.SetFuncs(L.Get(lua.GlobalsIndex).(*lua.LTable), map[string]lua.LGFunction{
+		"set_header":    v.setHeader,
+		"add_header":    v.addHeader,
+		"remove_header": v.removeHeader,
+		"set_path":      v.setPath,
+		"reject":        v.reject,
+	})
+	v.global = L.NewTable()
+	v.global.RawSetString("__index", L.Get(lua.GlobalsIndex))
+	v.global.RawSetString("__metatable", lua.LFalse)
+	return v
+}
+
+// newEnv creates the environment of a run, which has the request
+// and the read-only proxies of the library tables not to change the globals shared between runs.
+func (v *vm) newEnv(r *http.Request) *lua.LTable {
+	L := v.state
+	env := L.NewTable()
+	L.SetMetatable(env, v.global)
+	env.RawSetString("_G", env)
+	for name, mt := range v.libs {
+		lib := L.NewTable()
+		L.SetMetatable(lib, mt)
+		env.RawSetString(name, lib)
+	}
+	env.RawSetString("request", v.newRequest(r))
+	return env
+}
+
+func (v *vm) close() {
+	v.state.Close()
+}
+
+// newRequest creates the `request` table of the script.
+func (v *vm) newRequest(r *http.Request) *lua.LTable {
+	L := v.state
+	t := L.NewTable()
+	t.RawSetString("method", lua.LString(r.Method))
+	t.RawSetString("path", lua.LString(r.URL.Path))
+	t.RawSetString("api", lua.LString(api.FromContext(r.Context()).Name))
+	t.RawSetString("client_ip", lua.LString(clientIP(r)))
+
+	headers := L.CreateTable(0, len(r.Header))
+	for k, vs := range r.Header {
+		if len(vs) > 0 {
+			headers.RawSetString(k, lua.LString(vs[0]))
+		}
+	}
+	t.RawSetString("headers", headers)
+
+	query := r.URL.Query()
+	q := L.CreateTable(0, len(query))
+	for k, vs := range query {
+		if len(vs) > 0 {
+			q.RawSetString(k, lua.LString(vs[0]))
+		}
+	}
+	t.RawSetString("query", q)
+
+	vars := api.VarsFromContext(r.Context())
+	vt := L.CreateTable(0, len(vars))
+	for k, val := range vars {
+		vt.RawSetString(k, lua.LString(val))
+	}
+	t.RawSetString("vars", vt)
+	return t
+}
+
+// clientIP returns the host of the remote address, which is the real ip of the client set by the middleware.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (v *vm) setHeader(L *lua.LState) int {
+	v.req.Header.Set(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) addHeader(L *lua.LState) int {
+	v.req.Header.Add(L.CheckString(1), L.CheckString(2))
+	return 0
+}
+
+func (v *vm) removeHeader(L *lua.LState) int {
+	v.req.Header.Del(L.CheckString(1))
+	return 0
+}
+
+func (v *vm) setPath(L *lua.LState) int {
+	path := L.CheckString(1)
+	if len(path) == 0 || path[0] != '/' {
+		L.ArgError(1, "path must start with /")
+	}
+	v.req.URL.Path = path
+	v.req.URL.RawPath = ""
+	return 0
+}
+
+// reject responds with the status and the body instead of proxying the request.
+// The script goes on until it returns, so it is usually called as `return reject(401)`.
+func (v *vm) reject(L *lua.LState) int {
+	status := L.CheckInt(1)
+	if status < 100 || status > 599 {
+		L.ArgError(1, "status must be between 100 and 599")
+	}
+	v.res.rejected = true
+	v.res.status = status
+	v.res.body = L.OptString(2, http.StatusText(status))
+	return 0
+}
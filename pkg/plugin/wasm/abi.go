@@ -0,0 +1,528 @@
+package wasm
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	wasmapi "github.com/tetratelabs/wazero/api"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+// The values of the proxy-wasm ABI 0.2.
+const (
+	statusOk                  = 0
+	statusNotFound            = 1
+	statusBadArgument         = 2
+	statusSerializationFailed = 3
+	statusInvalidMemoryAccess = 6
+	statusInternalFailure     = 10
+	statusUnimplemented       = 12
+
+	mapRequestHeaders  = 0
+	mapResponseHeaders = 2
+
+	bufferRequestBody         = 0
+	bufferResponseBody        = 1
+	bufferVMConfiguration     = 6
+	bufferPluginConfiguration = 7
+
+	actionContinue = 0
+)
+
+const hostModuleName = "env"
+
+var i32 = wasmapi.ValueTypeI32
+
+// hostFunc is a function of the host called by the module with the stream of the call.
+// It returns the status of the ABI.
+type hostFunc func(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32
+
+// hostFuncs are the functions of the ABI by the name and the number of i32 parameters.
+var hostFuncs = map[string]struct {
+	params int
+	fn     hostFunc
+}{
+	"proxy_log":                          {3, proxyLog},
+	"proxy_get_log_level":                {1, proxyGetLogLevel},
+	"proxy_get_current_time_nanoseconds": {1, proxyGetCurrentTime},
+	"proxy_set_effective_context":        {1, noop},
+	"proxy_done":                         {0, noop},
+	"proxy_get_header_map_pairs":         {3, proxyGetHeaderMapPairs},
+	"proxy_set_header_map_pairs":         {3, proxySetHeaderMapPairs},
+	"proxy_get_header_map_value":         {5, proxyGetHeaderMapValue},
+	"proxy_add_header_map_value":         {5, proxyAddHeaderMapValue},
+	"proxy_replace_header_map_value":     {5, proxyReplaceHeaderMapValue},
+	"proxy_remove_header_map_value":      {3, proxyRemoveHeaderMapValue},
+	"proxy_get_buffer_bytes":             {5, proxyGetBufferBytes},
+	"proxy_set_buffer_bytes":             {5, proxySetBufferBytes},
+	"proxy_send_local_response":          {8, proxySendLocalResponse},
+	"proxy_get_property":                 {4, proxyGetProperty},
+
+	// the streams are not paused and the others are not supported.
+	"proxy_continue_stream":              {1, unimplemented},
+	"proxy_close_stream":                 {1, unimplemented},
+	"proxy_set_tick_period_milliseconds": {1, unimplemented},
+	"proxy_set_property":                 {4, unimplemented},
+	"proxy_get_shared_data":              {5, unimplemented},
+	"proxy_set_shared_data":              {5, unimplemented},
+	"proxy_http_call":                    {10, unimplemented},
+	"proxy_define_metric":                {4, unimplemented},
+	"proxy_get_metric":                   {2, unimplemented},
+}
+
+// instantiateHost instantiates the functions of the ABI in the runtime.
+func instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	b := r.NewHostModuleBuilder(hostModuleName)
+	for name, f := range hostFuncs {
+		fn := f.fn
+		params := make([]wasmapi.ValueType, f.params)
+		for i := range params {
+			params[i] = i32
+		}
+		b.NewFunctionBuilder().WithGoModuleFunction(wasmapi.GoModuleFunc(func(ctx context.Context, m wasmapi.Module, stack []uint64) {
+			s := streamFromContext(ctx)
+			if s == nil {
+				stack[0] = statusInternalFailure
+				return
+			}
+			stack[0] = uint64(fn(ctx, s, m.Memory(), stack))
+		}), params, []wasmapi.ValueType{i32}).Export(name)
+	}
+	_, err := b.Instantiate(ctx)
+	return err
+}
+
+func noop(context.Context, *stream, wasmapi.Memory, []uint64) uint32 {
+	return statusOk
+}
+
+func unimplemented(context.Context, *stream, wasmapi.Memory, []uint64) uint32 {
+	return statusUnimplemented
+}
+
+func proxyLog(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	msg, ok := mem.Read(uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	// trace and debug are debug, and error and critical are error.
+	level := zapcore.Level(int(args[0]) - 2)
+	if level < zapcore.DebugLevel {
+		level = zapcore.DebugLevel
+	} else if level > zapcore.ErrorLevel {
+		level = zapcore.ErrorLevel
+	}
+	if ce := s.logger().Check(level, string(msg)); ce != nil {
+		ce.Write(zap.String("file", s.filter.file))
+	}
+	return statusOk
+}
+
+func proxyGetLogLevel(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	level := uint32(1)
+	if !s.logger().Core().Enabled(zapcore.DebugLevel) {
+		level = 2
+	}
+	if !mem.WriteUint32Le(uint32(args[0]), level) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOk
+}
+
+func proxyGetCurrentTime(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	if !mem.WriteUint64Le(uint32(args[0]), uint64(time.Now().UnixNano())) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOk
+}
+
+func proxyGetHeaderMapPairs(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	pairs, st := s.headerPairs(uint32(args[0]))
+	if st != statusOk {
+		return st
+	}
+	return s.returnBytes(ctx, mem, encodePairs(pairs), uint32(args[1]), uint32(args[2]))
+}
+
+func proxySetHeaderMapPairs(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	b, ok := mem.Read(uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	pairs, ok := decodePairs(b)
+	if !ok {
+		return statusSerializationFailed
+	}
+	h := s.header(uint32(args[0]))
+	if h == nil {
+		return statusNotFound
+	}
+	for k := range h {
+		delete(h, k)
+	}
+	// the pseudo headers which cannot be set like :scheme are ignored.
+	for _, p := range pairs {
+		if st := s.setHeader(uint32(args[0]), p[0], p[1], true); st != statusOk && !strings.HasPrefix(p[0], ":") {
+			return st
+		}
+	}
+	return statusOk
+}
+
+func proxyGetHeaderMapValue(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	key, ok := mem.Read(uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	v, st := s.getHeader(uint32(args[0]), string(key))
+	if st != statusOk {
+		return st
+	}
+	return s.returnBytes(ctx, mem, []byte(v), uint32(args[3]), uint32(args[4]))
+}
+
+func proxyAddHeaderMapValue(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	return writeHeader(s, mem, args, true)
+}
+
+func proxyReplaceHeaderMapValue(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	return writeHeader(s, mem, args, false)
+}
+
+func writeHeader(s *stream, mem wasmapi.Memory, args []uint64, add bool) uint32 {
+	key, ok := mem.Read(uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	value, ok := mem.Read(uint32(args[3]), uint32(args[4]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	return s.setHeader(uint32(args[0]), string(key), string(value), add)
+}
+
+func proxyRemoveHeaderMapValue(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	key, ok := mem.Read(uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	h := s.header(uint32(args[0]))
+	if h == nil {
+		return statusNotFound
+	}
+	h.Del(string(key))
+	return statusOk
+}
+
+func proxyGetBufferBytes(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	buf := s.buffer(uint32(args[0]))
+	if buf == nil {
+		return statusNotFound
+	}
+	start, size := uint32(args[1]), uint32(args[2])
+	if start > uint32(len(*buf)) {
+		return statusBadArgument
+	}
+	end := uint32(len(*buf))
+	if size < end-start {
+		end = start + size
+	}
+	return s.returnBytes(ctx, mem, (*buf)[start:end], uint32(args[3]), uint32(args[4]))
+}
+
+// proxySetBufferBytes replaces the bytes of the buffer in the range of start and size with the data.
+func proxySetBufferBytes(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	buf := s.buffer(uint32(args[0]))
+	if buf == nil || uint32(args[0]) > bufferResponseBody {
+		return statusNotFound
+	}
+	data, ok := mem.Read(uint32(args[3]), uint32(args[4]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	start, size := uint32(args[1]), uint32(args[2])
+	if start > uint32(len(*buf)) {
+		return statusBadArgument
+	}
+	end := uint32(len(*buf))
+	if size < end-start {
+		end = start + size
+	}
+	b := make([]byte, 0, len(*buf)-int(end-start)+len(data))
+	b = append(b, (*buf)[:start]...)
+	b = append(b, data...)
+	*buf = append(b, (*buf)[end:]...)
+	return statusOk
+}
+
+func proxySendLocalResponse(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	status := int(uint32(args[0]))
+	if status < 100 || status > 599 {
+		return statusBadArgument
+	}
+	body, ok := mem.Read(uint32(args[3]), uint32(args[4]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	b, ok := mem.Read(uint32(args[5]), uint32(args[6]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	pairs, ok := decodePairs(b)
+	if !ok {
+		return statusSerializationFailed
+	}
+	local := &localResponse{status: status, header: make(http.Header), body: append([]byte(nil), body...)}
+	for _, p := range pairs {
+		local.header.Add(p[0], p[1])
+	}
+	s.local = local
+	return statusOk
+}
+
+// proxyGetProperty returns the properties of the request and plixy by the path separated by NUL,
+// like `request.path` and `plixy.vars.<name>`.
+func proxyGetProperty(ctx context.Context, s *stream, mem wasmapi.Memory, args []uint64) uint32 {
+	b, ok := mem.Read(uint32(args[0]), uint32(args[1]))
+	if !ok {
+		return statusInvalidMemoryAccess
+	}
+	if s.req == nil {
+		return statusNotFound
+	}
+	r := s.req
+	var v string
+	switch path := strings.Split(strings.TrimRight(string(b), "\x00"), "\x00"); strings.Join(path, ".") {
+	case "request.path":
+		v = r.URL.RequestURI()
+	case "request.url_path":
+		v = r.URL.Path
+	case "request.query":
+		v = r.URL.RawQuery
+	case "request.host":
+		v = r.Host
+	case "request.method":
+		v = r.Method
+	case "request.scheme":
+		v = scheme(r)
+	case "source.address":
+		v = r.RemoteAddr
+	case "plixy.api":
+		v = api.FromContext(r.Context()).Name
+	default:
+		if len(path) != 3 || path[0] != "plixy" || path[1] != "vars" {
+			return statusNotFound
+		}
+		if v, ok = api.VarsFromContext(r.Context())[path[2]]; !ok {
+			return statusNotFound
+		}
+	}
+	return s.returnBytes(ctx, mem, []byte(v), uint32(args[2]), uint32(args[3]))
+}
+
+// returnBytes writes the data to the memory allocated by the module,
+// and writes the pointer and the size of it to the memory at ptrPtr and sizePtr.
+func (s *stream) returnBytes(ctx context.Context, mem wasmapi.Memory, data []byte, ptrPtr, sizePtr uint32) uint32 {
+	if s.inst == nil || s.inst.malloc == nil {
+		return statusInternalFailure
+	}
+	var ptr uint32
+	if len(data) > 0 {
+		res, err := s.inst.malloc.Call(ctx, uint64(len(data)))
+		if err != nil || len(res) == 0 {
+			return statusInternalFailure
+		}
+		ptr = uint32(res[0])
+		if !mem.Write(ptr, data) {
+			return statusInvalidMemoryAccess
+		}
+	}
+	if !mem.WriteUint32Le(ptrPtr, ptr) || !mem.WriteUint32Le(sizePtr, uint32(len(data))) {
+		return statusInvalidMemoryAccess
+	}
+	return statusOk
+}
+
+// header returns the header of the map type, or nil if the stream has not it.
+func (s *stream) header(mapType uint32) http.Header {
+	switch {
+	case mapType == mapRequestHeaders && s.req != nil:
+		return s.req.Header
+	case mapType == mapResponseHeaders && s.res != nil:
+		return s.res.Header
+	}
+	return nil
+}
+
+// headerPairs returns the pairs of the header in lower case with the pseudo headers.
+func (s *stream) headerPairs(mapType uint32) ([][2]string, uint32) {
+	h := s.header(mapType)
+	if h == nil {
+		return nil, statusNotFound
+	}
+	pairs := make([][2]string, 0, len(h)+4)
+	switch mapType {
+	case mapRequestHeaders:
+		pairs = append(pairs,
+			[2]string{":method", s.req.Method},
+			[2]string{":path", s.req.URL.RequestURI()},
+			[2]string{":authority", s.req.Host},
+			[2]string{":scheme", scheme(s.req)},
+		)
+	case mapResponseHeaders:
+		pairs = append(pairs, [2]string{":status", strconv.Itoa(s.res.StatusCode)})
+	}
+	for k, vs := range h {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{strings.ToLower(k), v})
+		}
+	}
+	return pairs, statusOk
+}
+
+func (s *stream) getHeader(mapType uint32, key string) (string, uint32) {
+	if strings.HasPrefix(key, ":") {
+		pairs, st := s.headerPairs(mapType)
+		if st != statusOk {
+			return "", st
+		}
+		for _, p := range pairs {
+			if p[0] == key {
+				return p[1], statusOk
+			}
+		}
+		return "", statusNotFound
+	}
+	h := s.header(mapType)
+	if h == nil {
+		return "", statusNotFound
+	}
+	vs, ok := h[http.CanonicalHeaderKey(key)]
+	if !ok || len(vs) == 0 {
+		return "", statusNotFound
+	}
+	return vs[0], statusOk
+}
+
+// setHeader sets or adds the value of the header.
+// The pseudo headers of :method, :path and :authority of the request and :status of the response are replaced.
+func (s *stream) setHeader(mapType uint32, key, value string, add bool) uint32 {
+	h := s.header(mapType)
+	if h == nil {
+		return statusNotFound
+	}
+	switch {
+	case mapType == mapRequestHeaders && key == ":method":
+		s.req.Method = value
+	case mapType == mapRequestHeaders && key == ":path":
+		u, err := url.ParseRequestURI(value)
+		if err != nil {
+			return statusBadArgument
+		}
+		s.req.URL.Path, s.req.URL.RawPath, s.req.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	case mapType == mapRequestHeaders && key == ":authority":
+		s.req.Host = value
+	case mapType == mapResponseHeaders && key == ":status":
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			return statusBadArgument
+		}
+		s.res.StatusCode = status
+		s.res.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	case strings.HasPrefix(key, ":"):
+		return statusBadArgument
+	case add:
+		h.Add(key, value)
+	default:
+		h.Set(key, value)
+	}
+	return statusOk
+}
+
+// buffer returns the buffer of the type, or nil if the stream has not it.
+func (s *stream) buffer(bufferType uint32) *[]byte {
+	switch bufferType {
+	case bufferRequestBody:
+		return s.reqBody
+	case bufferResponseBody:
+		return s.resBody
+	case bufferVMConfiguration:
+		return &[]byte{}
+	case bufferPluginConfiguration:
+		b := s.filter.configuration
+		return &b
+	}
+	return nil
+}
+
+func (s *stream) logger() *zap.Logger {
+	if s.req != nil {
+		return log.FromContext(s.req.Context())
+	}
+	return log.GetLogger()
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// encodePairs serializes the pairs by the number of pairs, the sizes of each key and value,
+// and the keys and values terminated by NUL.
+func encodePairs(pairs [][2]string) []byte {
+	size := 4
+	for _, p := range pairs {
+		size += 8 + len(p[0]) + len(p[1]) + 2
+	}
+	b := make([]byte, 4+8*len(pairs), size)
+	binary.LittleEndian.PutUint32(b, uint32(len(pairs)))
+	for i, p := range pairs {
+		binary.LittleEndian.PutUint32(b[4+8*i:], uint32(len(p[0])))
+		binary.LittleEndian.PutUint32(b[8+8*i:], uint32(len(p[1])))
+	}
+	for _, p := range pairs {
+		b = append(b, p[0]...)
+		b = append(b, 0)
+		b = append(b, p[1]...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+func decodePairs(b []byte) ([][2]string, bool) {
+	if len(b) == 0 {
+		return nil, true
+	}
+	if len(b) < 4 {
+		return nil, false
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n > (len(b)-4)/8 {
+		return nil, false
+	}
+	pairs := make([][2]string, n)
+	data := b[4+8*n:]
+	for i := 0; i < n; i++ {
+		for j := 0; j < 2; j++ {
+			size := int(binary.LittleEndian.Uint32(b[4+8*i+4*j:]))
+			if size+1 > len(data) || data[size] != 0 {
+				return nil, false
+			}
+			pairs[i][j] = string(data[:size])
+			data = data[size+1:]
+		}
+	}
+	return pairs, true
+}
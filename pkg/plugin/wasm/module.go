@@ -0,0 +1,386 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	wasmapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.uber.org/zap"
+)
+
+const (
+	rootContextID      = 1
+	instantiateTimeout = 5 * time.Second
+)
+
+var errBodyTooLarge = errors.New("body is too large")
+
+// filter is the compiled module and the pool of the instances of it.
+type filter struct {
+	file          string
+	configuration []byte
+	timeout       time.Duration
+	maxBodySize   int64
+
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	idle     chan *instance
+
+	mu sync.Mutex
+	// inUse is the number of the instances got and not put back yet.
+	inUse  int
+	closed bool
+}
+
+func newFilter(c *Config) (*filter, error) {
+	ctx := context.Background()
+	runtime := newRuntime(ctx, c.MaxMemoryPages)
+	f, err := compileFilter(ctx, runtime, c)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	return f, nil
+}
+
+func compileFilter(ctx context.Context, runtime wazero.Runtime, c *Config) (*filter, error) {
+	compiled, err := runtime.CompileModule(ctx, c.module)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not compile wasm module. file: %s", c.File))
+	}
+	if err := instantiateHost(ctx, runtime); err != nil {
+		return nil, errors.Wrap(err, "could not instantiate host functions")
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, errors.Wrap(err, "could not instantiate wasi")
+	}
+
+	f := &filter{
+		file:          c.File,
+		configuration: []byte(c.Configuration),
+		timeout:       c.timeout,
+		maxBodySize:   c.MaxBodySize,
+		runtime:       runtime,
+		compiled:      compiled,
+		idle:          make(chan *instance, c.PoolSize),
+	}
+	// the first instance finds the errors of the start and the configuration of the module.
+	inst, err := f.newInstance()
+	if err != nil {
+		return nil, err
+	}
+	f.idle <- inst
+	return f, nil
+}
+
+func (f *filter) get() (*instance, error) {
+	f.mu.Lock()
+	f.inUse++
+	f.mu.Unlock()
+	select {
+	case inst := <-f.idle:
+		return inst, nil
+	default:
+	}
+	inst, err := f.newInstance()
+	if err != nil {
+		f.release()
+		return nil, err
+	}
+	return inst, nil
+}
+
+// put puts the instance back to the pool, or closes it if the pool is full or the filter is closed.
+func (f *filter) put(inst *instance) {
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if !closed {
+		select {
+		case f.idle <- inst:
+			f.release()
+			return
+		default:
+		}
+	}
+	f.discard(inst)
+}
+
+// discard closes the instance that cannot be reused.
+func (f *filter) discard(inst *instance) {
+	inst.close()
+	f.release()
+}
+
+func (f *filter) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inUse--
+	if f.closed && f.inUse == 0 {
+		_ = f.runtime.Close(context.Background())
+	}
+}
+
+// Close closes the runtime with all instances of the module.
+// The runtime is closed when the last instance in use is put back if any.
+func (f *filter) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.inUse > 0 {
+		return nil
+	}
+	return f.runtime.Close(context.Background())
+}
+
+// instance is an instance of the module with the root context configured.
+// It handles a stream at a time.
+type instance struct {
+	mod    wasmapi.Module
+	malloc wasmapi.Function
+	lastID uint32
+}
+
+func (f *filter) newInstance() (*instance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), instantiateTimeout)
+	defer cancel()
+	root := &stream{id: rootContextID, filter: f}
+	ctx = streamToContext(ctx, root)
+
+	mod, err := f.runtime.InstantiateModule(ctx, f.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize", "_start"))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not instantiate wasm module. file: %s", f.file))
+	}
+	inst := &instance{mod: mod, malloc: exportedMalloc(mod), lastID: rootContextID}
+	root.inst = inst
+
+	if _, _, err = inst.call(ctx, "proxy_on_context_create", rootContextID, 0); err == nil {
+		var res uint64
+		var ok bool
+		if res, ok, err = inst.call(ctx, "proxy_on_vm_start", rootContextID, 0); err == nil && ok && res == 0 {
+			err = errors.New("proxy_on_vm_start returned false")
+		}
+		if err == nil {
+			if res, ok, err = inst.call(ctx, "proxy_on_configure", rootContextID, uint64(len(f.configuration))); err == nil && ok && res == 0 {
+				err = errors.New("proxy_on_configure returned false")
+			}
+		}
+	}
+	if err != nil {
+		inst.close()
+		return nil, errors.Wrap(err, fmt.Sprintf("could not start wasm module. file: %s", f.file))
+	}
+	return inst, nil
+}
+
+func exportedMalloc(mod wasmapi.Module) wasmapi.Function {
+	if fn := mod.ExportedFunction("proxy_on_memory_allocate"); fn != nil {
+		return fn
+	}
+	return mod.ExportedFunction("malloc")
+}
+
+// call calls the exported function and reports whether the module exports it.
+func (i *instance) call(ctx context.Context, name string, params ...uint64) (uint64, bool, error) {
+	fn := i.mod.ExportedFunction(name)
+	if fn == nil {
+		return 0, false, nil
+	}
+	res, err := fn.Call(ctx, params...)
+	if err != nil {
+		return 0, true, errors.Wrap(err, fmt.Sprintf("failed %s", name))
+	}
+	if len(res) == 0 {
+		return 0, true, nil
+	}
+	return res[0], true, nil
+}
+
+func (i *instance) exports(name string) bool {
+	return i.mod.ExportedFunction(name) != nil
+}
+
+func (i *instance) close() {
+	_ = i.mod.Close(context.Background())
+}
+
+// stream is the http context of the module for a request.
+type stream struct {
+	id     uint32
+	filter *filter
+	inst   *instance
+
+	req *http.Request
+	res *http.Response
+	// reqBody and resBody are the body buffers while the body phases.
+	reqBody *[]byte
+	resBody *[]byte
+	local   *localResponse
+	// broken is true if the call to the instance failed and the instance cannot be reused.
+	broken bool
+}
+
+// localResponse is the response sent by the module instead of the upstream.
+type localResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (f *filter) newStream(inst *instance, r *http.Request) *stream {
+	inst.lastID++
+	return &stream{id: inst.lastID, filter: f, inst: inst, req: r}
+}
+
+func (s *stream) call(name string, params ...uint64) (uint64, bool, error) {
+	ctx, cancel := context.WithTimeout(s.req.Context(), s.filter.timeout)
+	defer cancel()
+	res, ok, err := s.inst.call(streamToContext(ctx, s), name, params...)
+	if err != nil {
+		s.broken = true
+		if ctx.Err() == context.DeadlineExceeded {
+			return 0, true, errors.New(fmt.Sprintf("%s exceeded the time limit. timeout: %s", name, s.filter.timeout))
+		}
+	}
+	return res, ok, err
+}
+
+// onRequest calls the request phases, and the request is modified or the local response is set.
+func (s *stream) onRequest() error {
+	r := s.req
+	if _, _, err := s.call("proxy_on_context_create", uint64(s.id), rootContextID); err != nil {
+		return err
+	}
+
+	hasBody := r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+	pairs, _ := s.headerPairs(mapRequestHeaders)
+	if err := s.callPhase("proxy_on_request_headers", uint64(len(pairs)), !hasBody); err != nil || s.local != nil {
+		return err
+	}
+
+	if !hasBody || !s.inst.exports("proxy_on_request_body") {
+		return nil
+	}
+	body, err := readBody(r.Body, s.filter.maxBodySize)
+	if err != nil {
+		return err
+	}
+	s.reqBody = &body
+	defer func() { s.reqBody = nil }()
+	if err := s.callPhase("proxy_on_request_body", uint64(len(body)), true); err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(*s.reqBody))
+	r.ContentLength = int64(len(*s.reqBody))
+	return nil
+}
+
+// onResponse calls the response phases, and the response is modified or replaced by the local response.
+func (s *stream) onResponse(res *http.Response) error {
+	s.res = res
+	defer func() { s.res = nil }()
+
+	hasBody := res.Body != nil && res.Body != http.NoBody && res.ContentLength != 0
+	pairs, _ := s.headerPairs(mapResponseHeaders)
+	if err := s.callPhase("proxy_on_response_headers", uint64(len(pairs)), !hasBody); err != nil {
+		return err
+	}
+	if s.local == nil && hasBody && s.inst.exports("proxy_on_response_body") {
+		body, err := readBody(res.Body, s.filter.maxBodySize)
+		if err != nil {
+			return err
+		}
+		s.resBody = &body
+		defer func() { s.resBody = nil }()
+		if err := s.callPhase("proxy_on_response_body", uint64(len(body)), true); err != nil {
+			return err
+		}
+		setResponseBody(res, *s.resBody)
+	}
+
+	if s.local != nil {
+		res.StatusCode = s.local.status
+		res.Status = strconv.Itoa(s.local.status) + " " + http.StatusText(s.local.status)
+		res.Header = s.local.header
+		setResponseBody(res, s.local.body)
+	}
+	return nil
+}
+
+func (s *stream) callPhase(name string, size uint64, endOfStream bool) error {
+	var eos uint64
+	if endOfStream {
+		eos = 1
+	}
+	action, _, err := s.call(name, uint64(s.id), size, eos)
+	if err != nil {
+		return err
+	}
+	if action != actionContinue && s.local == nil {
+		s.logger().Debug("Continue the stream paused by wasm plugin", zap.String("phase", name))
+	}
+	return nil
+}
+
+// done ends the stream and returns the instance to the pool.
+func (s *stream) done() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.filter.timeout)
+	defer cancel()
+	ctx = streamToContext(ctx, s)
+	if !s.broken {
+		if _, _, err := s.inst.call(ctx, "proxy_on_done", uint64(s.id)); err != nil {
+			s.broken = true
+		}
+	}
+	if !s.broken {
+		if _, _, err := s.inst.call(ctx, "proxy_on_delete", uint64(s.id)); err != nil {
+			s.broken = true
+		}
+	}
+	if s.broken {
+		s.filter.discard(s.inst)
+		return
+	}
+	s.filter.put(s.inst)
+}
+
+func (l *localResponse) write(w http.ResponseWriter) {
+	for k, vs := range l.header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(l.status)
+	_, _ = w.Write(l.body)
+}
+
+func setResponseBody(res *http.Response, body []byte) {
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// readBody reads the body up to max bytes.
+func readBody(body io.ReadCloser, max int64) ([]byte, error) {
+	defer body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, errBodyTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,92 @@
+;; The filter for the tests of the wasm plugin.
+;; filter.wasm is built by `wat2wasm filter.wat -o filter.wasm`.
+(module
+  (import "env" "proxy_get_buffer_bytes" (func $get_buffer_bytes (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_set_buffer_bytes" (func $set_buffer_bytes (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_get_header_map_value" (func $get_header (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_add_header_map_value" (func $add_header (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_replace_header_map_value" (func $replace_header (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_send_local_response" (func $send_local_response (param i32 i32 i32 i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "proxy_get_property" (func $get_property (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+
+  (data (i32.const 16) "x-block")
+  (data (i32.const 32) "blocked")
+  (data (i32.const 48) "x-wasm")
+  (data (i32.const 64) ":path")
+  (data (i32.const 80) "x-rewrite")
+  (data (i32.const 96) "x-loop")
+  (data (i32.const 112) "server")
+  (data (i32.const 128) "wasm")
+  (data (i32.const 144) "x-api")
+  (data (i32.const 160) "plixy\00api")
+  (data (i32.const 176) "filtered")
+  (data (i32.const 192) "x-deny-response")
+  (data (i32.const 208) ":status")
+
+  ;; the pointer and the size returned by the host are written to 512 and 516.
+  (global $heap (mut i32) (i32.const 1024))
+  (global $config_ptr (mut i32) (i32.const 0))
+  (global $config_size (mut i32) (i32.const 0))
+  (global $deny_response (mut i32) (i32.const 0))
+
+  (func (export "proxy_abi_version_0_2_0"))
+
+  (func (export "proxy_on_memory_allocate") (param $size i32) (result i32)
+    (local $ptr i32)
+    (local.set $ptr (global.get $heap))
+    (global.set $heap (i32.add (global.get $heap) (local.get $size)))
+    (local.get $ptr))
+
+  (func (export "proxy_on_context_create") (param i32 i32)
+    (global.set $deny_response (i32.const 0)))
+
+  (func (export "proxy_on_vm_start") (param i32 i32) (result i32)
+    (i32.const 1))
+
+  ;; the plugin configuration is added to the request header x-wasm.
+  (func (export "proxy_on_configure") (param $root i32) (param $size i32) (result i32)
+    (drop (call $get_buffer_bytes (i32.const 7) (i32.const 0) (local.get $size) (i32.const 512) (i32.const 516)))
+    (global.set $config_ptr (i32.load (i32.const 512)))
+    (global.set $config_size (i32.load (i32.const 516)))
+    (i32.const 1))
+
+  (func (export "proxy_on_request_headers") (param $id i32) (param $n i32) (param $eos i32) (result i32)
+    ;; x-loop runs forever.
+    (if (i32.eqz (call $get_header (i32.const 0) (i32.const 96) (i32.const 6) (i32.const 512) (i32.const 516)))
+      (then (loop $forever (br $forever))))
+    ;; x-block responds 403.
+    (if (i32.eqz (call $get_header (i32.const 0) (i32.const 16) (i32.const 7) (i32.const 512) (i32.const 516)))
+      (then
+        (drop (call $send_local_response (i32.const 403) (i32.const 0) (i32.const 0) (i32.const 32) (i32.const 7) (i32.const 0) (i32.const 0) (i32.const -1)))
+        (return (i32.const 1))))
+    ;; x-rewrite replaces the path.
+    (if (i32.eqz (call $get_header (i32.const 0) (i32.const 80) (i32.const 9) (i32.const 512) (i32.const 516)))
+      (then
+        (drop (call $replace_header (i32.const 0) (i32.const 64) (i32.const 5) (i32.load (i32.const 512)) (i32.load (i32.const 516))))))
+    ;; x-deny-response replaces the response by 502.
+    (if (i32.eqz (call $get_header (i32.const 0) (i32.const 192) (i32.const 15) (i32.const 512) (i32.const 516)))
+      (then (global.set $deny_response (i32.const 1))))
+    (drop (call $add_header (i32.const 0) (i32.const 48) (i32.const 6) (global.get $config_ptr) (global.get $config_size)))
+    (if (i32.eqz (call $get_property (i32.const 160) (i32.const 9) (i32.const 512) (i32.const 516)))
+      (then
+        (drop (call $add_header (i32.const 0) (i32.const 144) (i32.const 5) (i32.load (i32.const 512)) (i32.load (i32.const 516))))))
+    (i32.const 0))
+
+  ;; "filtered" is inserted at the head of the request body.
+  (func (export "proxy_on_request_body") (param $id i32) (param $size i32) (param $eos i32) (result i32)
+    (drop (call $set_buffer_bytes (i32.const 0) (i32.const 0) (i32.const 0) (i32.const 176) (i32.const 8)))
+    (i32.const 0))
+
+  (func (export "proxy_on_response_headers") (param $id i32) (param $n i32) (param $eos i32) (result i32)
+    (drop (call $replace_header (i32.const 2) (i32.const 112) (i32.const 6) (i32.const 128) (i32.const 4)))
+    (if (global.get $deny_response)
+      (then
+        (drop (call $send_local_response (i32.const 502) (i32.const 0) (i32.const 0) (i32.const 32) (i32.const 7) (i32.const 0) (i32.const 0) (i32.const -1)))))
+    (i32.const 0))
+
+  ;; the response body is replaced by "filtered".
+  (func (export "proxy_on_response_body") (param $id i32) (param $size i32) (param $eos i32) (result i32)
+    (drop (call $set_buffer_bytes (i32.const 1) (i32.const 0) (local.get $size) (i32.const 176) (i32.const 8)))
+    (i32.const 0))
+)
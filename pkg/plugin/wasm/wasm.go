@@ -0,0 +1,208 @@
+// Package wasm filters the requests and the responses by WebAssembly modules.
+//
+// The modules are run by a pure Go runtime in the sandbox with the time limit of each call and the memory limit.
+// They are called with the proxy-wasm ABI 0.2 of the http context:
+// proxy_on_request_headers, proxy_on_request_body, proxy_on_response_headers and proxy_on_response_body.
+// The body phases are called once with the whole body buffered up to maxBodySize,
+// and the stream paused by the module is continued, as the http calls, the timers and the shared data are not supported.
+package wasm
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/httperr"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/plugin"
+)
+
+const (
+	defaultTimeout        = "100ms"
+	defaultMaxBodySize    = 1 << 20
+	defaultMaxMemoryPages = 256
+	defaultPoolSize       = 32
+)
+
+type contextKeyType int
+
+const (
+	streamContextKey contextKeyType = iota
+	streamsContextKey
+)
+
+func init() {
+	plugin.Register("wasm", &plugin.Plugin{
+		Config:            newConfig,
+		BeforeProxyCloser: BeforeProxy,
+		AfterProxy:        AfterProxy,
+	})
+}
+
+type Config struct {
+	File string `json:"file" valid:"required"`
+	// Configuration is passed to the module by proxy_on_configure.
+	Configuration string `json:"configuration"`
+	// Timeout is the time limit of each call to the module.
+	Timeout     string `json:"timeout"`
+	MaxBodySize int64  `json:"maxBodySize" valid:"range(1|1073741824)~must be between 1 and 1073741824"`
+	// MaxMemoryPages is the memory limit of an instance in pages of 64KiB.
+	MaxMemoryPages int `json:"maxMemoryPages" valid:"range(1|65536)~must be between 1 and 65536"`
+	// PoolSize is the max number of idle instances kept for reuse.
+	PoolSize int `json:"poolSize" valid:"range(1|1024)~must be between 1 and 1024"`
+
+	timeout time.Duration
+	module  []byte
+}
+
+func newConfig() interface{} {
+	return &Config{
+		Timeout:        defaultTimeout,
+		MaxBodySize:    defaultMaxBodySize,
+		MaxMemoryPages: defaultMaxMemoryPages,
+		PoolSize:       defaultPoolSize,
+	}
+}
+
+// Validate compiles the module so that invalid modules are found when the definition is loaded.
+// The runtime of the compilation is closed, as the filter compiles the module in its own runtime.
+func (c *Config) Validate() error {
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return &plugin.ConfigError{Field: "timeout", Message: err.Error()}
+	}
+	if timeout <= 0 {
+		return &plugin.ConfigError{Field: "timeout", Message: "must be greater than 0"}
+	}
+	c.timeout = timeout
+
+	b, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return &plugin.ConfigError{Field: "file", Message: err.Error()}
+	}
+	ctx := context.Background()
+	runtime := newRuntime(ctx, c.MaxMemoryPages)
+	defer runtime.Close(ctx)
+	compiled, err := runtime.CompileModule(ctx, b)
+	if err != nil {
+		return &plugin.ConfigError{Field: "file", Message: err.Error()}
+	}
+
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports["proxy_on_context_create"]; !ok {
+		return &plugin.ConfigError{Field: "file", Message: "proxy_on_context_create is not exported"}
+	}
+	_, ok := exports["proxy_on_memory_allocate"]
+	if _, malloc := exports["malloc"]; !ok && !malloc {
+		return &plugin.ConfigError{Field: "file", Message: "proxy_on_memory_allocate or malloc is not exported"}
+	}
+	for _, fn := range compiled.ImportedFunctions() {
+		module, name, _ := fn.Import()
+		if _, ok := hostFuncs[name]; module == hostModuleName && !ok {
+			return &plugin.ConfigError{Field: "file", Message: "unsupported import: " + module + "." + name}
+		}
+	}
+	c.module = b
+	return nil
+}
+
+func newRuntime(ctx context.Context, maxMemoryPages int) wazero.Runtime {
+	return wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(uint32(maxMemoryPages)))
+}
+
+// BeforeProxy calls the request phases of the module,
+// and proxies the modified request unless the module sends the local response.
+// The closer closes the runtime of the module when the instances in use are put back.
+func BeforeProxy(config map[string]interface{}) (func(next http.Handler) http.Handler, io.Closer, error) {
+	c := newConfig().(*Config)
+	if err := plugin.ParseConfig(config, c); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot parse config by wasm plugin")
+	}
+	f, err := newFilter(c)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not load module by wasm plugin")
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			logger := log.FromContext(r.Context())
+			inst, err := f.get()
+			if err != nil {
+				logger.Error("Could not get wasm instance", zap.String("file", f.file), zap.Error(err))
+				httperr.InternalServerError(w, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			ss, ok := r.Context().Value(streamsContextKey).(*streams)
+			if !ok {
+				ss = &streams{}
+				r = r.WithContext(context.WithValue(r.Context(), streamsContextKey, ss))
+			}
+			s := f.newStream(inst, r)
+			defer s.done()
+
+			if err := s.onRequest(); err != nil {
+				if err == errBodyTooLarge {
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+					return
+				}
+				logger.Error("Failed wasm plugin",
+					zap.String("name", api.FromContext(r.Context()).Name), zap.String("file", f.file), zap.Error(err))
+				httperr.InternalServerError(w, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			if s.local != nil {
+				s.local.write(w)
+				return
+			}
+
+			ss.list = append(ss.list, s)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, f, nil
+}
+
+// AfterProxy calls the response phases of the module on the stream of the request.
+// The config is validated by BeforeProxy.
+func AfterProxy(_ *api.Api, _ map[string]interface{}) (plugin.AfterProxyHook, error) {
+	return func(res *http.Response) error {
+		ss, ok := res.Request.Context().Value(streamsContextKey).(*streams)
+		if !ok || len(ss.list) == 0 {
+			return nil
+		}
+		s := ss.list[len(ss.list)-1]
+		ss.list = ss.list[:len(ss.list)-1]
+		if err := s.onResponse(res); err != nil {
+			return errors.Wrap(err, "failed wasm plugin")
+		}
+		return nil
+	}, nil
+}
+
+// streams are the streams of the wasm plugins applied to the request.
+// They are pushed by the middlewares in the order of the plugins,
+// and popped by the AfterProxy hooks that are called in the reverse order.
+type streams struct {
+	list []*stream
+}
+
+func streamToContext(ctx context.Context, s *stream) context.Context {
+	return context.WithValue(ctx, streamContextKey, s)
+}
+
+func streamFromContext(ctx context.Context) *stream {
+	if s, ok := ctx.Value(streamContextKey).(*stream); ok {
+		return s
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package wasm
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/log"
+)
+
+const testFile = "testdata/filter.wasm"
+
+// upstream proxies the request to the fake upstream and calls the AfterProxy hook like the proxy.
+type upstream struct {
+	req  *http.Request
+	body string
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.req = r
+	b, _ := ioutil.ReadAll(r.Body)
+	u.body = string(b)
+
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Server": {"upstream"}},
+		Body:          ioutil.NopCloser(strings.NewReader("response")),
+		ContentLength: 8,
+		Request:       r,
+	}
+	hook, _ := AfterProxy(nil, nil)
+	if err := hook(res); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	for k, vs := range res.Header {
+		w.Header()[k] = vs
+	}
+	w.WriteHeader(res.StatusCode)
+	b, _ = ioutil.ReadAll(res.Body)
+	_, _ = w.Write(b)
+}
+
+func serve(t *testing.T, config map[string]interface{}, req *http.Request) (*httptest.ResponseRecorder, *upstream) {
+	mw, closer, err := BeforeProxy(config)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer closer.Close()
+	u := &upstream{}
+	ctx := log.ToContext(req.Context(), zap.NewNop())
+	ctx = api.ToContext(ctx, &api.Api{Name: "test"})
+	ctx = api.VarsToContext(ctx, map[string]string{})
+	rec := httptest.NewRecorder()
+	mw(u).ServeHTTP(rec, req.WithContext(ctx))
+	return rec, u
+}
+
+func TestBeforeProxy(t *testing.T) {
+	config := map[string]interface{}{"file": testFile, "configuration": "configured"}
+
+	t.Run("should be filter the request and the response", func(t *testing.T) {
+		rec, u := serve(t, config, httptest.NewRequest("POST", "/users", strings.NewReader("body")))
+
+		assert.Equal(t, "configured", u.req.Header.Get("X-Wasm"))
+		assert.Equal(t, "test", u.req.Header.Get("X-Api"))
+		assert.Equal(t, "filteredbody", u.body)
+		assert.Equal(t, int64(12), u.req.ContentLength)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "wasm", rec.Header().Get("Server"))
+		assert.Equal(t, "8", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "filtered", rec.Body.String())
+	})
+
+	t.Run("should be rewrite the path", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("X-Rewrite", "/members?id=1")
+		_, u := serve(t, config, req)
+
+		assert.Equal(t, "/members", u.req.URL.Path)
+		assert.Equal(t, "id=1", u.req.URL.RawQuery)
+	})
+
+	t.Run("should be respond the local response of the request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("X-Block", "1")
+		rec, u := serve(t, config, req)
+
+		assert.Nil(t, u.req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "blocked", rec.Body.String())
+	})
+
+	t.Run("should be replace the response by the local response", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.Header.Set("X-Deny-Response", "1")
+		rec, u := serve(t, config, req)
+
+		assert.NotNil(t, u.req)
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, "blocked", rec.Body.String())
+	})
+
+	t.Run("should be reject the request body too large", func(t *testing.T) {
+		config := map[string]interface{}{"file": testFile, "maxBodySize": 3}
+		rec, u := serve(t, config, httptest.NewRequest("POST", "/users", strings.NewReader("body")))
+
+		assert.Nil(t, u.req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("should be stop the call exceeded the time limit", func(t *testing.T) {
+		mw, closer, err := BeforeProxy(map[string]interface{}{"file": testFile, "timeout": "10ms", "poolSize": 1})
+		assert.NoError(t, err)
+		defer closer.Close()
+		h := mw(&upstream{})
+		for _, loop := range []bool{true, false} {
+			req := httptest.NewRequest("GET", "/users", nil)
+			if loop {
+				req.Header.Set("X-Loop", "1")
+			}
+			ctx := api.ToContext(log.ToContext(req.Context(), zap.NewNop()), &api.Api{Name: "test"})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req.WithContext(ctx))
+			if loop {
+				assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+		}
+	})
+}
+
+func TestFilter_Close(t *testing.T) {
+	c := newConfig().(*Config)
+	c.File = testFile
+	if !assert.NoError(t, c.Validate()) {
+		t.FailNow()
+	}
+	f, err := newFilter(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	inst, err := f.get()
+	assert.NoError(t, err)
+
+	t.Run("should be not close the runtime while the instance is in use", func(t *testing.T) {
+		assert.NoError(t, f.Close())
+		_, _, err := inst.call(context.Background(), "proxy_on_context_create", 2, rootContextID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should be close the runtime when the instance is put back", func(t *testing.T) {
+		f.put(inst)
+		_, err := f.newInstance()
+		assert.Error(t, err)
+		assert.Len(t, f.idle, 0)
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr string
+	}{
+		{name: "should be compile the module", config: &Config{File: testFile, Timeout: "1s", MaxMemoryPages: 1}},
+		{name: "should be error by the invalid timeout", config: &Config{File: testFile, Timeout: "0s", MaxMemoryPages: 1}, wantErr: "timeout: must be greater than 0"},
+		{name: "should be error if the file is not found", config: &Config{File: "notfound.wasm", Timeout: "1s", MaxMemoryPages: 1}, wantErr: "file: open notfound.wasm"},
+		{name: "should be error by the invalid module", config: &Config{File: "testdata/filter.wat", Timeout: "1s", MaxMemoryPages: 1}, wantErr: "file: invalid magic number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPairs(t *testing.T) {
+	pairs := [][2]string{{":method", "GET"}, {"x-empty", ""}, {"x-key", "value"}}
+	got, ok := decodePairs(encodePairs(pairs))
+	assert.True(t, ok)
+	assert.Equal(t, pairs, got)
+
+	_, ok = decodePairs([]byte{1, 0, 0, 0, 5, 0, 0, 0})
+	assert.False(t, ok)
+}
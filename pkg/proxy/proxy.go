@@ -1,16 +1,25 @@
 package proxy
 
 import (
-	"net"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"time"
 
+	"github.com/purini-to/plixy/pkg/api"
 	"github.com/purini-to/plixy/pkg/api/director"
+	"github.com/purini-to/plixy/pkg/middleware"
+	"github.com/purini-to/plixy/pkg/mirror"
+	"github.com/purini-to/plixy/pkg/plugin"
+	"github.com/purini-to/plixy/pkg/upstream"
 
 	"github.com/purini-to/plixy/pkg/config"
 	"go.opencensus.io/plugin/ochttp"
-	"golang.org/x/net/http2"
 
 	"github.com/purini-to/plixy/pkg/httperr"
 
@@ -22,49 +31,242 @@ import (
 	"go.uber.org/zap"
 )
 
+// hookError is the error returned by the AfterProxy hooks of the plugins.
+type hookError struct {
+	err error
+}
+
+func (e *hookError) Error() string {
+	return e.err.Error()
+}
+
 type Proxy struct {
-	server *httputil.ReverseProxy
+	server     *httputil.ReverseProxy
+	budget     *retryBudget
+	transports *transports
+}
+
+// Prepare builds the transports of the api definition in advance
+// so that invalid settings are found before serving.
+func (r *Proxy) Prepare(def *api.Definition) error {
+	for _, a := range def.Apis {
+		for _, u := range a.Proxy.Upstreams() {
+			if _, err := r.transports.get(u); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("could not build upstream transport. name: %s", a.Name))
+			}
+		}
+	}
+	return nil
+}
+
+// Prune removes the transports of the previous api definitions that are not used by the api definition.
+func (r *Proxy) Prune(def *api.Definition) {
+	var ups []*api.Upstream
+	for _, a := range def.Apis {
+		ups = append(ups, a.Proxy.Upstreams()...)
+	}
+	r.transports.prune(ups)
 }
 
 func (r *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.server.ServeHTTP(w, req)
+	ctx := req.Context()
+	up := upstream.FromContext(ctx)
+	if up == nil {
+		httperr.NotFound(w)
+		return
+	}
+
+	logger := log.FromContext(ctx)
+	def := up.Definition()
+	if def.Transport != nil && def.Transport.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, def.Transport.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	if m := mirror.FromContext(ctx); m != nil && m.Sample() {
+		var done func()
+		w, done = r.mirror(w, req, m)
+		defer done()
+	}
+
+	r.budget.deposit(time.Now())
+	policy := newRetryPolicy(def.Retry, req.Method)
+	var body []byte
+	if policy.attempts > 1 {
+		buf, ok, err := bufferBody(req, policy.maxBodySize)
+		if err != nil {
+			logger.Warn("Could not read request body", zap.Error(err))
+			httperr.BadRequest(w)
+			return
+		}
+		if !ok {
+			policy.attempts = 1
+		}
+		body = buf
+	}
+
+	var tried []*upstream.Target
+	for i := 1; ; i++ {
+		target, err := elect(ctx, up, tried)
+		if err != nil {
+			logger.Warn("Could not elect upstream target", zap.Error(err))
+			httperr.ServiceUnavailable(w)
+			return
+		}
+
+		a := &attempt{policy: policy, budget: r.budget, canRetry: i < policy.attempts}
+		if !r.serve(w, req, target, a, body) {
+			return
+		}
+
+		tried = append(tried, target)
+		logger.Info("Retrying upstream request",
+			zap.Int("attempt", i),
+			zap.String("reason", a.reason),
+			zap.String("upstream_host", target.URL.Host),
+		)
+		recordRetry(ctx, a, i, target)
+		if err := sleepContext(ctx, policy.backoffDuration(i)); err != nil {
+			if err == context.DeadlineExceeded {
+				httperr.GatewayTimeout(w)
+				return
+			}
+			httperr.ClientClosedRequest(w, err)
+			return
+		}
+	}
+}
+
+// mirror sends the copy of the request to the shadow upstream.
+// The returned writer records the primary response if the mirror compares the responses,
+// and done passes the result to the mirror after the request is proxied.
+func (r *Proxy) mirror(w http.ResponseWriter, req *http.Request, m *mirror.Mirror) (http.ResponseWriter, func()) {
+	logger := log.FromContext(req.Context())
+	body, ok, err := bufferBody(req, m.MaxBodySize())
+	if err != nil || !ok {
+		logger.Debug("Skip mirror request", zap.Bool("too_large", !ok), zap.Error(err))
+		return w, func() {}
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !m.Compare() {
+		m.Send(req, body, r.transports, nil)
+		return w, func() {}
+	}
+
+	primary := make(chan *mirror.Result, 1)
+	m.Send(req, body, r.transports, primary)
+	ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+	h := sha256.New()
+	ww.Tee(h)
+	return ww, func() {
+		primary <- &mirror.Result{Status: ww.Status(), Sum: h.Sum(nil)}
+	}
+}
+
+// serve sends the request to the target and reports whether it should be retried.
+func (r *Proxy) serve(w http.ResponseWriter, req *http.Request, target *upstream.Target, a *attempt, body []byte) bool {
+	ctx := upstream.TargetToContext(req.Context(), target)
+	ctx = attemptToContext(ctx, a)
+	if a.policy.perTryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.policy.perTryTimeout)
+		defer cancel()
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	target.Acquire()
+	defer target.Release()
+	r.server.ServeHTTP(w, req.WithContext(ctx))
+	if a.status != 0 {
+		upstream.Done(req.Context(), target, a.status)
+	}
+	return a.retried
 }
 
 func New() (*Proxy, error) {
-	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   config.Global.DialTimeout,
-			KeepAlive: config.Global.IdleConnTimeout,
-		}).DialContext,
-		MaxIdleConns:          config.Global.MaxIdleConns,
-		IdleConnTimeout:       config.Global.IdleConnTimeout,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		ResponseHeaderTimeout: 10 * time.Second,
-		MaxIdleConnsPerHost:   config.Global.MaxIdleConnsPerHost,
-	}
-	if err := http2.ConfigureTransport(tr); err != nil {
-		return nil, errors.Wrap(err, "could not create http2 transport")
+	trs := newTransports()
+	// warm up the transport of the global settings
+	if _, err := trs.get(nil); err != nil {
+		return nil, err
 	}
 
+	upstream.SetProbeTransport(trs)
+
 	var transport http.RoundTripper
-	transport = tr
+	transport = trs
 	if config.Global.IsObservable() {
-		transport = &ochttp.Transport{Base: tr}
+		transport = &ochttp.Transport{Base: trs}
 	}
 
 	proxy := &Proxy{
+		transports: trs,
+		budget:     newRetryBudget(config.Global.RetryBudget),
 		server: &httputil.ReverseProxy{
 			Director:  director.Director,
 			Transport: transport,
+			ModifyResponse: func(res *http.Response) error {
+				ctx := res.Request.Context()
+				a := attemptFromContext(ctx)
+				if a != nil {
+					a.status = res.StatusCode
+				}
+				if target := upstream.TargetFromContext(ctx); target != nil {
+					if res.StatusCode >= http.StatusInternalServerError {
+						target.ReportFailure()
+					} else {
+						target.ReportSuccess()
+					}
+				}
+				if a != nil && a.shouldRetry(strconv.Itoa(res.StatusCode)) {
+					return errRetry
+				}
+				if hooks := plugin.HooksFromContext(ctx); hooks != nil {
+					if err := hooks.ModifyResponse(res); err != nil {
+						return &hookError{err: err}
+					}
+				}
+				return nil
+			},
 			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				// the response is discarded to retry
+				if err == errRetry {
+					return
+				}
+
+				a := attemptFromContext(r.Context())
 				// client canceled
 				if err.Error() == "context canceled" {
+					if a != nil {
+						a.status = httperr.HTTPStatusClientClosedRequest
+					}
 					httperr.ClientClosedRequest(w, err)
 					return
 				}
 
+				// the upstream responded, so the error of the plugin is neither a failure of the target nor retried
+				reason := errorReason(err)
+				if _, ok := err.(*hookError); !ok {
+					if a != nil {
+						a.status = http.StatusBadGateway
+						if reason == retryOnTimeout {
+							a.status = http.StatusGatewayTimeout
+						}
+					}
+					if target := upstream.TargetFromContext(r.Context()); target != nil {
+						target.ReportFailure()
+					}
+					if a != nil && a.shouldRetry(reason) {
+						return
+					}
+				}
+
 				logger := log.FromContext(r.Context())
 				// disabled stacktrace
 				logger.WithOptions(zap.AddStacktrace(zapcore.PanicLevel)).
@@ -75,6 +277,13 @@ func New() (*Proxy, error) {
 						zap.String("upstream_scheme", r.URL.Scheme),
 						zap.Error(err),
 					)
+				if hooks := plugin.HooksFromContext(r.Context()); hooks != nil && hooks.HandleError(w, r, err) {
+					return
+				}
+				if reason == retryOnTimeout {
+					httperr.GatewayTimeout(w)
+					return
+				}
 				httperr.BadGateway(w)
 			},
 		},
@@ -0,0 +1,363 @@
+package proxy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/mirror"
+	"github.com/purini-to/plixy/pkg/plugin"
+	"github.com/purini-to/plixy/pkg/plugin/circuitbreaker"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+func newTestRequest(t *testing.T, method string, body string, a *api.Api) *http.Request {
+	logger, _ := zap.NewDevelopment()
+	up, err := upstream.New(a.Name, a.Proxy.Upstream)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	ctx := log.ToContext(req.Context(), logger)
+	ctx = api.ToContext(ctx, a)
+	ctx = api.VarsToContext(ctx, map[string]string{})
+	ctx = upstream.ToContext(ctx, up)
+	return req.WithContext(ctx)
+}
+
+func newTestApi(retry *api.Retry, targets ...string) *api.Api {
+	u := &api.Upstream{Retry: retry}
+	for _, t := range targets {
+		u.Targets = append(u.Targets, &api.Target{Target: t, Weight: 1})
+	}
+	return &api.Api{Name: "test", Proxy: &api.Proxy{Path: "/", Upstream: u}}
+}
+
+func TestProxy_ServeHTTP_retry(t *testing.T) {
+	var failCount, okCount int32
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failCount, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer fail.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&okCount, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer ok.Close()
+
+	config.Global.RetryBudget = config.RetryBudget{Ratio: 1, MinRetriesPerSecond: 10}
+	p, err := New()
+	assert.NoError(t, err)
+
+	reset := func() {
+		atomic.StoreInt32(&failCount, 0)
+		atomic.StoreInt32(&okCount, 0)
+	}
+
+	t.Run("should be retry to another target with the same body", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 2, Backoff: time.Millisecond}, fail.URL, ok.URL)
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, newTestRequest(t, "PUT", "hello", a))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "hello", rec.Body.String())
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&okCount))
+	})
+
+	t.Run("should be retry on connect failure", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 2, Backoff: time.Millisecond}, "http://127.0.0.1:1", ok.URL)
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, newTestRequest(t, "GET", "", a))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("should be return the last response if attempts are exhausted", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond}, fail.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "GET", "", a))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&failCount))
+	})
+
+	t.Run("should be not retry non idempotent method", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond}, fail.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "POST", "hello", a))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failCount))
+	})
+
+	t.Run("should be retry non idempotent method if allowed", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond, RetryNonIdempotent: true}, fail.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "POST", "hello", a))
+		assert.Equal(t, int32(3), atomic.LoadInt32(&failCount))
+	})
+
+	t.Run("should be not retry if body is larger than max body size", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond, MaxBodySize: 2}, fail.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "PUT", "hello", a))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failCount))
+	})
+
+	t.Run("should be not retry if status is not in retryOn", func(t *testing.T) {
+		reset()
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond, RetryOn: []string{"502"}}, fail.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "GET", "", a))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failCount))
+	})
+}
+
+func TestRetryBudget_withdraw(t *testing.T) {
+	now := time.Now()
+
+	t.Run("should be allow retries up to ratio of requests plus min retries", func(t *testing.T) {
+		b := newRetryBudget(config.RetryBudget{Ratio: 0.5, MinRetriesPerSecond: 0})
+		for i := 0; i < 4; i++ {
+			b.deposit(now)
+		}
+		assert.True(t, b.withdraw(now))
+		assert.True(t, b.withdraw(now))
+		assert.False(t, b.withdraw(now))
+	})
+
+	t.Run("should be reset when window elapsed", func(t *testing.T) {
+		b := newRetryBudget(config.RetryBudget{Ratio: 0, MinRetriesPerSecond: 0})
+		assert.False(t, b.withdraw(now))
+		b = newRetryBudget(config.RetryBudget{Ratio: 1, MinRetriesPerSecond: 0})
+		b.deposit(now)
+		assert.True(t, b.withdraw(now))
+		assert.False(t, b.withdraw(now))
+		b.deposit(now.Add(retryBudgetWindow))
+		assert.True(t, b.withdraw(now.Add(retryBudgetWindow)))
+	})
+}
+
+func TestRetryPolicy_backoffDuration(t *testing.T) {
+	p := newRetryPolicy(&api.Retry{Attempts: 5, Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}, "GET")
+	for i := 1; i <= 5; i++ {
+		d := p.backoffDuration(i)
+		assert.True(t, d >= 0 && d <= 40*time.Millisecond)
+	}
+}
+
+func TestProxy_ServeHTTP_timeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	p, err := New()
+	assert.NoError(t, err)
+
+	t.Run("should be return gateway timeout if the request exceeds the upstream timeout", func(t *testing.T) {
+		a := newTestApi(nil, slow.URL)
+		a.Proxy.Upstream.Transport = &api.Transport{Timeout: 50 * time.Millisecond}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newTestRequest(t, "GET", "", a))
+		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	})
+}
+
+func TestProxy_ServeHTTP_mirror(t *testing.T) {
+	mirrored := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		mirrored <- r
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer shadow.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer primary.Close()
+
+	p, err := New()
+	assert.NoError(t, err)
+
+	newMirrorRequest := func(t *testing.T, body string, def *api.Mirror) *http.Request {
+		a := newTestApi(nil, primary.URL)
+		a.Proxy.Mirror = def
+		m, err := mirror.New(a.Name, def)
+		assert.NoError(t, err)
+		req := newTestRequest(t, "POST", body, a)
+		return req.WithContext(mirror.ToContext(req.Context(), m))
+	}
+
+	t.Run("should be send the copy of the request to the shadow upstream", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newMirrorRequest(t, "hello", &api.Mirror{
+			Upstream: &api.Upstream{Target: shadow.URL + "/shadow"},
+			Compare:  true,
+		}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "hello", rec.Body.String())
+
+		select {
+		case r := <-mirrored:
+			assert.Equal(t, "hello", <-bodies)
+			assert.Equal(t, "/shadow/", r.URL.Path)
+			assert.Equal(t, "true", r.Header.Get(mirror.HeaderKey))
+		case <-time.After(time.Second):
+			t.Fatal("request is not mirrored")
+		}
+	})
+
+	t.Run("should be not mirror the request with a large body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newMirrorRequest(t, "hello", &api.Mirror{
+			Upstream:    &api.Upstream{Target: shadow.URL},
+			MaxBodySize: 2,
+		}))
+		assert.Equal(t, "hello", rec.Body.String())
+
+		select {
+		case <-mirrored:
+			t.Fatal("request is mirrored")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestProxy_ServeHTTP_hooks(t *testing.T) {
+	var count int32
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("X-Upstream", "true")
+	}))
+	defer ok.Close()
+
+	p, err := New()
+	assert.NoError(t, err)
+
+	newHooksRequest := func(t *testing.T, a *api.Api, hooks *plugin.Hooks) *http.Request {
+		req := newTestRequest(t, "GET", "", a)
+		return req.WithContext(plugin.HooksToContext(req.Context(), hooks))
+	}
+
+	t.Run("should be modify the response by the after proxy hooks", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newHooksRequest(t, newTestApi(nil, ok.URL), &plugin.Hooks{
+			AfterProxy: []plugin.AfterProxyHook{func(res *http.Response) error {
+				res.Header.Del("X-Upstream")
+				res.Header.Set("X-Hook", "true")
+				return nil
+			}},
+		}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "", rec.Header().Get("X-Upstream"))
+		assert.Equal(t, "true", rec.Header().Get("X-Hook"))
+	})
+
+	t.Run("should be return bad gateway without retry if the after proxy hook fails", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		a := newTestApi(&api.Retry{Attempts: 3, Backoff: time.Millisecond}, ok.URL)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newHooksRequest(t, a, &plugin.Hooks{
+			AfterProxy: []plugin.AfterProxyHook{func(res *http.Response) error {
+				return errors.New("hook error")
+			}},
+		}))
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+
+	t.Run("should be write the error response by the on error hooks", func(t *testing.T) {
+		var called []string
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, newHooksRequest(t, newTestApi(nil, "http://127.0.0.1:1"), &plugin.Hooks{
+			OnError: []plugin.OnErrorHook{
+				func(w http.ResponseWriter, r *http.Request, err error) bool {
+					called = append(called, "first")
+					return false
+				},
+				func(w http.ResponseWriter, r *http.Request, err error) bool {
+					called = append(called, "second")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return true
+				},
+			},
+		}))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, []string{"first", "second"}, called)
+	})
+}
+
+func TestProxy_ServeHTTP_circuitBreaker(t *testing.T) {
+	var failCount int32
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failCount, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer fail.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+
+	config.Global.RetryBudget = config.RetryBudget{Ratio: 1, MinRetriesPerSecond: 100}
+	p, err := New()
+	assert.NoError(t, err)
+	mw, err := circuitbreaker.BeforeProxy(map[string]interface{}{
+		"perTarget":           true,
+		"consecutiveFailures": 1,
+		"openTimeout":         "50ms",
+	})
+	assert.NoError(t, err)
+	h := mw(p)
+
+	a := newTestApi(&api.Retry{Attempts: 2, Backoff: time.Millisecond}, fail.URL, ok.URL)
+	up, err := upstream.New(a.Name, a.Proxy.Upstream)
+	assert.NoError(t, err)
+	serve := func() int {
+		req := newTestRequest(t, "GET", "", a)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req.WithContext(upstream.ToContext(req.Context(), up)))
+		return rec.Code
+	}
+
+	t.Run("should be open the circuit of the target failed before the retry", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusOK, serve())
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failCount))
+	})
+
+	t.Run("should be probe the target again after the half-open probe failed", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			atomic.StoreInt32(&failCount, 0)
+			time.Sleep(60 * time.Millisecond)
+			for j := 0; j < 4; j++ {
+				assert.Equal(t, http.StatusOK, serve())
+			}
+			assert.Equal(t, int32(1), atomic.LoadInt32(&failCount))
+		}
+	})
+}
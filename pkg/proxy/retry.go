@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	pstats "github.com/purini-to/plixy/pkg/stats"
+	"github.com/purini-to/plixy/pkg/upstream"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+)
+
+const (
+	defaultRetryBackoff     = 25 * time.Millisecond
+	defaultRetryMaxBackoff  = 250 * time.Millisecond
+	defaultRetryMaxBodySize = 64 * 1024
+	retryBudgetWindow       = 10 * time.Second
+)
+
+// Retry reasons
+const (
+	retryOnConnectFailure = "connectFailure"
+	retryOnTimeout        = "timeout"
+	retryOn5xx            = "5xx"
+)
+
+var defaultRetryOn = []string{retryOnConnectFailure, "502", "503", "504"}
+
+// errRetry is returned by ModifyResponse to discard a response that will be retried.
+var errRetry = errors.New("retry upstream request")
+
+type attemptKeyType int
+
+const attemptContextKey attemptKeyType = iota
+
+type retryPolicy struct {
+	attempts      int
+	retryOn       []string
+	perTryTimeout time.Duration
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	maxBodySize   int64
+}
+
+// newRetryPolicy creates the retry policy of the request.
+// a policy with one attempt is returned if the request can not be retried.
+func newRetryPolicy(def *api.Retry, method string) *retryPolicy {
+	if def == nil {
+		return &retryPolicy{attempts: 1}
+	}
+	p := &retryPolicy{
+		attempts:      def.Attempts,
+		retryOn:       def.RetryOn,
+		perTryTimeout: def.PerTryTimeout,
+		backoff:       def.Backoff,
+		maxBackoff:    def.MaxBackoff,
+		maxBodySize:   def.MaxBodySize,
+	}
+	if p.attempts <= 0 || (!def.RetryNonIdempotent && !isIdempotent(method)) {
+		p.attempts = 1
+	}
+	if len(p.retryOn) == 0 {
+		p.retryOn = defaultRetryOn
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRetryBackoff
+	}
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = defaultRetryMaxBackoff
+		if p.maxBackoff < p.backoff {
+			p.maxBackoff = p.backoff
+		}
+	}
+	if p.maxBodySize <= 0 {
+		p.maxBodySize = defaultRetryMaxBodySize
+	}
+	return p
+}
+
+func (p *retryPolicy) retryable(reason string) bool {
+	for _, r := range p.retryOn {
+		if r == reason {
+			return true
+		}
+		if r == retryOn5xx && len(reason) == 3 && reason[0] == '5' {
+			return true
+		}
+	}
+	return false
+}
+
+// backoffDuration returns the exponential backoff with full jitter.
+func (p *retryPolicy) backoffDuration(retry int) time.Duration {
+	d := p.backoff
+	for i := 1; i < retry && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferBody reads the request body so that it can be replayed.
+// it returns false if the body is larger than max, and the body is left readable.
+func bufferBody(req *http.Request, max int64) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength > max {
+		return nil, false, nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(buf)) > max {
+		req.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(buf), req.Body), Closer: req.Body}
+		return nil, false, nil
+	}
+	_ = req.Body.Close()
+	return buf, true, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// attempt is the state of an attempt to send a request to upstream.
+type attempt struct {
+	policy   *retryPolicy
+	budget   *retryBudget
+	canRetry bool
+	retried  bool
+	reason   string
+	// status is the status of the response, or of the error if the upstream did not respond.
+	status int
+}
+
+// shouldRetry reports whether the attempt is retried by the reason.
+// the retry budget is consumed if retried.
+func (a *attempt) shouldRetry(reason string) bool {
+	if !a.canRetry || a.retried || !a.policy.retryable(reason) {
+		return false
+	}
+	if !a.budget.withdraw(time.Now()) {
+		return false
+	}
+	a.retried = true
+	a.reason = reason
+	return true
+}
+
+func attemptToContext(ctx context.Context, a *attempt) context.Context {
+	return context.WithValue(ctx, attemptContextKey, a)
+}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	if a, ok := ctx.Value(attemptContextKey).(*attempt); ok {
+		return a
+	}
+	return nil
+}
+
+func errorReason(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return retryOnConnectFailure
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return retryOnTimeout
+	}
+	return ""
+}
+
+// excludeTried is a hook that excludes the targets already tried.
+type excludeTried []*upstream.Target
+
+func (e excludeTried) Allow(t *upstream.Target) bool {
+	for _, tried := range e {
+		if t == tried {
+			return false
+		}
+	}
+	return true
+}
+
+func (e excludeTried) Elected(t *upstream.Target) {}
+
+func (e excludeTried) Done(t *upstream.Target, status int) {}
+
+// elect elects a target that has not been tried yet if possible.
+func elect(ctx context.Context, up *upstream.Upstream, tried []*upstream.Target) (*upstream.Target, error) {
+	if len(tried) == 0 {
+		return up.Next(ctx)
+	}
+	target, err := up.Next(upstream.HookToContext(ctx, excludeTried(tried)))
+	if err == upstream.ErrNoTarget {
+		return up.Next(ctx)
+	}
+	return target, err
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func recordRetry(ctx context.Context, a *attempt, retry int, target *upstream.Target) {
+	if config.Global.Stats.Enable {
+		if ctx, err := tag.New(ctx, tag.Upsert(pstats.KeyReason, a.reason)); err == nil {
+			stats.Record(ctx, pstats.UpstreamRetryCount.M(1))
+		}
+	}
+	if config.Global.Trace.Enable {
+		if span := trace.FromContext(ctx); span != nil {
+			span.Annotate([]trace.Attribute{
+				trace.Int64Attribute("plixy.retry", int64(retry)),
+				trace.StringAttribute("plixy.retry_reason", a.reason),
+				trace.StringAttribute("plixy.upstream_target", target.URL.Host),
+			}, "Retrying upstream request")
+		}
+	}
+}
+
+// retryBudget limits retries to a ratio of requests within a fixed window.
+type retryBudget struct {
+	sync.Mutex
+	ratio       float64
+	minRetries  float64
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+func newRetryBudget(conf config.RetryBudget) *retryBudget {
+	return &retryBudget{
+		ratio:      conf.Ratio,
+		minRetries: float64(conf.MinRetriesPerSecond) * retryBudgetWindow.Seconds(),
+	}
+}
+
+func (b *retryBudget) roll(now time.Time) {
+	if now.Sub(b.windowStart) >= retryBudgetWindow {
+		b.windowStart = now
+		b.requests, b.retries = 0, 0
+	}
+}
+
+// deposit counts a request.
+func (b *retryBudget) deposit(now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+	b.roll(now)
+	b.requests++
+}
+
+// withdraw counts a retry if the budget remains.
+func (b *retryBudget) withdraw(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	b.roll(now)
+	if float64(b.retries) >= b.minRetries+b.ratio*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
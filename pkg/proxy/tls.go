@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// tlsReloadInterval is the interval to check changes of the certificate files.
+const tlsReloadInterval = 5 * time.Second
+
+// tlsKey is the tls settings of a transport.
+type tlsKey struct {
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+func newTLSKey(def *api.UpstreamTLS) tlsKey {
+	if def == nil {
+		return tlsKey{}
+	}
+	return tlsKey{
+		caFile:             def.CAFile,
+		certFile:           def.CertFile,
+		keyFile:            def.KeyFile,
+		serverName:         def.ServerName,
+		insecureSkipVerify: def.InsecureSkipVerify,
+	}
+}
+
+func (k tlsKey) files() []string {
+	files := make([]string, 0, 3)
+	for _, f := range []string{k.caFile, k.certFile, k.keyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// newTLSConfig creates a tls config of the settings.
+// nil is returned if the settings are default.
+func newTLSConfig(k tlsKey) (*tls.Config, error) {
+	if k == (tlsKey{}) {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         k.serverName,
+		InsecureSkipVerify: k.insecureSkipVerify,
+	}
+	if k.caFile != "" {
+		pem, err := ioutil.ReadFile(k.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read upstream ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("no certificates in upstream ca file. file: %s", k.caFile))
+		}
+		cfg.RootCAs = pool
+	}
+	if k.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load upstream client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// modTimes returns the latest modification time of the files.
+func modTimes(files []string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
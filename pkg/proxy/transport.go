@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+// transportKey is the settings of a transport.
+// the apis with the same settings share a transport.
+type transportKey struct {
+	connectTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+	http2                 bool
+	tls                   tlsKey
+}
+
+func newTransportKey(def *api.Upstream) transportKey {
+	k := transportKey{
+		connectTimeout:        config.Global.DialTimeout,
+		responseHeaderTimeout: config.Global.ResponseHeaderTimeout,
+		maxIdleConns:          config.Global.MaxIdleConns,
+		maxIdleConnsPerHost:   config.Global.MaxIdleConnsPerHost,
+		idleConnTimeout:       config.Global.IdleConnTimeout,
+		http2:                 config.Global.HTTP2,
+	}
+	if def == nil {
+		return k
+	}
+	k.tls = newTLSKey(def.TLS)
+
+	tr := def.Transport
+	if tr == nil {
+		return k
+	}
+	if tr.ConnectTimeout > 0 {
+		k.connectTimeout = tr.ConnectTimeout
+	}
+	if tr.ResponseHeaderTimeout > 0 {
+		k.responseHeaderTimeout = tr.ResponseHeaderTimeout
+	}
+	if tr.MaxIdleConns > 0 {
+		k.maxIdleConns = tr.MaxIdleConns
+	}
+	if tr.MaxIdleConnsPerHost > 0 {
+		k.maxIdleConnsPerHost = tr.MaxIdleConnsPerHost
+	}
+	if tr.IdleConnTimeout > 0 {
+		k.idleConnTimeout = tr.IdleConnTimeout
+	}
+	if tr.HTTP2 != nil {
+		k.http2 = *tr.HTTP2
+	}
+	return k
+}
+
+func newTransport(k transportKey) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(k.tls)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   k.connectTimeout,
+			KeepAlive: k.idleConnTimeout,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          k.maxIdleConns,
+		IdleConnTimeout:       k.idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: k.responseHeaderTimeout,
+		MaxIdleConnsPerHost:   k.maxIdleConnsPerHost,
+	}
+	if !k.http2 {
+		// a non-nil empty map disables http2
+		tr.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+		return tr, nil
+	}
+	if err := http2.ConfigureTransport(tr); err != nil {
+		return nil, errors.Wrap(err, "could not create http2 transport")
+	}
+	return tr, nil
+}
+
+// reloadableTransport rebuilds the transport when the certificate files change.
+// in-flight requests keep using the previous transport.
+type reloadableTransport struct {
+	sync.RWMutex
+	key       transportKey
+	files     []string
+	current   *http.Transport
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newReloadableTransport(k transportKey) (*reloadableTransport, error) {
+	files := k.tls.files()
+	modTime, err := modTimes(files)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not stat upstream tls files")
+	}
+	tr, err := newTransport(k)
+	if err != nil {
+		return nil, err
+	}
+	return &reloadableTransport{
+		key:       k,
+		files:     files,
+		current:   tr,
+		modTime:   modTime,
+		checkedAt: time.Now(),
+	}, nil
+}
+
+func (r *reloadableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.transport(time.Now()).RoundTrip(req)
+}
+
+func (r *reloadableTransport) transport(now time.Time) *http.Transport {
+	r.RLock()
+	tr := r.current
+	check := len(r.files) > 0 && now.Sub(r.checkedAt) >= tlsReloadInterval
+	r.RUnlock()
+	if !check {
+		return tr
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if now.Sub(r.checkedAt) < tlsReloadInterval {
+		return r.current
+	}
+	r.checkedAt = now
+
+	modTime, err := modTimes(r.files)
+	if err != nil || !modTime.After(r.modTime) {
+		return r.current
+	}
+	newTr, err := newTransport(r.key)
+	if err != nil {
+		log.Error("Could not reload upstream tls certificates", zap.Strings("files", r.files), zap.Error(err))
+		return r.current
+	}
+	log.Info("Reloaded upstream tls certificates", zap.Strings("files", r.files))
+
+	old := r.current
+	r.current = newTr
+	r.modTime = modTime
+	old.CloseIdleConnections()
+	return r.current
+}
+
+func (r *reloadableTransport) closeIdleConnections() {
+	r.RLock()
+	defer r.RUnlock()
+	r.current.CloseIdleConnections()
+}
+
+// transports dispatches requests to the transport of the api settings.
+type transports struct {
+	sync.RWMutex
+	cache map[transportKey]*reloadableTransport
+}
+
+func newTransports() *transports {
+	return &transports{
+		cache: make(map[transportKey]*reloadableTransport),
+	}
+}
+
+// RoundTrip sends the request by the transport of the upstream in the context,
+// or by the transport of the global settings if the context has no upstream.
+func (t *transports) RoundTrip(req *http.Request) (*http.Response, error) {
+	var def *api.Upstream
+	if up := upstream.FromContext(req.Context()); up != nil {
+		def = up.Definition()
+	}
+	tr, err := t.get(def)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+func (t *transports) get(def *api.Upstream) (*reloadableTransport, error) {
+	k := newTransportKey(def)
+
+	t.RLock()
+	tr, ok := t.cache[k]
+	t.RUnlock()
+	if ok {
+		return tr, nil
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if tr, ok := t.cache[k]; ok {
+		return tr, nil
+	}
+	tr, err := newReloadableTransport(k)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[k] = tr
+	return tr, nil
+}
+
+// prune removes the transports not used by the upstreams or the global settings
+// and closes their idle connections. The in-flight requests finish on the removed transports.
+func (t *transports) prune(defs []*api.Upstream) {
+	used := map[transportKey]struct{}{newTransportKey(nil): {}}
+	for _, def := range defs {
+		used[newTransportKey(def)] = struct{}{}
+	}
+
+	t.Lock()
+	var removed []*reloadableTransport
+	for k, tr := range t.cache {
+		if _, ok := used[k]; !ok {
+			removed = append(removed, tr)
+			delete(t.cache, k)
+		}
+	}
+	t.Unlock()
+
+	for _, tr := range removed {
+		tr.closeIdleConnections()
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/upstream"
+)
+
+func TestTransports_get(t *testing.T) {
+	config.Global.DialTimeout = 30 * time.Second
+	config.Global.ResponseHeaderTimeout = 10 * time.Second
+	config.Global.HTTP2 = true
+	trs := newTransports()
+
+	t.Run("should be use the global settings if no override", func(t *testing.T) {
+		tr, err := trs.get(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 10*time.Second, tr.current.ResponseHeaderTimeout)
+		assert.NotEmpty(t, tr.current.TLSNextProto)
+	})
+
+	t.Run("should be share the transport of the same settings", func(t *testing.T) {
+		a, _ := trs.get(&api.Upstream{Transport: &api.Transport{ResponseHeaderTimeout: time.Minute}})
+		b, _ := trs.get(&api.Upstream{Transport: &api.Transport{ResponseHeaderTimeout: time.Minute}})
+		c, _ := trs.get(nil)
+		assert.Same(t, a, b)
+		assert.False(t, a == c)
+		assert.Equal(t, time.Minute, a.current.ResponseHeaderTimeout)
+	})
+
+	t.Run("should be disable http2 if http2 is false", func(t *testing.T) {
+		disabled := false
+		tr, err := trs.get(&api.Upstream{Transport: &api.Transport{HTTP2: &disabled}})
+		assert.NoError(t, err)
+		assert.NotNil(t, tr.current.TLSNextProto)
+		assert.Empty(t, tr.current.TLSNextProto)
+	})
+
+	t.Run("should be return error if tls files are not found", func(t *testing.T) {
+		_, err := trs.get(&api.Upstream{TLS: &api.UpstreamTLS{CAFile: "not_found.pem"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("should be prune the transports not used by the upstreams", func(t *testing.T) {
+		used := &api.Upstream{Transport: &api.Transport{ResponseHeaderTimeout: time.Minute}}
+		unused := &api.Upstream{Transport: &api.Transport{ResponseHeaderTimeout: time.Hour}}
+		a, _ := trs.get(used)
+		b, _ := trs.get(unused)
+		global, _ := trs.get(nil)
+
+		trs.prune([]*api.Upstream{used})
+
+		assert.Len(t, trs.cache, 2)
+		tr, _ := trs.get(used)
+		assert.Same(t, a, tr)
+		tr, _ = trs.get(nil)
+		assert.Same(t, global, tr)
+		tr, _ = trs.get(unused)
+		assert.False(t, b == tr)
+	})
+}
+
+func writeCertificate(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestReloadableTransport_tls(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "transport_test")
+	defer os.RemoveAll(dir)
+
+	var clientCN string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+	certFile, keyFile := writeCertificate(t, dir, "client")
+
+	get := func(tr http.RoundTripper) error {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	t.Run("should be verify upstream by the ca file", func(t *testing.T) {
+		tr, err := newReloadableTransport(transportKey{tls: tlsKey{caFile: caFile, serverName: "example.com"}})
+		assert.NoError(t, err)
+		assert.NoError(t, get(tr))
+	})
+
+	t.Run("should be fail without the ca file", func(t *testing.T) {
+		tr, err := newReloadableTransport(transportKey{})
+		assert.NoError(t, err)
+		assert.Error(t, get(tr))
+	})
+
+	t.Run("should be skip verify if insecure", func(t *testing.T) {
+		tr, err := newReloadableTransport(transportKey{tls: tlsKey{insecureSkipVerify: true}})
+		assert.NoError(t, err)
+		assert.NoError(t, get(tr))
+	})
+
+	t.Run("should be send the client certificate", func(t *testing.T) {
+		tr, err := newReloadableTransport(transportKey{tls: tlsKey{caFile: caFile, certFile: certFile, keyFile: keyFile}})
+		assert.NoError(t, err)
+		assert.NoError(t, get(tr))
+		assert.Equal(t, "client", clientCN)
+	})
+
+	t.Run("should be reload the certificate if files changed", func(t *testing.T) {
+		tr, err := newReloadableTransport(transportKey{tls: tlsKey{caFile: caFile, certFile: certFile, keyFile: keyFile}})
+		assert.NoError(t, err)
+		before := tr.current
+
+		writeCertificate(t, dir, "client")
+		later := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(certFile, later, later))
+
+		assert.Same(t, before, tr.transport(time.Now()))
+		assert.False(t, before == tr.transport(time.Now().Add(tlsReloadInterval)))
+	})
+}
+
+func TestTransports_healthCheck(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "transport_test")
+	defer os.RemoveAll(dir)
+
+	var probes int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+	certFile, keyFile := writeCertificate(t, dir, "client")
+
+	_, err := New()
+	assert.NoError(t, err)
+
+	t.Run("should be check the health by the tls settings of the upstream", func(t *testing.T) {
+		up, err := upstream.New("test", &api.Upstream{
+			Target: ts.URL,
+			TLS:    &api.UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			HealthCheck: &api.HealthCheck{
+				Path:               "/healthz",
+				Interval:           10 * time.Millisecond,
+				HealthyThreshold:   1,
+				UnhealthyThreshold: 1,
+			},
+		})
+		assert.NoError(t, err)
+		defer up.Close()
+
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&probes) >= 3
+		}, time.Second, 10*time.Millisecond)
+		assert.True(t, up.Targets()[0].Healthy())
+	})
+
+	t.Run("should be unhealthy without the client certificate", func(t *testing.T) {
+		up, err := upstream.New("test", &api.Upstream{
+			Target: ts.URL,
+			TLS:    &api.UpstreamTLS{CAFile: caFile},
+			HealthCheck: &api.HealthCheck{
+				Path:               "/healthz",
+				Interval:           10 * time.Millisecond,
+				HealthyThreshold:   1,
+				UnhealthyThreshold: 1,
+			},
+		})
+		assert.NoError(t, err)
+		defer up.Close()
+
+		assert.Eventually(t, func() bool {
+			return !up.Targets()[0].Healthy()
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestTransports_RoundTrip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	t.Run("should be use the global transport without the upstream", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		res, err := newTransports().RoundTrip(req)
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/purini-to/plixy/pkg/acme"
+	"github.com/purini-to/plixy/pkg/certstore"
 	"github.com/purini-to/plixy/pkg/store"
 
 	"github.com/purini-to/plixy/pkg/api/router"
@@ -25,12 +27,20 @@ import (
 	"go.uber.org/zap"
 
 	// plugins
+	_ "github.com/purini-to/plixy/pkg/plugin/circuitbreaker"
+	_ "github.com/purini-to/plixy/pkg/plugin/headers"
+	_ "github.com/purini-to/plixy/pkg/plugin/jwt"
 	_ "github.com/purini-to/plixy/pkg/plugin/rate"
+	_ "github.com/purini-to/plixy/pkg/plugin/script"
+	_ "github.com/purini-to/plixy/pkg/plugin/wasm"
 )
 
 type Server struct {
 	sync.RWMutex
 	server      *http.Server
+	redirect    *http.Server
+	certs       *certstore.Store
+	acme        *acme.Manager
 	proxy       *proxy.Proxy
 	router      *router.Router
 	middlewares []func(http.Handler) http.Handler
@@ -42,6 +52,7 @@ type Server struct {
 func New(store store.Store) *Server {
 	return &Server{
 		store:    store,
+		certs:    certstore.New(),
 		stopChan: make(chan struct{}),
 		defChan:  make(chan *api.DefinitionChanged),
 	}
@@ -62,16 +73,20 @@ func (s *Server) Start(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "could not get api definition")
 	}
+	// the proxy sets the transport of the health checks started by the router.
+	s.proxy, err = proxy.New()
+	if err != nil {
+		return errors.Wrap(err, "error proxy.New()")
+	}
 	rt, err := router.NewRouter(def)
 	if err != nil {
 		return err
 	}
-	s.router = rt
-
-	s.proxy, err = proxy.New()
-	if err != nil {
-		return errors.Wrap(err, "error proxy.New()")
+	if err = s.proxy.Prepare(def); err != nil {
+		_ = rt.Close()
+		return err
 	}
+	s.router = rt
 
 	if config.Global.Watch {
 		if err = s.store.Watch(ctx, config.Global.WatchInterval, s.defChan); err != nil {
@@ -88,6 +103,30 @@ func (s *Server) Start(ctx context.Context) error {
 	s.server = &http.Server{
 		Handler: s.buildMux(),
 	}
+	if config.Global.TLS.Enable {
+		if config.Global.TLS.ACME.Enable {
+			if s.acme, err = acme.New(config.Global.TLS.ACME); err != nil {
+				_ = listener.Close()
+				return errors.Wrap(err, "could not create acme manager")
+			}
+		}
+		if err = s.loadCertificates(def); err != nil {
+			_ = listener.Close()
+			return errors.Wrap(err, "could not load tls certificates")
+		}
+		s.server.TLSConfig, err = newTLSConfig(config.Global.TLS, s.certs, s.acme)
+		if err != nil {
+			_ = listener.Close()
+			return errors.Wrap(err, "could not build tls config")
+		}
+		if config.Global.Watch && config.Global.TLS.CertDir != "" {
+			certstore.WatchDir(ctx, config.Global.TLS.CertDir, config.Global.WatchInterval, s.handleCertificateEvent)
+		}
+		if err = s.startRedirect(); err != nil {
+			_ = listener.Close()
+			return err
+		}
+	}
 
 	go func() {
 		if err := s.serve(listener); err != http.ErrServerClosed {
@@ -96,7 +135,37 @@ func (s *Server) Start(ctx context.Context) error {
 	}()
 	go s.listenApiDefinition(ctx)
 
-	log.Info("Listening HTTP server", zap.String("address", address))
+	if config.Global.TLS.Enable {
+		log.Info("Listening HTTPS server", zap.String("address", address))
+	} else {
+		log.Info("Listening HTTP server", zap.String("address", address))
+	}
+	return nil
+}
+
+func (s *Server) startRedirect() error {
+	if config.Global.TLS.RedirectPort == 0 {
+		return nil
+	}
+
+	address := fmt.Sprintf(":%v", config.Global.TLS.RedirectPort)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrap(err, "error opening redirect listener")
+	}
+	s.redirect = &http.Server{
+		Handler: redirectHandler(config.Global.Port),
+	}
+	if s.acme != nil {
+		s.redirect.Handler = s.acme.HTTPHandler(s.redirect.Handler)
+	}
+
+	go func() {
+		if err := s.redirect.Serve(listener); err != http.ErrServerClosed {
+			log.Fatal("Could not start redirect server", zap.Error(err))
+		}
+	}()
+	log.Info("Listening HTTP redirect server", zap.String("address", address))
 	return nil
 }
 
@@ -106,18 +175,30 @@ func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), config.Global.GraceTimeOut)
 	defer cancel()
 	log.Info(fmt.Sprintf("Waiting %s before killing connections...", config.Global.GraceTimeOut))
+	if s.redirect != nil {
+		_ = s.redirect.Shutdown(ctx)
+	}
 	if err := s.server.Shutdown(ctx); err != nil {
 		log.Debug("Wait is over due to error", zap.Error(err))
 		_ = s.server.Close()
 	}
 	log.Debug("Server closed")
 
+	s.Lock()
+	if err := s.router.Close(); err != nil {
+		log.Warn("could not close router", zap.Error(err))
+	}
+	s.Unlock()
+
 	s.stopChan <- struct{}{}
 }
 
 func (s *Server) Close() error {
 	defer close(s.stopChan)
 	defer close(s.defChan)
+	if s.redirect != nil {
+		_ = s.redirect.Close()
+	}
 	return s.server.Close()
 }
 
@@ -130,9 +211,10 @@ func (s *Server) Wait() {
 func (s *Server) buildMux() http.Handler {
 	next := s.router.WithApiDefinition(s.proxy)
 	next = middleware.Chain(next, s.middlewares)
+	healthHandler := health.NewHandler(s.router)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/__health__" {
-			health.Handler(w, r)
+			healthHandler(w, r)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -160,6 +242,9 @@ func (s *Server) buildMiddlewares() error {
 }
 
 func (s *Server) serve(listener net.Listener) error {
+	if s.server.TLSConfig != nil {
+		return s.server.ServeTLS(listener, "", "")
+	}
 	return s.server.Serve(listener)
 }
 
@@ -185,12 +270,57 @@ func (s *Server) handleApiDefinitionEvent() {
 		log.Error("failed get definition", zap.Error(err))
 		return
 	}
+	// the router validates the definition before the transports of it are built.
 	rt, err := router.NewRouter(def)
 	if err != nil {
 		log.Error("could not new router", zap.Error(err))
 		return
 	}
+	if err = s.proxy.Prepare(def); err != nil {
+		_ = rt.Close()
+		log.Error("could not prepare proxy", zap.Error(err))
+		return
+	}
+	if config.Global.TLS.Enable {
+		if err = s.loadCertificates(def); err != nil {
+			_ = rt.Close()
+			log.Error("could not load tls certificates", zap.Error(err))
+			return
+		}
+	}
+	old := s.router
 	s.router = rt
 	s.server.Handler = s.buildMux()
+	if err := old.Close(); err != nil {
+		log.Warn("could not close old router", zap.Error(err))
+	}
+	s.proxy.Prune(def)
 	log.Info("Reloaded proxy based on new api definition")
 }
+
+func (s *Server) handleCertificateEvent() {
+	s.Lock()
+	defer s.Unlock()
+	def, err := s.store.GetDefinition()
+	if err != nil {
+		log.Error("failed get definition", zap.Error(err))
+		return
+	}
+	if err = loadCertificates(config.Global.TLS, def, s.certs); err != nil {
+		log.Error("could not load tls certificates", zap.Error(err))
+		return
+	}
+	log.Info("Reloaded tls certificates", zap.Int("names", s.certs.Len()))
+}
+
+// loadCertificates loads the certificates of the api definition,
+// and allows the acme manager to obtain certificates of its hosts.
+func (s *Server) loadCertificates(def *api.Definition) error {
+	if err := loadCertificates(config.Global.TLS, def, s.certs); err != nil {
+		return err
+	}
+	if s.acme != nil {
+		s.acme.SetHosts(def.AcmeHosts())
+	}
+	return nil
+}
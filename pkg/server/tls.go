@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/acme"
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/certstore"
+	"github.com/purini-to/plixy/pkg/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// newTLSConfig creates the tls config of the gateway listener.
+// certificates are selected by the store, or by the acme manager if it handles the server name.
+func newTLSConfig(conf config.TLS, store *certstore.Store, acm *acme.Manager) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[conf.MinVersion]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("The selected tls min version is not supported. version: %s", conf.MinVersion))
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: store.GetCertificate,
+	}
+	if acm != nil {
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if acm.Handles(hello) {
+				return acm.GetCertificate(hello)
+			}
+			return store.GetCertificate(hello)
+		}
+		cfg.NextProtos = []string{acme.ALPNProto}
+	}
+	for _, name := range conf.CipherSuites {
+		id, ok := cipherSuites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("The selected cipher suite is not supported. name: %s", name))
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	clientAuth, ok := clientAuthTypes[conf.ClientAuth]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("The selected tls client auth is not supported. client auth: %s", conf.ClientAuth))
+	}
+	if clientAuth != tls.NoClientCert {
+		pool, err := loadCertPool(conf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = clientAuth
+		cfg.ClientCAs = pool
+	}
+	if acm != nil && cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		// the acme server does not send a client certificate for tls-alpn-01 challenges
+		challenge := cfg.Clone()
+		challenge.ClientAuth = tls.NoClientCert
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if acm.Challenge(hello) {
+				return challenge, nil
+			}
+			return nil, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadCertPool loads the ca certificates to verify client certificates.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, errors.New("tls client ca file is required if client auth is enabled")
+	}
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read tls client ca file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(fmt.Sprintf("could not parse tls client ca file. file: %s", file))
+	}
+	return pool, nil
+}
+
+// loadCertificates loads the certificates of the config and the api definition,
+// and swaps them in the store.
+func loadCertificates(conf config.TLS, def *api.Definition, store *certstore.Store) error {
+	var defCert *tls.Certificate
+	if conf.CertFile != "" || conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "could not load tls certificate")
+		}
+		defCert = &cert
+	}
+
+	certs := make([]*tls.Certificate, 0)
+	if conf.CertDir != "" {
+		dirCerts, err := certstore.LoadDir(conf.CertDir)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, dirCerts...)
+	}
+	defCerts, err := certstore.LoadDefinition(def)
+	if err != nil {
+		return err
+	}
+	certs = append(certs, defCerts...)
+
+	if defCert == nil && len(certs) == 0 && !conf.ACME.Enable {
+		return errors.New("tls certificate is required")
+	}
+	return store.Set(defCert, certs)
+}
+
+// redirectHandler redirects http requests to https on the gateway port.
+func redirectHandler(port uint) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(port))
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
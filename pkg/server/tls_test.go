@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/certstore"
+	"github.com/purini-to/plixy/pkg/config"
+)
+
+func writeCertificate(t *testing.T, dir string, hosts ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, hosts[0]+".crt")
+	keyFile := filepath.Join(dir, hosts[0]+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	store := certstore.New()
+
+	t.Run("should be build config with min version and cipher suites", func(t *testing.T) {
+		cfg, err := newTLSConfig(config.TLS{
+			MinVersion:   "1.2",
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+			ClientAuth:   "none",
+		}, store, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+		assert.NotNil(t, cfg.GetCertificate)
+		assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+	})
+
+	t.Run("should be return error if min version is unknown", func(t *testing.T) {
+		_, err := newTLSConfig(config.TLS{MinVersion: "0.9"}, store, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be return error if cipher suite is unknown", func(t *testing.T) {
+		_, err := newTLSConfig(config.TLS{MinVersion: "1.2", CipherSuites: []string{"unknown"}, ClientAuth: "none"}, store, nil)
+		assert.Error(t, err)
+	})
+
+	dir, _ := ioutil.TempDir("", "server_test")
+	defer os.RemoveAll(dir)
+	caFile, _ := writeCertificate(t, dir, "client-ca")
+
+	t.Run("should be verify client certificates by the ca", func(t *testing.T) {
+		cfg, err := newTLSConfig(config.TLS{MinVersion: "1.2", ClientAuth: "require", ClientCAFile: caFile}, store, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+		assert.NotNil(t, cfg.ClientCAs)
+	})
+
+	t.Run("should be return error if client auth is unknown", func(t *testing.T) {
+		_, err := newTLSConfig(config.TLS{MinVersion: "1.2", ClientAuth: "unknown"}, store, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("should be return error if client ca file is not set", func(t *testing.T) {
+		_, err := newTLSConfig(config.TLS{MinVersion: "1.2", ClientAuth: "optional"}, store, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadCertificates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "server_test")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir, "localhost")
+	certDir := filepath.Join(dir, "certs")
+	_ = os.Mkdir(certDir, 0700)
+	writeCertificate(t, certDir, "example.com")
+	apiCertFile, apiKeyFile := writeCertificate(t, dir, "api.example.com")
+
+	t.Run("should be load certificates of the config and the definition", func(t *testing.T) {
+		store := certstore.New()
+		err := loadCertificates(config.TLS{CertFile: certFile, KeyFile: keyFile, CertDir: certDir}, &api.Definition{
+			Certificates: []*api.Certificate{{CertFile: apiCertFile, KeyFile: apiKeyFile}},
+		}, store)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, store.Len())
+
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.test"})
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost", cert.Leaf.Subject.CommonName)
+	})
+
+	t.Run("should be return error if there is no certificate", func(t *testing.T) {
+		err := loadCertificates(config.TLS{}, &api.Definition{}, certstore.New())
+		assert.Error(t, err)
+	})
+
+	t.Run("should be return error if certificate is not found", func(t *testing.T) {
+		err := loadCertificates(config.TLS{CertFile: "not_found.crt", KeyFile: "not_found.key"}, &api.Definition{}, certstore.New())
+		assert.Error(t, err)
+	})
+}
+
+func TestRedirectHandler(t *testing.T) {
+	t.Run("should be redirect to https on the gateway port", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com:8080/users?id=1", nil)
+		rec := httptest.NewRecorder()
+		redirectHandler(8443).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "https://example.com:8443/users?id=1", rec.Header().Get("Location"))
+	})
+
+	t.Run("should be omit the port if it is 443", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/users", nil)
+		rec := httptest.NewRecorder()
+		redirectHandler(443).ServeHTTP(rec, req)
+
+		assert.Equal(t, "https://example.com/users", rec.Header().Get("Location"))
+	})
+}
@@ -18,6 +18,10 @@ import (
 var (
 	KeyPath, _    = tag.NewKey("path")
 	KeyApiName, _ = tag.NewKey("api_name")
+	KeyTarget, _  = tag.NewKey("target")
+	KeyReason, _  = tag.NewKey("reason")
+	KeyStatus, _  = tag.NewKey("status")
+	KeyResult, _  = tag.NewKey("result")
 )
 
 // Measures
@@ -26,6 +30,30 @@ var (
 		"http/proxy/concurrent_request_count",
 		"Current count of HTTP requests",
 		stats.UnitDimensionless)
+	UpstreamTargetHealthy = stats.Int64(
+		"upstream/target_healthy",
+		"Health status of upstream target (1: healthy, 0: unhealthy)",
+		stats.UnitDimensionless)
+	UpstreamTargetEjectionCount = stats.Int64(
+		"upstream/target_ejection_count",
+		"Count of upstream target ejections by the passive health check",
+		stats.UnitDimensionless)
+	UpstreamRetryCount = stats.Int64(
+		"upstream/retry_count",
+		"Count of retried requests to upstream",
+		stats.UnitDimensionless)
+	MirrorLatency = stats.Float64(
+		"upstream/mirror_latency",
+		"Latency of mirrored requests to the shadow upstream",
+		stats.UnitMilliseconds)
+	MirrorCompareCount = stats.Int64(
+		"upstream/mirror_compare_count",
+		"Count of comparisons between mirror and primary responses",
+		stats.UnitDimensionless)
+	MirrorDroppedCount = stats.Int64(
+		"upstream/mirror_dropped_count",
+		"Count of requests not mirrored as the mirrored requests in flight reached the limit",
+		stats.UnitDimensionless)
 )
 
 // AllViews aggregates the metrics
@@ -121,6 +149,56 @@ var AllViews = []*view.View{
 		Description: "Count of completed requests, by HTTP method and response status and api name",
 		TagKeys:     []tag.Key{ochttp.KeyClientMethod, ochttp.KeyClientStatus, KeyApiName},
 	},
+	// upstream
+	{
+		Name:        "upstream/target_healthy",
+		Measure:     UpstreamTargetHealthy,
+		Aggregation: view.LastValue(),
+		Description: "Health status of upstream target (1: healthy, 0: unhealthy), by api name and target",
+		TagKeys:     []tag.Key{KeyApiName, KeyTarget},
+	},
+	{
+		Name:        "upstream/target_ejection_count",
+		Measure:     UpstreamTargetEjectionCount,
+		Aggregation: view.Count(),
+		Description: "Count of upstream target ejections by the passive health check, by api name and target",
+		TagKeys:     []tag.Key{KeyApiName, KeyTarget},
+	},
+	{
+		Name:        "upstream/retry_count",
+		Measure:     UpstreamRetryCount,
+		Aggregation: view.Count(),
+		Description: "Count of retried requests to upstream, by api name and reason",
+		TagKeys:     []tag.Key{KeyApiName, KeyReason},
+	},
+	{
+		Name:        "upstream/mirror_count",
+		Measure:     MirrorLatency,
+		Aggregation: view.Count(),
+		Description: "Count of mirrored requests, by api name and status",
+		TagKeys:     []tag.Key{KeyApiName, KeyStatus},
+	},
+	{
+		Name:        "upstream/mirror_latency",
+		Measure:     MirrorLatency,
+		Aggregation: ochttp.DefaultLatencyDistribution,
+		Description: "Latency distribution of mirrored requests, by api name and status",
+		TagKeys:     []tag.Key{KeyApiName, KeyStatus},
+	},
+	{
+		Name:        "upstream/mirror_compare_count",
+		Measure:     MirrorCompareCount,
+		Aggregation: view.Count(),
+		Description: "Count of comparisons between mirror and primary responses, by api name and result",
+		TagKeys:     []tag.Key{KeyApiName, KeyResult},
+	},
+	{
+		Name:        "upstream/mirror_dropped_count",
+		Measure:     MirrorDroppedCount,
+		Aggregation: view.Count(),
+		Description: "Count of requests not mirrored as the mirrored requests in flight reached the limit, by api name",
+		TagKeys:     []tag.Key{KeyApiName},
+	},
 }
 
 var exporter Exporter
@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/plugin"
 )
 
 // Store represents a in memory store.
@@ -25,11 +26,18 @@ func (s *Store) GetDefinition() (*api.Definition, error) {
 }
 
 // SetDefinition set a api definition.
-// definition not set if that is invalid.
+// The deprecated settings are translated before the validation,
+// and definition not set if that is invalid.
 func (s *Store) SetDefinition(def *api.Definition) error {
+	if err := def.Migrate(); err != nil {
+		return err
+	}
 	if _, err := def.Validate(); err != nil {
 		return err
 	}
+	if err := plugin.Validate(def); err != nil {
+		return err
+	}
 
 	s.Lock()
 	defer s.Unlock()
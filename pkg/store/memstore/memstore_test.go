@@ -54,4 +54,24 @@ func TestStore_SetDefinition(t *testing.T) {
 		assert.Error(t, err)
 		assert.Nil(t, s.def)
 	})
+
+	t.Run("should be no set api.Definition if plugin is not found", func(t *testing.T) {
+		s := New()
+		err := s.SetDefinition(&api.Definition{
+			Apis: []*api.Api{
+				{
+					Name: "test",
+					Proxy: &api.Proxy{
+						Path: "/test",
+						Upstream: &api.Upstream{
+							Target: "http://localhost:8080",
+						},
+					},
+					Plugins: []*api.Plugin{{Name: "unknown"}},
+				},
+			},
+		})
+		assert.Error(t, err)
+		assert.Nil(t, s.def)
+	})
 }
@@ -0,0 +1,118 @@
+package upstream
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Balancing policies
+const (
+	RoundRobin         = "roundRobin"
+	WeightedRoundRobin = "weightedRoundRobin"
+	LeastConn          = "leastConn"
+	Random             = "random"
+	P2C                = "p2c"
+)
+
+// Balancer elects a target from the candidates.
+// candidates is never empty.
+type Balancer interface {
+	Elect(candidates []*Target) *Target
+}
+
+// NewBalancer creates a balancer of the policy name.
+// round robin is used if name is empty.
+func NewBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", RoundRobin:
+		return &roundRobin{}, nil
+	case WeightedRoundRobin:
+		return &weightedRoundRobin{current: make(map[*Target]int)}, nil
+	case LeastConn:
+		return &leastConn{}, nil
+	case Random:
+		return &random{}, nil
+	case P2C:
+		return &p2c{}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("The selected balancing is not supported. name: %s", name))
+	}
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (b *roundRobin) Elect(candidates []*Target) *Target {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobin is the smooth weighted round robin used by nginx.
+type weightedRoundRobin struct {
+	sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobin) Elect(candidates []*Target) *Target {
+	b.Lock()
+	defer b.Unlock()
+
+	var best *Target
+	total := 0
+	for _, t := range candidates {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+type leastConn struct {
+	roundRobin
+}
+
+func (b *leastConn) Elect(candidates []*Target) *Target {
+	// start from a rotating offset so that ties are spread out
+	offset := int(atomic.AddUint64(&b.next, 1) % uint64(len(candidates)))
+	var best *Target
+	for i := range candidates {
+		t := candidates[(offset+i)%len(candidates)]
+		if best == nil || t.ActiveRequests() < best.ActiveRequests() {
+			best = t
+		}
+	}
+	return best
+}
+
+type random struct{}
+
+func (b *random) Elect(candidates []*Target) *Target {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// p2c picks two random targets and chooses the one with fewer in-flight requests.
+type p2c struct{}
+
+func (b *p2c) Elect(candidates []*Target) *Target {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, c := candidates[i], candidates[j]
+	if c.ActiveRequests() < a.ActiveRequests() {
+		return c
+	}
+	return a
+}
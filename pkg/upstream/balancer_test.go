@@ -0,0 +1,94 @@
+package upstream
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTargets(weights ...int) []*Target {
+	targets := make([]*Target, 0, len(weights))
+	for _, w := range weights {
+		targets = append(targets, &Target{URL: &url.URL{}, Weight: w})
+	}
+	return targets
+}
+
+func TestNewBalancer(t *testing.T) {
+	t.Run("should be round robin if name is empty", func(t *testing.T) {
+		b, err := NewBalancer("")
+		assert.NoError(t, err)
+		assert.IsType(t, &roundRobin{}, b)
+	})
+
+	t.Run("should be return error if name is unknown", func(t *testing.T) {
+		b, err := NewBalancer("unknown")
+		assert.Error(t, err)
+		assert.Nil(t, b)
+	})
+}
+
+func TestRoundRobin_Elect(t *testing.T) {
+	t.Run("should be elect targets in order", func(t *testing.T) {
+		targets := newTargets(1, 1, 1)
+		b, _ := NewBalancer(RoundRobin)
+		for i := 0; i < 6; i++ {
+			assert.Same(t, targets[i%3], b.Elect(targets))
+		}
+	})
+}
+
+func TestWeightedRoundRobin_Elect(t *testing.T) {
+	t.Run("should be elect targets in proportion to weight", func(t *testing.T) {
+		targets := newTargets(5, 1, 1)
+		b, _ := NewBalancer(WeightedRoundRobin)
+		got := make(map[*Target]int)
+		for i := 0; i < 70; i++ {
+			got[b.Elect(targets)]++
+		}
+		assert.Equal(t, 50, got[targets[0]])
+		assert.Equal(t, 10, got[targets[1]])
+		assert.Equal(t, 10, got[targets[2]])
+	})
+
+	t.Run("should be interleave targets smoothly", func(t *testing.T) {
+		targets := newTargets(2, 1)
+		b, _ := NewBalancer(WeightedRoundRobin)
+		assert.Same(t, targets[0], b.Elect(targets))
+		assert.Same(t, targets[1], b.Elect(targets))
+		assert.Same(t, targets[0], b.Elect(targets))
+	})
+}
+
+func TestLeastConn_Elect(t *testing.T) {
+	t.Run("should be elect the target with the fewest active requests", func(t *testing.T) {
+		targets := newTargets(1, 1, 1)
+		targets[0].Acquire()
+		targets[2].Acquire()
+		targets[2].Acquire()
+		b, _ := NewBalancer(LeastConn)
+		for i := 0; i < 3; i++ {
+			assert.Same(t, targets[1], b.Elect(targets))
+		}
+	})
+}
+
+func TestP2C_Elect(t *testing.T) {
+	t.Run("should be never elect the busiest of two targets", func(t *testing.T) {
+		targets := newTargets(1, 1)
+		targets[0].Acquire()
+		b, _ := NewBalancer(P2C)
+		for i := 0; i < 10; i++ {
+			assert.Same(t, targets[1], b.Elect(targets))
+		}
+	})
+}
+
+func TestRandom_Elect(t *testing.T) {
+	t.Run("should be elect one of the candidates", func(t *testing.T) {
+		targets := newTargets(1, 1, 1)
+		b, _ := NewBalancer(Random)
+		assert.Contains(t, targets, b.Elect(targets))
+	})
+}
@@ -0,0 +1,185 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	pstats "github.com/purini-to/plixy/pkg/stats"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckTimeout   = 2 * time.Second
+	defaultHealthyThreshold     = 2
+	defaultUnhealthyThreshold   = 3
+	healthCheckUserAgent        = "plixy-health-check"
+	healthCheckMaxDiscardLength = 4096
+)
+
+var probeTransport atomic.Value
+
+type roundTripper struct {
+	http.RoundTripper
+}
+
+// SetProbeTransport sets the transport of the health check requests.
+// The upstream is set in the context of the requests so that the transport can use the settings of the upstream.
+func SetProbeTransport(rt http.RoundTripper) {
+	probeTransport.Store(roundTripper{rt})
+}
+
+func getProbeTransport() http.RoundTripper {
+	if rt, ok := probeTransport.Load().(roundTripper); ok {
+		return rt.RoundTripper
+	}
+	return http.DefaultTransport
+}
+
+type healthChecker struct {
+	upstream           *Upstream
+	path               string
+	interval           time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	client             *http.Client
+	cancel             context.CancelFunc
+	wg                 sync.WaitGroup
+}
+
+func newHealthChecker(u *Upstream, def *api.HealthCheck) *healthChecker {
+	c := &healthChecker{
+		upstream:           u,
+		path:               def.Path,
+		interval:           def.Interval,
+		healthyThreshold:   def.HealthyThreshold,
+		unhealthyThreshold: def.UnhealthyThreshold,
+	}
+	if c.interval <= 0 {
+		c.interval = defaultHealthCheckInterval
+	}
+	if c.healthyThreshold <= 0 {
+		c.healthyThreshold = defaultHealthyThreshold
+	}
+	if c.unhealthyThreshold <= 0 {
+		c.unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	timeout := def.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	c.client = &http.Client{
+		Transport: getProbeTransport(),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c
+}
+
+func (c *healthChecker) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	for _, t := range c.upstream.targets {
+		c.wg.Add(1)
+		go c.watch(ctx, t)
+	}
+}
+
+func (c *healthChecker) stop() {
+	c.cancel()
+	c.wg.Wait()
+}
+
+func (c *healthChecker) watch(ctx context.Context, t *Target) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	successes, failures := 0, 0
+	for {
+		if c.check(ctx, t) {
+			successes, failures = successes+1, 0
+			if successes >= c.healthyThreshold {
+				c.mark(ctx, t, true)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if failures >= c.unhealthyThreshold {
+				c.mark(ctx, t, false)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *healthChecker) check(ctx context.Context, t *Target) bool {
+	u := &url.URL{Scheme: t.URL.Scheme, Host: t.URL.Host, Path: c.path}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", healthCheckUserAgent)
+
+	res, err := c.client.Do(req.WithContext(ToContext(ctx, c.upstream)))
+	if err != nil {
+		log.Debug("Failed upstream health check",
+			zap.String("name", c.upstream.name), zap.String("target", t.URL.Host), zap.Error(err))
+		return false
+	}
+	defer res.Body.Close()
+	_, _ = io.CopyN(ioutil.Discard, res.Body, healthCheckMaxDiscardLength)
+
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+func (c *healthChecker) mark(ctx context.Context, t *Target, healthy bool) {
+	if ctx.Err() != nil {
+		return
+	}
+	if t.setHealthy(healthy) {
+		fields := []zap.Field{zap.String("name", c.upstream.name), zap.String("target", t.URL.Host)}
+		if healthy {
+			log.Info("Upstream target became healthy", fields...)
+		} else {
+			log.Warn("Upstream target became unhealthy", fields...)
+		}
+	}
+	recordHealthy(c.upstream.name, t)
+}
+
+func recordHealthy(name string, t *Target) {
+	if !config.Global.Stats.Enable {
+		return
+	}
+	ctx, err := tag.New(context.Background(),
+		tag.Upsert(pstats.KeyApiName, name),
+		tag.Upsert(pstats.KeyTarget, t.URL.Host),
+	)
+	if err != nil {
+		return
+	}
+	var v int64
+	if t.Healthy() {
+		v = 1
+	}
+	stats.Record(ctx, pstats.UpstreamTargetHealthy.M(v))
+}
@@ -0,0 +1,69 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func TestUpstream_HealthCheck(t *testing.T) {
+	var status int32 = http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/healthz", r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer ts.Close()
+
+	up, err := New("test", &api.Upstream{
+		Targets: []*api.Target{
+			{Target: ts.URL, Weight: 1},
+			{Target: "http://127.0.0.1:1", Weight: 1},
+		},
+		HealthCheck: &api.HealthCheck{
+			Path:               "/healthz",
+			Interval:           10 * time.Millisecond,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+	})
+	assert.NoError(t, err)
+	defer up.Close()
+
+	t.Run("should be remove unhealthy target from selection", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			return !up.Targets()[1].Healthy()
+		}, time.Second, 10*time.Millisecond)
+		for i := 0; i < 4; i++ {
+			got, err := up.Next(context.Background())
+			assert.NoError(t, err)
+			assert.Same(t, up.Targets()[0], got)
+		}
+	})
+
+	t.Run("should be return error if all targets are unhealthy", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		assert.Eventually(t, func() bool {
+			return !up.Targets()[0].Healthy()
+		}, time.Second, 10*time.Millisecond)
+		got, err := up.Next(context.Background())
+		assert.Equal(t, ErrNoTarget, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("should be return to selection if target recovers", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusOK)
+		assert.Eventually(t, func() bool {
+			return up.Targets()[0].Healthy()
+		}, time.Second, 10*time.Millisecond)
+		got, err := up.Next(context.Background())
+		assert.NoError(t, err)
+		assert.Same(t, up.Targets()[0], got)
+	})
+}
@@ -0,0 +1,50 @@
+package upstream
+
+import "context"
+
+type hookKeyType int
+
+const hookContextKey hookKeyType = iota
+
+// Hook intercepts the election of a target for a request.
+type Hook interface {
+	// Allow reports whether the target can be elected.
+	Allow(t *Target) bool
+	// Elected is called with the elected target.
+	Elected(t *Target)
+	// Done is called with the status of the attempt to the elected target.
+	// The status is 502 or 504 if the target did not respond.
+	Done(t *Target, status int)
+}
+
+// HookToContext adds the hook to the hooks of the context.
+func HookToContext(ctx context.Context, h Hook) context.Context {
+	hooks := hooksFromContext(ctx)
+	newHooks := make([]Hook, 0, len(hooks)+1)
+	newHooks = append(newHooks, hooks...)
+	newHooks = append(newHooks, h)
+	return context.WithValue(ctx, hookContextKey, newHooks)
+}
+
+// Done passes the status of the attempt to the target to the hooks of the context.
+func Done(ctx context.Context, t *Target, status int) {
+	for _, h := range hooksFromContext(ctx) {
+		h.Done(t, status)
+	}
+}
+
+func hooksFromContext(ctx context.Context) []Hook {
+	if hooks, ok := ctx.Value(hookContextKey).([]Hook); ok {
+		return hooks
+	}
+	return nil
+}
+
+func allowed(hooks []Hook, t *Target) bool {
+	for _, h := range hooks {
+		if !h.Allow(t) {
+			return false
+		}
+	}
+	return true
+}
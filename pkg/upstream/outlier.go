@@ -0,0 +1,156 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/purini-to/plixy/pkg/api"
+	"github.com/purini-to/plixy/pkg/config"
+	"github.com/purini-to/plixy/pkg/log"
+	pstats "github.com/purini-to/plixy/pkg/stats"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultConsecutiveFailures = 5
+	defaultBaseEjectionTime    = 30 * time.Second
+	defaultMaxEjectionTime     = 300 * time.Second
+)
+
+type outlierDetector struct {
+	name                string
+	consecutiveFailures int
+	baseEjectionTime    time.Duration
+	maxEjectionTime     time.Duration
+}
+
+func newOutlierDetector(name string, def *api.Outlier) *outlierDetector {
+	d := &outlierDetector{
+		name:                name,
+		consecutiveFailures: def.ConsecutiveFailures,
+		baseEjectionTime:    def.BaseEjectionTime,
+		maxEjectionTime:     def.MaxEjectionTime,
+	}
+	if d.consecutiveFailures <= 0 {
+		d.consecutiveFailures = defaultConsecutiveFailures
+	}
+	if d.baseEjectionTime <= 0 {
+		d.baseEjectionTime = defaultBaseEjectionTime
+	}
+	if d.maxEjectionTime <= 0 {
+		d.maxEjectionTime = defaultMaxEjectionTime
+	}
+	if d.maxEjectionTime < d.baseEjectionTime {
+		d.maxEjectionTime = d.baseEjectionTime
+	}
+	return d
+}
+
+// ejectionTime returns the ejection time that doubles for each ejection.
+func (d *outlierDetector) ejectionTime(ejections int) time.Duration {
+	t := d.baseEjectionTime
+	for i := 1; i < ejections && t < d.maxEjectionTime; i++ {
+		t *= 2
+	}
+	if t > d.maxEjectionTime {
+		t = d.maxEjectionTime
+	}
+	return t
+}
+
+// outlier is the passive health state of a target.
+type outlier struct {
+	sync.Mutex
+	detector     *outlierDetector
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func (o *outlier) ejected(now time.Time) bool {
+	o.Lock()
+	defer o.Unlock()
+	return now.Before(o.ejectedUntil)
+}
+
+func (o *outlier) success() {
+	o.Lock()
+	defer o.Unlock()
+	o.failures = 0
+}
+
+// failure counts up the consecutive failures and returns the ejection time
+// if the target should be ejected.
+func (o *outlier) failure(now time.Time) (time.Duration, bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	if now.Before(o.ejectedUntil) {
+		// in-flight requests sent before the ejection
+		return 0, false
+	}
+	o.failures++
+	if o.failures < o.detector.consecutiveFailures {
+		return 0, false
+	}
+
+	// forget the past ejections if the target has been stable for a while
+	if !o.ejectedUntil.IsZero() && now.Sub(o.ejectedUntil) > o.detector.maxEjectionTime {
+		o.ejections = 0
+	}
+	o.ejections++
+	o.failures = 0
+	d := o.detector.ejectionTime(o.ejections)
+	o.ejectedUntil = now.Add(d)
+	return d, true
+}
+
+// ReportSuccess reports that the target responded successfully.
+func (t *Target) ReportSuccess() {
+	if t.outlier == nil {
+		return
+	}
+	t.outlier.success()
+}
+
+// ReportFailure reports that the target failed to respond.
+// The target is ejected from selection after consecutive failures.
+func (t *Target) ReportFailure() {
+	if t.outlier == nil {
+		return
+	}
+	d, ejected := t.outlier.failure(time.Now())
+	if !ejected {
+		return
+	}
+
+	name := t.outlier.detector.name
+	log.Warn("Upstream target ejected",
+		zap.String("name", name), zap.String("target", t.URL.Host), zap.Duration("duration", d))
+	recordEjection(name, t)
+}
+
+// Ejected reports whether the target is ejected by the passive health check.
+func (t *Target) Ejected() bool {
+	if t.outlier == nil {
+		return false
+	}
+	return t.outlier.ejected(time.Now())
+}
+
+func recordEjection(name string, t *Target) {
+	if !config.Global.Stats.Enable {
+		return
+	}
+	ctx, err := tag.New(context.Background(),
+		tag.Upsert(pstats.KeyApiName, name),
+		tag.Upsert(pstats.KeyTarget, t.URL.Host),
+	)
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, pstats.UpstreamTargetEjectionCount.M(1))
+}
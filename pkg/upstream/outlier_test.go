@@ -0,0 +1,110 @@
+package upstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func TestOutlierDetector_ejectionTime(t *testing.T) {
+	d := newOutlierDetector("test", &api.Outlier{
+		BaseEjectionTime: 10 * time.Second,
+		MaxEjectionTime:  60 * time.Second,
+	})
+
+	assert.Equal(t, 10*time.Second, d.ejectionTime(1))
+	assert.Equal(t, 20*time.Second, d.ejectionTime(2))
+	assert.Equal(t, 40*time.Second, d.ejectionTime(3))
+	assert.Equal(t, 60*time.Second, d.ejectionTime(4))
+	assert.Equal(t, 60*time.Second, d.ejectionTime(100))
+}
+
+func TestOutlier_failure(t *testing.T) {
+	newOutlier := func() *outlier {
+		return &outlier{detector: newOutlierDetector("test", &api.Outlier{
+			ConsecutiveFailures: 3,
+			BaseEjectionTime:    10 * time.Second,
+			MaxEjectionTime:     60 * time.Second,
+		})}
+	}
+	now := time.Now()
+
+	t.Run("should be ejected after consecutive failures", func(t *testing.T) {
+		o := newOutlier()
+		for i := 0; i < 2; i++ {
+			_, ejected := o.failure(now)
+			assert.False(t, ejected)
+		}
+		d, ejected := o.failure(now)
+		assert.True(t, ejected)
+		assert.Equal(t, 10*time.Second, d)
+		assert.True(t, o.ejected(now))
+		assert.False(t, o.ejected(now.Add(10*time.Second)))
+	})
+
+	t.Run("should be reset consecutive failures by success", func(t *testing.T) {
+		o := newOutlier()
+		o.failure(now)
+		o.failure(now)
+		o.success()
+		_, ejected := o.failure(now)
+		assert.False(t, ejected)
+	})
+
+	t.Run("should be double the ejection time if failed again after re-admission", func(t *testing.T) {
+		o := newOutlier()
+		for i := 0; i < 3; i++ {
+			o.failure(now)
+		}
+		readmitted := now.Add(10 * time.Second)
+		var d time.Duration
+		for i := 0; i < 3; i++ {
+			d, _ = o.failure(readmitted)
+		}
+		assert.Equal(t, 20*time.Second, d)
+	})
+
+	t.Run("should be reset the ejection time if stable longer than max ejection time", func(t *testing.T) {
+		o := newOutlier()
+		for i := 0; i < 3; i++ {
+			o.failure(now)
+		}
+		later := now.Add(10*time.Second + 61*time.Second)
+		var d time.Duration
+		for i := 0; i < 3; i++ {
+			d, _ = o.failure(later)
+		}
+		assert.Equal(t, 10*time.Second, d)
+	})
+}
+
+func TestUpstream_Next_outlier(t *testing.T) {
+	up, err := New("test", &api.Upstream{
+		Targets: []*api.Target{
+			{Target: "http://localhost:9001", Weight: 1},
+			{Target: "http://localhost:9002", Weight: 1},
+		},
+		Outlier: &api.Outlier{ConsecutiveFailures: 1},
+	})
+	assert.NoError(t, err)
+
+	t.Run("should be skip ejected target", func(t *testing.T) {
+		up.Targets()[0].ReportFailure()
+		for i := 0; i < 4; i++ {
+			got, err := up.Next(context.Background())
+			assert.NoError(t, err)
+			assert.Same(t, up.Targets()[1], got)
+		}
+	})
+
+	t.Run("should be ignore ejection if all targets are ejected", func(t *testing.T) {
+		up.Targets()[1].ReportFailure()
+		got, err := up.Next(context.Background())
+		assert.NoError(t, err)
+		assert.NotNil(t, got)
+	})
+}
@@ -0,0 +1,71 @@
+package upstream
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// rewriter rewrites the request path in the order of
+// strip prefix, regex rewrite and add prefix.
+type rewriter struct {
+	stripPrefix string
+	addPrefix   string
+	reg         *regexp.Regexp
+	replacement string
+}
+
+func newRewriter(def *api.Upstream) (*rewriter, error) {
+	rw := &rewriter{
+		stripPrefix: strings.TrimSuffix(def.StripPrefix, "/"),
+		addPrefix:   def.AddPrefix,
+	}
+	if def.Rewrite != nil {
+		reg, err := regexp.Compile(def.Rewrite.Regex)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not compile rewrite regex. regex: %s", def.Rewrite.Regex))
+		}
+		rw.reg = reg
+		rw.replacement = def.Rewrite.Replacement
+	}
+	return rw, nil
+}
+
+func (rw *rewriter) rewrite(path string) string {
+	// strip only whole segments, so "/api" does not strip "/apis"
+	if rw.stripPrefix != "" && (path == rw.stripPrefix || strings.HasPrefix(path, rw.stripPrefix+"/")) {
+		path = path[len(rw.stripPrefix):]
+		if path == "" {
+			path = "/"
+		}
+	}
+	if rw.reg != nil {
+		path = rw.reg.ReplaceAllString(path, rw.replacement)
+	}
+	if rw.addPrefix != "" {
+		if path == "/" {
+			return rw.addPrefix
+		}
+		path = JoinPath(rw.addPrefix, path)
+	}
+	return path
+}
+
+// JoinPath joins the paths with a single slash.
+func JoinPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case b == "":
+		return a
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,70 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func TestRewriter_Rewrite(t *testing.T) {
+	tests := []struct {
+		name string
+		def  *api.Upstream
+		path string
+		want string
+	}{
+		{name: "should be keep path without rules", def: &api.Upstream{}, path: "/users/1", want: "/users/1"},
+		{name: "should be strip prefix", def: &api.Upstream{StripPrefix: "/api"}, path: "/api/users/1", want: "/users/1"},
+		{name: "should be strip prefix with trailing slash", def: &api.Upstream{StripPrefix: "/api/"}, path: "/api/users", want: "/users"},
+		{name: "should be strip prefix to root", def: &api.Upstream{StripPrefix: "/api"}, path: "/api", want: "/"},
+		{name: "should be not strip partial segment", def: &api.Upstream{StripPrefix: "/api"}, path: "/apis/users", want: "/apis/users"},
+		{name: "should be add prefix", def: &api.Upstream{AddPrefix: "/v1/"}, path: "/users", want: "/v1/users"},
+		{name: "should be add prefix to root", def: &api.Upstream{StripPrefix: "/api", AddPrefix: "/v1"}, path: "/api", want: "/v1"},
+		{
+			name: "should be rewrite with capture groups",
+			def:  &api.Upstream{Rewrite: &api.Rewrite{Regex: `^/users/(\d+)/orders$`, Replacement: "/orders/user/$1"}},
+			path: "/users/12/orders",
+			want: "/orders/user/12",
+		},
+		{
+			name: "should be apply rules in order of strip, rewrite and add",
+			def: &api.Upstream{
+				StripPrefix: "/api",
+				Rewrite:     &api.Rewrite{Regex: `^/users/(?P<id>\d+)`, Replacement: "/members/${id}"},
+				AddPrefix:   "/v2",
+			},
+			path: "/api/users/12/orders",
+			want: "/v2/members/12/orders",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := newRewriter(tt.def)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, rw.rewrite(tt.path))
+		})
+	}
+
+	t.Run("should be return error if regex is invalid", func(t *testing.T) {
+		_, err := newRewriter(&api.Upstream{Rewrite: &api.Rewrite{Regex: "("}})
+		assert.Error(t, err)
+	})
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{a: "", b: "/users", want: "/users"},
+		{a: "/apis/v1", b: "/users", want: "/apis/v1/users"},
+		{a: "/apis/v1/", b: "/users", want: "/apis/v1/users"},
+		{a: "/apis/v1", b: "users", want: "/apis/v1/users"},
+		{a: "/apis/v1", b: "", want: "/apis/v1"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, JoinPath(tt.a, tt.b))
+	}
+}
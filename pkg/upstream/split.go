@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+// Split selects the upstream of the request by the weights of the split backends.
+// The first upstream is the upstream of the proxy and receives the rest of the weights.
+type Split struct {
+	upstreams []*Upstream
+	bounds    []uint32
+	sticky    *api.Sticky
+}
+
+// NewSplit creates the upstreams of the proxy.
+// The upstreams of the backends are named "<api name>/<backend name>".
+func NewSplit(name string, p *api.Proxy) (*Split, error) {
+	up, err := New(name, p.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	s := &Split{upstreams: []*Upstream{up}}
+	if p.Split == nil {
+		return s, nil
+	}
+
+	s.sticky = p.Split.Sticky
+	rest := 100
+	for _, b := range p.Split.Backends {
+		rest -= b.Weight
+	}
+	bound := uint32(rest)
+	s.bounds = append(s.bounds, bound)
+	for _, b := range p.Split.Backends {
+		up, err := New(fmt.Sprintf("%s/%s", name, b.Name), b.Upstream)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		bound += uint32(b.Weight)
+		s.upstreams = append(s.upstreams, up)
+		s.bounds = append(s.bounds, bound)
+	}
+	return s, nil
+}
+
+// Select returns the upstream of the request.
+// The request is distributed at random unless it has the sticky key.
+func (s *Split) Select(r *http.Request) *Upstream {
+	if len(s.upstreams) == 1 {
+		return s.upstreams[0]
+	}
+
+	var n uint32
+	if key, ok := s.stickyKey(r); ok {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		n = h.Sum32() % 100
+	} else {
+		n = uint32(rand.Intn(100))
+	}
+	for i, b := range s.bounds {
+		if n < b {
+			return s.upstreams[i]
+		}
+	}
+	return s.upstreams[0]
+}
+
+func (s *Split) stickyKey(r *http.Request) (string, bool) {
+	switch {
+	case s.sticky == nil:
+		return "", false
+	case s.sticky.Header != "":
+		v := r.Header.Get(s.sticky.Header)
+		return v, v != ""
+	case s.sticky.Cookie != "":
+		c, err := r.Cookie(s.sticky.Cookie)
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return c.Value, true
+	case s.sticky.ClientIP:
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		return ip, ip != ""
+	}
+	return "", false
+}
+
+// Upstreams returns all upstreams of the split.
+func (s *Split) Upstreams() []*Upstream {
+	return s.upstreams
+}
+
+// Close stops the health checking of all upstreams.
+func (s *Split) Close() error {
+	for _, up := range s.upstreams {
+		if err := up.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
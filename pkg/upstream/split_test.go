@@ -0,0 +1,106 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+func newSplitProxy(weight int, sticky *api.Sticky) *api.Proxy {
+	return &api.Proxy{
+		Upstream: &api.Upstream{Target: "http://v1.test"},
+		Split: &api.Split{
+			Backends: []*api.Backend{{Name: "v2", Weight: weight, Upstream: &api.Upstream{Target: "http://v2.test"}}},
+			Sticky:   sticky,
+		},
+	}
+}
+
+func TestSplit_Select(t *testing.T) {
+	t.Run("should be select the upstream of the proxy if there is no split", func(t *testing.T) {
+		s, err := NewSplit("test", &api.Proxy{Upstream: &api.Upstream{Target: "http://v1.test"}})
+		assert.NoError(t, err)
+		defer s.Close()
+		assert.Equal(t, "test", s.Select(httptest.NewRequest("GET", "/", nil)).Name())
+	})
+
+	t.Run("should be split requests by weights", func(t *testing.T) {
+		s, err := NewSplit("test", newSplitProxy(20, nil))
+		assert.NoError(t, err)
+		defer s.Close()
+		assert.Equal(t, 2, len(s.Upstreams()))
+
+		counts := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			counts[s.Select(httptest.NewRequest("GET", "/", nil)).Name()]++
+		}
+		assert.InDelta(t, 8000, counts["test"], 500)
+		assert.InDelta(t, 2000, counts["test/v2"], 500)
+	})
+
+	t.Run("should be select all requests to the backend of weight 100", func(t *testing.T) {
+		s, err := NewSplit("test", newSplitProxy(100, nil))
+		assert.NoError(t, err)
+		defer s.Close()
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, "test/v2", s.Select(httptest.NewRequest("GET", "/", nil)).Name())
+		}
+	})
+
+	stickyTests := []struct {
+		name   string
+		sticky *api.Sticky
+		req    func(key string) *http.Request
+	}{
+		{
+			name:   "header",
+			sticky: &api.Sticky{Header: "X-User-Id"},
+			req: func(key string) *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("X-User-Id", key)
+				return r
+			},
+		},
+		{
+			name:   "cookie",
+			sticky: &api.Sticky{Cookie: "user"},
+			req: func(key string) *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.AddCookie(&http.Cookie{Name: "user", Value: key})
+				return r
+			},
+		},
+		{
+			name:   "client ip",
+			sticky: &api.Sticky{ClientIP: true},
+			req: func(key string) *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = key + ":12345"
+				return r
+			},
+		},
+	}
+	for _, tt := range stickyTests {
+		t.Run(fmt.Sprintf("should be select the same upstream by the %s", tt.name), func(t *testing.T) {
+			s, err := NewSplit("test", newSplitProxy(50, tt.sticky))
+			assert.NoError(t, err)
+			defer s.Close()
+
+			counts := make(map[string]int)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("10.0.0.%d", i)
+				want := s.Select(tt.req(key))
+				counts[want.Name()]++
+				for j := 0; j < 5; j++ {
+					assert.Same(t, want, s.Select(tt.req(key)))
+				}
+			}
+			assert.Equal(t, 2, len(counts))
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/purini-to/plixy/pkg/api"
+)
+
+type upstreamKeyType int
+
+const (
+	upstreamContextKey upstreamKeyType = iota
+	targetContextKey
+)
+
+// ErrNoTarget is returned when there is no target that can receive a request.
+var ErrNoTarget = errors.New("no available upstream target")
+
+// Target represents a backend server of the upstream.
+type Target struct {
+	URL     *url.URL
+	Weight  int
+	active  int64
+	healthy int32
+	outlier *outlier
+	// vars reports whether the path has the deprecated path variables such as `/users/{id}`.
+	vars bool
+}
+
+// Path returns the path of the target with the path variables expanded by the variables of the request.
+// The variables not found are left as is.
+func (t *Target) Path(ctx context.Context) string {
+	if !t.vars {
+		return t.URL.Path
+	}
+	path := t.URL.Path
+	for k, v := range api.VarsFromContext(ctx) {
+		path = strings.Replace(path, "{"+k+"}", v, 1)
+	}
+	return path
+}
+
+// Healthy reports whether the target passes the active health check.
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.healthy) == 1
+}
+
+func (t *Target) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&t.healthy, v) != v
+}
+
+// Available reports whether the target can receive requests.
+func (t *Target) Available() bool {
+	return t.Healthy() && !t.Ejected()
+}
+
+// Acquire marks the start of a request to the target.
+func (t *Target) Acquire() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+// Release marks the end of a request to the target.
+func (t *Target) Release() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+// ActiveRequests returns the number of in-flight requests to the target.
+func (t *Target) ActiveRequests() int64 {
+	return atomic.LoadInt64(&t.active)
+}
+
+// Upstream is the runtime state of an api upstream.
+type Upstream struct {
+	name     string
+	def      *api.Upstream
+	targets  []*Target
+	balancer Balancer
+	rewriter *rewriter
+	checker  *healthChecker
+}
+
+// New creates an upstream from the api definition.
+// Active health checking is started if the definition has it.
+func New(name string, def *api.Upstream) (*Upstream, error) {
+	b, err := NewBalancer(def.Balancing)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := newRewriter(def)
+	if err != nil {
+		return nil, err
+	}
+
+	var detector *outlierDetector
+	if def.Outlier != nil {
+		detector = newOutlierDetector(name, def.Outlier)
+	}
+
+	targets := make([]*Target, 0)
+	for _, t := range def.AllTargets() {
+		u, err := url.Parse(t.Target)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse upstream target. target: %s", t.Target))
+		}
+		weight := t.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		target := &Target{
+			URL:     u,
+			Weight:  weight,
+			healthy: 1,
+			vars:    strings.ContainsAny(u.Path, "{}"),
+		}
+		if detector != nil {
+			target.outlier = &outlier{detector: detector}
+		}
+		targets = append(targets, target)
+	}
+
+	up := &Upstream{
+		name:     name,
+		def:      def,
+		targets:  targets,
+		balancer: b,
+		rewriter: rw,
+	}
+	if def.HealthCheck != nil {
+		up.checker = newHealthChecker(up, def.HealthCheck)
+		up.checker.start()
+	}
+	return up, nil
+}
+
+// Name returns the api name of the upstream.
+func (u *Upstream) Name() string {
+	return u.name
+}
+
+// Definition returns the upstream definition.
+func (u *Upstream) Definition() *api.Upstream {
+	return u.def
+}
+
+// Close stops the health checking.
+func (u *Upstream) Close() error {
+	if u.checker != nil {
+		u.checker.stop()
+	}
+	return nil
+}
+
+// RewritePath rewrites the request path by the rules of the upstream.
+func (u *Upstream) RewritePath(path string) string {
+	return u.rewriter.rewrite(path)
+}
+
+// Targets returns all targets of the upstream.
+func (u *Upstream) Targets() []*Target {
+	return u.targets
+}
+
+// Next elects a target to send the request.
+// The hooks of the context can exclude targets from the election.
+func (u *Upstream) Next(ctx context.Context) (*Target, error) {
+	hooks := hooksFromContext(ctx)
+	candidates := u.available(hooks)
+	if len(candidates) == 0 {
+		return nil, ErrNoTarget
+	}
+
+	target := candidates[0]
+	if len(candidates) > 1 {
+		target = u.balancer.Elect(candidates)
+	}
+	for _, h := range hooks {
+		h.Elected(target)
+	}
+	return target, nil
+}
+
+// available returns the targets that can receive requests.
+// If every healthy target is ejected, the ejection is ignored so that
+// the passive health check does not cause a total outage.
+func (u *Upstream) available(hooks []Hook) []*Target {
+	candidates := u.filter(func(t *Target) bool {
+		return t.Available() && allowed(hooks, t)
+	})
+	if len(candidates) > 0 {
+		return candidates
+	}
+	return u.filter(func(t *Target) bool {
+		return t.Healthy() && allowed(hooks, t)
+	})
+}
+
+func (u *Upstream) filter(fn func(t *Target) bool) []*Target {
+	for i, t := range u.targets {
+		if fn(t) {
+			continue
+		}
+		// copy only if there is an unavailable target
+		candidates := make([]*Target, 0, len(u.targets)-1)
+		candidates = append(candidates, u.targets[:i]...)
+		for _, t := range u.targets[i+1:] {
+			if fn(t) {
+				candidates = append(candidates, t)
+			}
+		}
+		return candidates
+	}
+	return u.targets
+}
+
+func ToContext(ctx context.Context, u *Upstream) context.Context {
+	return context.WithValue(ctx, upstreamContextKey, u)
+}
+
+func FromContext(ctx context.Context) *Upstream {
+	if u, ok := ctx.Value(upstreamContextKey).(*Upstream); ok {
+		return u
+	}
+	return nil
+}
+
+func TargetToContext(ctx context.Context, t *Target) context.Context {
+	return context.WithValue(ctx, targetContextKey, t)
+}
+
+func TargetFromContext(ctx context.Context) *Target {
+	if t, ok := ctx.Value(targetContextKey).(*Target); ok {
+		return t
+	}
+	return nil
+}